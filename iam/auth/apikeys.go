@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"iam/store"
+)
+
+// APIKeyDTO for creating API keys
+type APIKeyDTO struct {
+	Name      string   `json:"name" binding:"required"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in"` // Go duration, e.g. "720h"; empty for no expiry
+}
+
+// Keys look like iam_<prefix>_<secret>
+const APIKeyPrefix = "iam_"
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// CreateAPIKey issues a new API key for the user and returns it with the
+// plain text key
+func (s *Service) CreateAPIKey(userID uint, keyDTO APIKeyDTO) (store.APIKey, string, error) {
+	var expiresAt *time.Time
+	if keyDTO.ExpiresIn != "" {
+		d, err := time.ParseDuration(keyDTO.ExpiresIn)
+		if err != nil || d <= 0 {
+			return store.APIKey{}, "", errors.New("invalid expires_in duration")
+		}
+		t := time.Now().Add(d)
+		expiresAt = &t
+	}
+
+	prefix := make([]byte, 6)
+	if _, err := rand.Read(prefix); err != nil {
+		return store.APIKey{}, "", err
+	}
+	secret, err := RandomToken(32)
+	if err != nil {
+		return store.APIKey{}, "", err
+	}
+
+	key := store.APIKey{
+		UserID:     userID,
+		Name:       keyDTO.Name,
+		Prefix:     hex.EncodeToString(prefix),
+		SecretHash: HashToken(secret),
+		Scopes:     strings.Join(keyDTO.Scopes, " "),
+		ExpiresAt:  expiresAt,
+	}
+	if err := s.db.Create(&key).Error; err != nil {
+		return store.APIKey{}, "", err
+	}
+
+	return key, APIKeyPrefix + key.Prefix + "_" + secret, nil
+}
+
+// AuthenticateAPIKey resolves an API key to the key and its owner and
+// records its use
+func (s *Service) AuthenticateAPIKey(rawKey, ip string) (store.APIKey, store.User, error) {
+	if !strings.HasPrefix(rawKey, APIKeyPrefix) {
+		return store.APIKey{}, store.User{}, ErrInvalidAPIKey
+	}
+	prefix, secret, found := strings.Cut(strings.TrimPrefix(rawKey, APIKeyPrefix), "_")
+	if !found {
+		return store.APIKey{}, store.User{}, ErrInvalidAPIKey
+	}
+
+	var key store.APIKey
+	if result := s.db.Where("prefix = ?", prefix).First(&key); result.Error != nil {
+		return store.APIKey{}, store.User{}, ErrInvalidAPIKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(key.SecretHash)) != 1 ||
+		key.RevokedAt != nil ||
+		(key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt)) {
+		return store.APIKey{}, store.User{}, ErrInvalidAPIKey
+	}
+
+	var user store.User
+	if result := s.db.First(&user, key.UserID); result.Error != nil || user.Disabled {
+		return store.APIKey{}, store.User{}, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	s.db.Model(&key).UpdateColumns(map[string]interface{}{
+		"last_used_at": now,
+		"last_used_ip": ip,
+	})
+
+	return key, user, nil
+}
+
+// RevokeAPIKey revokes a key owned by the user
+func (s *Service) RevokeAPIKey(userID uint, id string) error {
+	result := s.db.Model(&store.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidAPIKey
+	}
+	return nil
+}
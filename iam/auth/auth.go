@@ -0,0 +1,174 @@
+// Package auth holds the credentials of an iam instance: passwords, signed
+// access and purpose tokens, refresh tokens, MFA, API keys and server-side
+// sessions, plus the login throttle guarding them.
+package auth
+
+import (
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+
+	"iam/policy"
+	"iam/store"
+)
+
+// Config holds the credential settings
+type Config struct {
+	// Issuer is the iss claim of every token
+	Issuer string
+	// SigningAlgorithm is RS256 or EdDSA
+	SigningAlgorithm string
+	// SigningKeyRetention keeps retired keys published for verification;
+	// New raises it to outlive every token a key signed
+	SigningKeyRetention time.Duration
+	// PublicURL is the externally visible base URL used in emailed links
+	PublicURL string
+	// TOTPIssuer names the account in authenticator apps
+	TOTPIssuer string
+
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	InvitationTTL        time.Duration
+	EmailVerificationTTL time.Duration
+	PasswordResetTTL     time.Duration
+	SessionTTL           time.Duration
+	// ImpersonationTTL limits impersonation tokens and sessions, 30 minutes by default
+	ImpersonationTTL time.Duration
+
+	Throttle LoginThrottle
+	// HashConcurrency limits how many password hashes run at once
+	HashConcurrency int
+	// HashQueueWait is how long a hash waits for a free slot
+	HashQueueWait time.Duration
+	// HashAlgorithm hashes new passwords, bcrypt or argon2id. Hashes made
+	// with another algorithm or cost are upgraded at the next login.
+	HashAlgorithm string
+	// BcryptCost defaults to 14
+	BcryptCost int
+	// Argon2 defaults to 64 MiB, 3 iterations and 4 lanes
+	Argon2 Argon2Params
+
+	// Password holds the rules for new passwords
+	Password PasswordPolicy
+}
+
+// Service issues and verifies credentials
+type Service struct {
+	store  *store.Store
+	db     *gorm.DB
+	policy *policy.Service
+
+	Keyring  *Keyring
+	Mailer   Mailer
+	Sessions SessionBackend
+	Config   Config
+
+	// Now is the clock TOTP codes are checked against
+	Now func() time.Time
+
+	// hashSlots bounds the number of concurrent password hashes
+	hashSlots chan struct{}
+}
+
+// New creates the credential service. The keyring is loaded separately
+// with Keyring.Load.
+func New(st *store.Store, pol *policy.Service, mailer Mailer, sessions SessionBackend, cfg Config) *Service {
+	if cfg.HashAlgorithm == "" {
+		cfg.HashAlgorithm = HashBcrypt
+	}
+	if cfg.BcryptCost == 0 {
+		cfg.BcryptCost = 14
+	}
+	if cfg.Argon2 == (Argon2Params{}) {
+		cfg.Argon2 = Argon2Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 4}
+	}
+	if cfg.HashConcurrency < 1 {
+		cfg.HashConcurrency = runtime.NumCPU()
+	}
+	if cfg.ImpersonationTTL == 0 {
+		cfg.ImpersonationTTL = 30 * time.Minute
+	}
+	cfg.PublicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+
+	// Retired keys must outlive every token they signed
+	for _, ttl := range []time.Duration{cfg.AccessTokenTTL, cfg.InvitationTTL, cfg.EmailVerificationTTL, cfg.PasswordResetTTL, cfg.ImpersonationTTL} {
+		if cfg.SigningKeyRetention < ttl {
+			cfg.SigningKeyRetention = ttl
+		}
+	}
+
+	return &Service{
+		store:     st,
+		db:        st.DB,
+		policy:    pol,
+		Keyring:   NewKeyring(st.DB, cfg.SigningAlgorithm, cfg.SigningKeyRetention),
+		Mailer:    mailer,
+		Sessions:  sessions,
+		Config:    cfg,
+		Now:       time.Now,
+		hashSlots: make(chan struct{}, cfg.HashConcurrency),
+	}
+}
+
+// Claims defines the structure for JWT claims
+type Claims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// Version must match User.TokenVersion for the token to be accepted
+	Version uint `json:"ver"`
+	// Set on tokens issued to OAuth clients
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// Active organization, the Casbin domain the user works in
+	Org string `json:"org,omitempty"`
+	// Set on tokens of an admin impersonating the user
+	Act *ActorClaim `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateJWT creates a new JWT token
+func (s *Service) GenerateJWT(user store.User) (string, error) {
+	return s.signAccessToken(&Claims{
+		Username: user.Username,
+		Role:     user.Role,
+		Version:  user.TokenVersion,
+		Org:      user.ActiveOrg,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: user.Username,
+		},
+	})
+}
+
+// signAccessToken sets the token ID, issuer and lifetime of the claims and signs them
+func (s *Service) signAccessToken(claims *Claims) (string, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims.ID = jti
+	claims.Issuer = s.Config.Issuer
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(s.Config.AccessTokenTTL))
+	}
+
+	return s.Keyring.Sign(AccessTokenType, claims)
+}
+
+// ParseAccessToken validates an access token and returns its claims. It
+// does not check the token version against the user.
+func (s *Service) ParseAccessToken(tokenString string) (*Claims, bool) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, s.Keyring.Keyfunc,
+		jwt.WithValidMethods([]string{"RS256", "EdDSA"}),
+		jwt.WithIssuer(s.Config.Issuer),
+	)
+	if err != nil || !token.Valid || token.Header["typ"] != AccessTokenType || s.IsTokenRevoked(claims.ID) {
+		return nil, false
+	}
+	return claims, true
+}
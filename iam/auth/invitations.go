@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"iam/store"
+)
+
+const PurposeInvitation = "invitation"
+
+var ErrInvalidInvitation = errors.New("invalid or expired invitation")
+
+// InvitationLink returns the registration URL for an invitation token
+func (s *Service) InvitationLink(token string) string {
+	return s.Config.PublicURL + "/register?invitation=" + token
+}
+
+// FindInvitation resolves an invitation token to a pending invitation for
+// the given email address
+func (s *Service) FindInvitation(token, email string) (store.Invitation, error) {
+	claims, err := s.ParsePurposeToken(PurposeInvitation, token)
+	if err != nil {
+		return store.Invitation{}, ErrInvalidInvitation
+	}
+
+	var invitation store.Invitation
+	if result := s.db.First(&invitation, claims.Subject); result.Error != nil {
+		return store.Invitation{}, ErrInvalidInvitation
+	}
+
+	if invitation.AcceptedAt != nil || invitation.RevokedAt != nil ||
+		time.Now().After(invitation.ExpiresAt) ||
+		!strings.EqualFold(invitation.Email, email) {
+		return store.Invitation{}, ErrInvalidInvitation
+	}
+
+	return invitation, nil
+}
+
+// AcceptInvitation marks a pending invitation as accepted by the user. It
+// fails if the invitation was accepted or revoked concurrently.
+func AcceptInvitation(tx *gorm.DB, invitation store.Invitation, user store.User) error {
+	result := tx.Model(&store.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitation.ID).
+		Updates(map[string]interface{}{
+			"accepted_at":    time.Now(),
+			"accepted_by_id": user.ID,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidInvitation
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+
+	"iam/store"
+)
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// keyringKey is a parsed signing key
+type keyringKey struct {
+	record  store.SigningKey
+	private crypto.Signer
+	public  crypto.PublicKey
+	method  jwt.SigningMethod
+}
+
+// Keyring holds the active signing key and all keys valid for verification
+type Keyring struct {
+	db        *gorm.DB
+	algorithm string
+	retention time.Duration
+
+	mu     sync.RWMutex
+	active *keyringKey
+	keys   map[string]*keyringKey
+
+	// reloadedAt is when a token of an unknown key last reloaded the keys
+	reloadMu   sync.Mutex
+	reloadedAt time.Time
+}
+
+// keyReloadInterval limits the reloads for tokens of unknown keys, since
+// anyone can send those
+const keyReloadInterval = 10 * time.Second
+
+// NewKeyring creates an empty keyring that generates keys for algorithm
+// and keeps retired keys for retention
+func NewKeyring(db *gorm.DB, algorithm string, retention time.Duration) *Keyring {
+	return &Keyring{db: db, algorithm: algorithm, retention: retention, keys: map[string]*keyringKey{}}
+}
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Token types set in the typ header, so that a token issued for one purpose
+// cannot be presented as another
+const (
+	AccessTokenType  = "at+jwt"
+	PurposeTokenType = "JWT"
+)
+
+// signingMethod returns the JWT signing method for an algorithm name
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case "RS256":
+		return jwt.SigningMethodRS256, nil
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+}
+
+// generateSigningKey creates a new key pair for the given algorithm
+func generateSigningKey(alg string) (store.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch alg {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return store.SigningKey{}, err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return store.SigningKey{}, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return store.SigningKey{}, err
+	}
+
+	kid, err := RandomToken(12)
+	if err != nil {
+		return store.SigningKey{}, err
+	}
+
+	return store.SigningKey{
+		KID:        kid,
+		Algorithm:  alg,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})),
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		Active:     true,
+	}, nil
+}
+
+// parseSigningKey decodes the PEM encoded key pair of a stored key
+func parseSigningKey(record store.SigningKey) (*keyringKey, error) {
+	method, err := signingMethod(record.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &keyringKey{record: record, method: method}
+
+	block, _ := pem.Decode([]byte(record.PublicKey))
+	if block == nil {
+		return nil, fmt.Errorf("key %s: invalid public key", record.KID)
+	}
+	if key.public, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return nil, err
+	}
+
+	// Retired keys only need the public half
+	if record.Active {
+		block, _ = pem.Decode([]byte(record.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("key %s: invalid private key", record.KID)
+		}
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key %s: private key cannot sign", record.KID)
+		}
+		key.private = signer
+	}
+
+	return key, nil
+}
+
+// Load reads all keys that are still valid for verification from the
+// database, creating the first signing key if there is none.
+func (k *Keyring) Load() error {
+	loaded, err := k.load()
+	if err != nil || loaded {
+		return err
+	}
+
+	// Instances starting together race to create the first key. The unique
+	// index on active keys lets only one of them succeed; the others load
+	// the key it created.
+	if err := k.Rotate(); err != nil {
+		if loaded, loadErr := k.load(); loadErr == nil && loaded {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// load reads the keys from the database and reports whether there is an
+// active one. The keyring is left unchanged when there is none.
+func (k *Keyring) load() (bool, error) {
+	var records []store.SigningKey
+	if err := k.db.Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Find(&records).Error; err != nil {
+		return false, err
+	}
+
+	keys := map[string]*keyringKey{}
+	var active *keyringKey
+	for _, record := range records {
+		key, err := parseSigningKey(record)
+		if err != nil {
+			log.Printf("Skipping signing key %s: %v", record.KID, err)
+			continue
+		}
+		keys[record.KID] = key
+		if record.Active {
+			active = key
+		}
+	}
+
+	if active == nil {
+		return false, nil
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.active = active
+	k.mu.Unlock()
+	return true, nil
+}
+
+// Rotate creates a new active signing key and retires the current one.
+// Retired keys stay published for the retention of the keyring.
+func (k *Keyring) Rotate() error {
+	record, err := generateSigningKey(k.algorithm)
+	if err != nil {
+		return err
+	}
+
+	err = k.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		expiresAt := now.Add(k.retention)
+		if err := tx.Model(&store.SigningKey{}).Where("active = ?", true).Updates(map[string]interface{}{
+			"active":      false,
+			"private_key": "",
+			"retired_at":  now,
+			"expires_at":  expiresAt,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Rotated signing key, new key %s (%s)", record.KID, record.Algorithm)
+	return k.Load()
+}
+
+// Sign signs the claims with the active key and sets the kid and typ headers
+func (k *Keyring) Sign(typ string, claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	active := k.active
+	k.mu.RUnlock()
+
+	if active == nil {
+		return "", ErrUnknownKey
+	}
+
+	token := jwt.NewWithClaims(active.method, claims)
+	token.Header["kid"] = active.record.KID
+	token.Header["typ"] = typ
+	return token.SignedString(active.private)
+}
+
+// Keyfunc resolves the verification key of a token from its kid header
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	k.mu.RLock()
+	key, exists := k.keys[kid]
+	k.mu.RUnlock()
+
+	// The key may have been created by another instance
+	if !exists {
+		if !validKID(kid) || !k.reloadDue() {
+			return nil, ErrUnknownKey
+		}
+		if err := k.Load(); err != nil {
+			return nil, err
+		}
+		k.mu.RLock()
+		key, exists = k.keys[kid]
+		k.mu.RUnlock()
+	}
+
+	if !exists || key.method.Alg() != token.Method.Alg() {
+		return nil, ErrUnknownKey
+	}
+
+	return key.public, nil
+}
+
+// validKID reports whether kid looks like the key IDs of the keyring
+func validKID(kid string) bool {
+	if kid == "" || len(kid) > 64 {
+		return false
+	}
+	for _, r := range kid {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// reloadDue reports whether an unknown key may reload the keys now
+func (k *Keyring) reloadDue() bool {
+	k.reloadMu.Lock()
+	defer k.reloadMu.Unlock()
+	if time.Since(k.reloadedAt) < keyReloadInterval {
+		return false
+	}
+	k.reloadedAt = time.Now()
+	return true
+}
+
+// JWKS returns the public keys valid for verification
+func (k *Keyring) JWKS() []JWK {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	jwks := []JWK{}
+	for _, key := range k.keys {
+		jwk := JWK{Use: "sig", Alg: key.record.Algorithm, Kid: key.record.KID}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+// RotatePeriodically reloads the keyring and rotates the active key once
+// it is older than the rotation interval.
+func (k *Keyring) RotatePeriodically(interval time.Duration) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := k.Load(); err != nil {
+			log.Printf("Failed to reload signing keys: %v", err)
+			continue
+		}
+
+		k.mu.RLock()
+		due := time.Since(k.active.record.CreatedAt) > interval
+		k.mu.RUnlock()
+
+		if due {
+			if err := k.Rotate(); err != nil {
+				log.Printf("Failed to rotate signing key: %v", err)
+			}
+		}
+	}
+}
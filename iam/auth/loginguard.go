@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"iam/store"
+)
+
+// LoginThrottle holds the brute-force protection settings
+type LoginThrottle struct {
+	UserBackoffAfter int           // failures per username before backoff starts
+	IPBackoffAfter   int           // failures per IP before backoff starts
+	BaseDelay        time.Duration // first backoff delay, doubled per failure
+	MaxDelay         time.Duration
+	LockoutAfter     int // failures per username before the account is locked
+	LockoutDuration  time.Duration
+	Window           time.Duration // failures older than this are forgotten
+}
+
+var (
+	ErrLoginThrottled   = errors.New("too many failed login attempts")
+	ErrAccountLocked    = errors.New("account is temporarily locked")
+	ErrPasswordHashBusy = errors.New("password hashing capacity exhausted")
+)
+
+// withHashSlot runs fn once a password hashing slot is free
+func (s *Service) withHashSlot(fn func()) error {
+	timer := time.NewTimer(s.Config.HashQueueWait)
+	defer timer.Stop()
+
+	select {
+	case s.hashSlots <- struct{}{}:
+	case <-timer.C:
+		return ErrPasswordHashBusy
+	}
+	defer func() { <-s.hashSlots }()
+
+	fn()
+	return nil
+}
+
+func userAttemptKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// backoff returns the delay required after the given number of failures
+func (t LoginThrottle) backoff(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	exp := failures - threshold
+	if exp > 30 {
+		return t.MaxDelay
+	}
+	delay := t.BaseDelay << uint(exp)
+	if delay > t.MaxDelay || delay <= 0 {
+		return t.MaxDelay
+	}
+	return delay
+}
+
+// CheckLoginAllowed reports whether a login for username from ip may be
+// attempted now. It returns the time to wait when it may not.
+func (s *Service) CheckLoginAllowed(username, ip string) (time.Duration, error) {
+	var attempts []store.LoginAttempt
+	s.db.Where("attempt_key IN ?", []string{userAttemptKey(username), ipAttemptKey(ip)}).Find(&attempts)
+
+	now := time.Now()
+	var wait time.Duration
+	for _, attempt := range attempts {
+		if now.Sub(attempt.LastFailureAt) > s.Config.Throttle.Window &&
+			(attempt.LockedUntil == nil || now.After(*attempt.LockedUntil)) {
+			continue
+		}
+
+		if attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil) {
+			return attempt.LockedUntil.Sub(now), ErrAccountLocked
+		}
+
+		threshold := s.Config.Throttle.UserBackoffAfter
+		if strings.HasPrefix(attempt.Key, "ip:") {
+			threshold = s.Config.Throttle.IPBackoffAfter
+		}
+		if d := attempt.LastFailureAt.Add(s.Config.Throttle.backoff(attempt.Failures, threshold)).Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	if wait > 0 {
+		return wait, ErrLoginThrottled
+	}
+	return 0, nil
+}
+
+// RecordLoginFailure counts a failed attempt for username and ip and locks
+// the username once it reaches the lockout threshold. It reports whether
+// the account became locked.
+func (s *Service) RecordLoginFailure(username, ip string) bool {
+	locked := false
+	s.db.Transaction(func(tx *gorm.DB) error {
+		for _, key := range []string{userAttemptKey(username), ipAttemptKey(ip)} {
+			var attempt store.LoginAttempt
+			if err := tx.Where(store.LoginAttempt{Key: key}).FirstOrInit(&attempt).Error; err != nil {
+				return err
+			}
+
+			now := time.Now()
+			if now.Sub(attempt.LastFailureAt) > s.Config.Throttle.Window {
+				attempt.Failures = 0
+			}
+			attempt.Failures++
+			attempt.LastFailureAt = now
+
+			if strings.HasPrefix(key, "user:") && attempt.Failures >= s.Config.Throttle.LockoutAfter {
+				until := now.Add(s.Config.Throttle.LockoutDuration)
+				attempt.LockedUntil = &until
+				attempt.Failures = 0
+				locked = true
+			}
+
+			if err := tx.Save(&attempt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return locked
+}
+
+// ResetLoginFailures clears the failures of a username after a successful
+// login or an admin unlock
+func (s *Service) ResetLoginFailures(username string) error {
+	return s.db.Where("attempt_key = ?", userAttemptKey(username)).Delete(&store.LoginAttempt{}).Error
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer delivers plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends emails through an SMTP server, using STARTTLS when the
+// server offers it
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send implements Mailer
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{to}, formatMessage(m.From, to, subject, body))
+}
+
+// LogMailer writes emails to a file, or to the log when Path is empty. It
+// is meant for local development and tests.
+type LogMailer struct {
+	Path string
+	From string
+	mu   sync.Mutex
+}
+
+// Send implements Mailer
+func (m *LogMailer) Send(to, subject, body string) error {
+	message := formatMessage(m.From, to, subject, body)
+	if m.Path == "" {
+		log.Printf("Email not sent (log mailer):\n%s", message)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(message, []byte("\r\n")...))
+	return err
+}
+
+// formatMessage builds an RFC 5322 message
+func formatMessage(from, to, subject, body string) []byte {
+	// Header values come from user input, so line breaks are stripped
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", clean.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(to))
+	fmt.Fprintf(&b, "Subject: %s\r\n", clean.Replace(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// SendMail delivers an email in the background so slow mail servers do
+// not hold up requests or reveal whether an address is registered
+func (s *Service) SendMail(to, subject, body string) {
+	go func() {
+		if err := s.Mailer.Send(to, subject, body); err != nil {
+			log.Printf("Failed to send email %q to %s: %v", subject, to, err)
+		}
+	}()
+}
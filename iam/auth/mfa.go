@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+
+	"iam/store"
+)
+
+// PurposeClaims are the claims of short-lived, single-purpose tokens such as
+// MFA challenges. They are never accepted as access tokens.
+type PurposeClaims struct {
+	Purpose string `json:"purpose"`
+	Binding string `json:"bnd,omitempty"` // state the token is only valid for
+	jwt.RegisteredClaims
+}
+
+const (
+	// TOTP parameters as defined by RFC 6238, compatible with common authenticator apps
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1
+
+	recoveryCodeCount = 10
+
+	PurposeMFA      = "mfa"
+	MFAChallengeTTL = 5 * time.Minute
+)
+
+var ErrInvalidPurposeToken = errors.New("invalid or expired token")
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret returns a new random base32 encoded TOTP secret
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// totpCode computes the HOTP value (RFC 4226) of the secret for a time step
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// ValidateTOTP checks a code against the user's secret, allowing one step of
+// clock skew. A time step can only be used once, so a code cannot be replayed.
+// On success the used step is stored on the user.
+func (s *Service) ValidateTOTP(user *store.User, code string) bool {
+	secret, err := base32NoPadding.DecodeString(strings.ToUpper(user.TOTPSecret))
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+
+	current := s.Now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= user.TOTPLastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			user.TOTPLastStep = step
+			s.db.Model(user).UpdateColumn("totp_last_step", step)
+			return true
+		}
+	}
+	return false
+}
+
+// ProvisioningURI returns the otpauth:// URI authenticator apps import,
+// usually rendered as a QR code by the client
+func (s *Service) ProvisioningURI(user store.User) string {
+	issuer := s.Config.TOTPIssuer
+	label := url.PathEscape(issuer + ":" + user.Username)
+
+	params := url.Values{}
+	params.Set("secret", user.TOTPSecret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// normalizeRecoveryCode strips formatting so codes can be typed loosely
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// GenerateRecoveryCodes replaces the user's recovery codes and returns the
+// new codes in plain text. They are shown to the user exactly once.
+func (s *Service) GenerateRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	records := make([]store.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		records[i] = store.RecoveryCode{UserID: userID, CodeHash: HashToken(normalizeRecoveryCode(code))}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&store.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes
+func (s *Service) UseRecoveryCode(userID uint, code string) bool {
+	result := s.db.Model(&store.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// VerifySecondFactor accepts either a TOTP code or a recovery code
+func (s *Service) VerifySecondFactor(user *store.User, code, recoveryCode string) bool {
+	if code != "" {
+		return s.ValidateTOTP(user, code)
+	}
+	if recoveryCode != "" {
+		return s.UseRecoveryCode(user.ID, recoveryCode)
+	}
+	return false
+}
+
+// SignPurposeToken issues a short-lived token for a single purpose
+func (s *Service) SignPurposeToken(purpose, subject string, ttl time.Duration) (string, error) {
+	return s.SignPurposeTokenWithBinding(purpose, subject, "", ttl)
+}
+
+// SignPurposeTokenWithBinding issues a purpose token that carries a binding
+// the verifier compares against the current state of the subject
+func (s *Service) SignPurposeTokenWithBinding(purpose, subject, binding string, ttl time.Duration) (string, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := &PurposeClaims{
+		Purpose: purpose,
+		Binding: binding,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    s.Config.Issuer,
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+	}
+	return s.Keyring.Sign(PurposeTokenType, claims)
+}
+
+// ParsePurposeToken validates a token issued by SignPurposeToken
+func (s *Service) ParsePurposeToken(purpose, tokenString string) (*PurposeClaims, error) {
+	claims := &PurposeClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, s.Keyring.Keyfunc,
+		jwt.WithValidMethods([]string{"RS256", "EdDSA"}),
+		jwt.WithIssuer(s.Config.Issuer),
+	)
+	if err != nil || !token.Valid || token.Header["typ"] != PurposeTokenType ||
+		claims.Purpose != purpose || s.IsTokenRevoked(claims.ID) {
+		return nil, ErrInvalidPurposeToken
+	}
+	return claims, nil
+}
+
+// UserFromMFAToken resolves the user an MFA challenge was issued for
+func (s *Service) UserFromMFAToken(tokenString string) (*PurposeClaims, store.User, error) {
+	claims, err := s.ParsePurposeToken(PurposeMFA, tokenString)
+	if err != nil {
+		return nil, store.User{}, err
+	}
+
+	var user store.User
+	if result := s.db.Where("username = ?", claims.Subject).First(&user); result.Error != nil {
+		return nil, store.User{}, ErrInvalidPurposeToken
+	}
+	return claims, user, nil
+}
+
+// StartTOTPEnrollment generates a new pending TOTP secret for the user
+func (s *Service) StartTOTPEnrollment(user *store.User) error {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return err
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	return s.db.Model(user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error
+}
+
+// EnableMFA turns on MFA after the first code was verified and returns
+// a fresh set of recovery codes
+func (s *Service) EnableMFA(user *store.User) ([]string, error) {
+	user.MFAEnabled = true
+	if err := s.db.Model(user).Update("mfa_enabled", true).Error; err != nil {
+		return nil, err
+	}
+	return s.GenerateRecoveryCodes(user.ID)
+}
@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"iam/store"
+)
+
+// Password hashing algorithms
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// Argon2Params are the cost parameters of Argon2id hashes
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// PasswordPolicy holds the rules new passwords must satisfy
+type PasswordPolicy struct {
+	MinLength int
+	// MinClasses is how many of lowercase letters, uppercase letters,
+	// digits and other characters a password must mix
+	MinClasses int
+	// Blocklist holds common and breached passwords, lowercased
+	Blocklist map[string]struct{}
+	// History is how many recent passwords of a user, the current one
+	// included, cannot be chosen again
+	History int
+}
+
+var (
+	// ErrWeakPassword is wrapped by every reason the policy refuses a password
+	ErrWeakPassword   = errors.New("password does not meet the policy")
+	ErrPasswordReused = fmt.Errorf("%w: it was used recently", ErrWeakPassword)
+
+	errInvalidHash = errors.New("invalid password hash")
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// LoadPasswordBlocklist returns the built-in list of common passwords,
+// extended with the lines of path if it is set
+func LoadPasswordBlocklist(path string) (map[string]struct{}, error) {
+	blocklist := map[string]struct{}{}
+	add := func(scanner *bufio.Scanner) error {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				blocklist[strings.ToLower(line)] = struct{}{}
+			}
+		}
+		return scanner.Err()
+	}
+
+	if err := add(bufio.NewScanner(strings.NewReader(commonPasswords))); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return blocklist, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return blocklist, add(bufio.NewScanner(f))
+}
+
+// CharacterClasses counts the classes of characters a password mixes
+func CharacterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// ValidatePassword checks a new password for user against the password
+// policy. Existing users cannot reuse their recent passwords.
+func (s *Service) ValidatePassword(user store.User, password string) error {
+	policy := s.Config.Password
+
+	if n := len([]rune(password)); n < policy.MinLength {
+		return fmt.Errorf("%w: it needs at least %d characters", ErrWeakPassword, policy.MinLength)
+	}
+	if CharacterClasses(password) < policy.MinClasses {
+		return fmt.Errorf("%w: it needs %d of lowercase letters, uppercase letters, digits and symbols",
+			ErrWeakPassword, policy.MinClasses)
+	}
+
+	lower := strings.ToLower(password)
+	if _, listed := policy.Blocklist[lower]; listed {
+		return fmt.Errorf("%w: it is too common", ErrWeakPassword)
+	}
+	if (user.Username != "" && lower == strings.ToLower(user.Username)) ||
+		(user.Email != "" && lower == strings.ToLower(user.Email)) {
+		return fmt.Errorf("%w: it matches the username or email", ErrWeakPassword)
+	}
+
+	if user.ID == 0 || policy.History < 1 {
+		return nil
+	}
+	recent := []string{user.PasswordHash}
+	if policy.History > 1 {
+		var history []store.PasswordHistory
+		if err := s.db.Where("user_id = ?", user.ID).Order("id desc").
+			Limit(policy.History - 1).Find(&history).Error; err != nil {
+			return err
+		}
+		for _, h := range history {
+			recent = append(recent, h.Hash)
+		}
+	}
+	for _, hash := range recent {
+		if hash == "" {
+			continue
+		}
+		match, err := s.CheckPasswordHash(password, hash)
+		if err != nil {
+			return err
+		}
+		if match {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+// ChangePassword validates and sets a new password for user. The hash it
+// replaces moves to the password history.
+func (s *Service) ChangePassword(user *store.User, password string) error {
+	if err := s.ValidatePassword(*user, password); err != nil {
+		return err
+	}
+	hash, err := s.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if user.PasswordHash != "" && s.Config.Password.History > 1 {
+			if err := tx.Create(&store.PasswordHistory{UserID: user.ID, Hash: user.PasswordHash}).Error; err != nil {
+				return err
+			}
+
+			// Only the hashes the policy still checks are kept
+			var stale []uint
+			if err := tx.Model(&store.PasswordHistory{}).Where("user_id = ?", user.ID).Order("id desc").
+				Offset(s.Config.Password.History-1).Pluck("id", &stale).Error; err != nil {
+				return err
+			}
+			if len(stale) > 0 {
+				if err := tx.Delete(&store.PasswordHistory{}, stale).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Model(user).Update("password_hash", hash).Error
+	})
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	return nil
+}
+
+// HashPassword hashes a password with the configured algorithm
+func (s *Service) HashPassword(password string) (string, error) {
+	var hash string
+	var err error
+	if slotErr := s.withHashSlot(func() {
+		if s.Config.HashAlgorithm == HashArgon2id {
+			hash, err = hashArgon2id(password, s.Config.Argon2)
+			return
+		}
+		var bytes []byte
+		bytes, err = bcrypt.GenerateFromPassword([]byte(password), s.Config.BcryptCost)
+		hash = string(bytes)
+	}); slotErr != nil {
+		return "", slotErr
+	}
+	return hash, err
+}
+
+// CheckPasswordHash compares a password with a bcrypt or Argon2id hash. It
+// fails with ErrPasswordHashBusy when no hashing slot frees up in time.
+func (s *Service) CheckPasswordHash(password, hash string) (bool, error) {
+	var match bool
+	if slotErr := s.withHashSlot(func() {
+		if strings.HasPrefix(hash, "$"+HashArgon2id+"$") {
+			match = checkArgon2id(password, hash)
+			return
+		}
+		match = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}); slotErr != nil {
+		return false, slotErr
+	}
+	return match, nil
+}
+
+// NeedsRehash reports whether a hash was made with another algorithm or
+// cost than the configured one
+func (s *Service) NeedsRehash(hash string) bool {
+	if hash == "" {
+		return false
+	}
+	if s.Config.HashAlgorithm == HashArgon2id {
+		params, _, _, err := parseArgon2id(hash)
+		return err != nil || params != s.Config.Argon2
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != s.Config.BcryptCost
+}
+
+// UpgradePasswordHash rehashes the password of user after a successful
+// login if the algorithm or cost changed since it was set
+func (s *Service) UpgradePasswordHash(user *store.User, password string) error {
+	if !s.NeedsRehash(user.PasswordHash) {
+		return nil
+	}
+	hash, err := s.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	// Only replace the hash that was verified, a concurrent change wins
+	result := s.db.Model(&store.User{}).Where("id = ? AND password_hash = ?", user.ID, user.PasswordHash).
+		Update("password_hash", hash)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 1 {
+		user.PasswordHash = hash
+	}
+	return nil
+}
+
+// hashArgon2id encodes an Argon2id hash in the PHC string format
+func hashArgon2id(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, 32)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", HashArgon2id, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// parseArgon2id decodes a hash made by hashArgon2id
+func parseArgon2id(hash string) (params Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return params, nil, nil, errInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, errInvalidHash
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, errInvalidHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidHash
+	}
+	return params, salt, key, nil
+}
+
+// checkArgon2id compares a password with an Argon2id hash in constant time
+func checkArgon2id(password, hash string) bool {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
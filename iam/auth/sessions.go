@@ -0,0 +1,360 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"iam/store"
+)
+
+// SessionBackend persists server-side sessions
+type SessionBackend interface {
+	Load(id string) (store.UserSession, error)
+	Save(session store.UserSession) error
+	Delete(id string) error
+	ListByUser(userID uint) ([]store.UserSession, error)
+	DeleteByUser(userID uint) error
+	Prune(now time.Time) error
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionActivityInterval limits how often the last activity of a session
+// is written
+const sessionActivityInterval = time.Minute
+
+// GormSessionBackend stores sessions in the database
+type GormSessionBackend struct {
+	DB *gorm.DB
+}
+
+// Load implements SessionBackend
+func (b GormSessionBackend) Load(id string) (store.UserSession, error) {
+	var session store.UserSession
+	if result := b.DB.Where("id = ?", id).First(&session); result.Error != nil {
+		return store.UserSession{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// Save implements SessionBackend
+func (b GormSessionBackend) Save(session store.UserSession) error {
+	return b.DB.Save(&session).Error
+}
+
+// Delete implements SessionBackend
+func (b GormSessionBackend) Delete(id string) error {
+	return b.DB.Where("id = ?", id).Delete(&store.UserSession{}).Error
+}
+
+// ListByUser implements SessionBackend
+func (b GormSessionBackend) ListByUser(userID uint) ([]store.UserSession, error) {
+	var sessions []store.UserSession
+	err := b.DB.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// DeleteByUser implements SessionBackend
+func (b GormSessionBackend) DeleteByUser(userID uint) error {
+	return b.DB.Where("user_id = ?", userID).Delete(&store.UserSession{}).Error
+}
+
+// Prune implements SessionBackend
+func (b GormSessionBackend) Prune(now time.Time) error {
+	return b.DB.Where("expires_at < ?", now).Delete(&store.UserSession{}).Error
+}
+
+// RedisSessionBackend stores sessions in Redis. Sessions expire with their
+// keys; a set per user indexes them for listing, kept for TTL.
+type RedisSessionBackend struct {
+	Client *redis.Client
+	TTL    time.Duration
+}
+
+func (b RedisSessionBackend) key(id string) string {
+	return "iam:session:" + id
+}
+
+func (b RedisSessionBackend) userKey(userID uint) string {
+	return "iam:user-sessions:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// Load implements SessionBackend
+func (b RedisSessionBackend) Load(id string) (store.UserSession, error) {
+	data, err := b.Client.Get(context.Background(), b.key(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return store.UserSession{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return store.UserSession{}, err
+	}
+
+	var session store.UserSession
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session); err != nil {
+		return store.UserSession{}, err
+	}
+	return session, nil
+}
+
+// Save implements SessionBackend
+func (b RedisSessionBackend) Save(session store.UserSession) error {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	pipe := b.Client.TxPipeline()
+	pipe.Set(ctx, b.key(session.ID), data.Bytes(), time.Until(session.ExpiresAt))
+	if session.UserID != 0 {
+		pipe.SAdd(ctx, b.userKey(session.UserID), session.ID)
+		pipe.Expire(ctx, b.userKey(session.UserID), b.TTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Delete implements SessionBackend
+func (b RedisSessionBackend) Delete(id string) error {
+	session, err := b.Load(id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	pipe := b.Client.TxPipeline()
+	pipe.Del(ctx, b.key(id))
+	pipe.SRem(ctx, b.userKey(session.UserID), id)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ListByUser implements SessionBackend
+func (b RedisSessionBackend) ListByUser(userID uint) ([]store.UserSession, error) {
+	ids, err := b.Client.SMembers(context.Background(), b.userKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]store.UserSession, 0, len(ids))
+	for _, id := range ids {
+		session, err := b.Load(id)
+		if errors.Is(err, ErrSessionNotFound) {
+			// The session expired, drop it from the index
+			b.Client.SRem(context.Background(), b.userKey(userID), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// DeleteByUser implements SessionBackend
+func (b RedisSessionBackend) DeleteByUser(userID uint) error {
+	ctx := context.Background()
+	ids, err := b.Client.SMembers(ctx, b.userKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{b.userKey(userID)}
+	for _, id := range ids {
+		keys = append(keys, b.key(id))
+	}
+	return b.Client.Del(ctx, keys...).Err()
+}
+
+// Prune implements SessionBackend. Redis expires sessions by itself.
+func (b RedisSessionBackend) Prune(now time.Time) error {
+	return nil
+}
+
+// SessionID returns the ID of the session a cookie token refers to
+func SessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionUserID returns the signed-in user of session values, or 0
+func sessionUserID(values map[interface{}]interface{}) uint {
+	userID, _ := values["user_id"].(uint)
+	return userID
+}
+
+type clientIPKey struct{}
+
+// WithClientIP passes the client IP gin resolved, honouring trusted
+// proxies, to the session store. It must run before the sessions middleware.
+func WithClientIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), clientIPKey{}, c.ClientIP()))
+		c.Next()
+	}
+}
+
+// requestIP returns the client IP recorded by WithClientIP
+func requestIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// SessionStore is a sessions.Store that keeps session values in the
+// session backend and only a signed token in the cookie
+type SessionStore struct {
+	backend SessionBackend
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// NewSessionStore creates a store keeping sessions in backend for ttl and
+// signing cookies with the key pairs
+func NewSessionStore(backend SessionBackend, ttl time.Duration, keyPairs ...[]byte) *SessionStore {
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(int(ttl.Seconds()))
+		}
+	}
+
+	return &SessionStore{
+		backend: backend,
+		codecs:  codecs,
+		options: &gsessions.Options{
+			Path:     "/",
+			MaxAge:   int(ttl.Seconds()),
+			HttpOnly: true,
+		},
+	}
+}
+
+// Options implements sessions.Store
+func (s *SessionStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+}
+
+// Get implements sessions.Store
+func (s *SessionStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New implements sessions.Store. Unknown, expired or revoked sessions
+// start over as new empty sessions.
+func (s *SessionStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	record, err := s.backend.Load(SessionID(token))
+	if err != nil || time.Now().After(record.ExpiresAt) {
+		return session, nil
+	}
+	if err := (securecookie.GobEncoder{}).Deserialize(record.Data, &session.Values); err != nil {
+		return session, nil
+	}
+	session.ID = token
+	session.IsNew = false
+
+	if time.Since(record.LastSeenAt) > sessionActivityInterval || record.IP != requestIP(r) {
+		record.LastSeenAt = time.Now()
+		record.IP = requestIP(r)
+		record.UserAgent = r.UserAgent()
+		if err := s.backend.Save(record); err != nil {
+			log.Printf("Failed to record session activity: %v", err)
+		}
+	}
+	return session, nil
+}
+
+// Save implements sessions.Store. Saving a cleared session deletes it.
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge <= 0 || len(session.Values) == 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(SessionID(session.ID)); err != nil {
+				return err
+			}
+		}
+		options := *session.Options
+		options.MaxAge = -1
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", &options))
+		return nil
+	}
+
+	now := time.Now()
+	userID := sessionUserID(session.Values)
+
+	var record store.UserSession
+	if session.ID != "" {
+		record, _ = s.backend.Load(SessionID(session.ID))
+		// Signing in or out starts a new session, preventing session fixation
+		if record.ID != "" && record.UserID != userID {
+			if err := s.backend.Delete(record.ID); err != nil {
+				return err
+			}
+			record = store.UserSession{}
+		}
+	}
+	if record.ID == "" {
+		token, err := RandomToken(32)
+		if err != nil {
+			return err
+		}
+		session.ID = token
+		record = store.UserSession{ID: SessionID(token), CreatedAt: now}
+	}
+
+	data, err := (securecookie.GobEncoder{}).Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+	record.UserID = userID
+	record.Data = data
+	record.IP = requestIP(r)
+	record.UserAgent = r.UserAgent()
+	record.LastSeenAt = now
+	record.ExpiresAt = now.Add(time.Duration(session.Options.MaxAge) * time.Second)
+	if err := s.backend.Save(record); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
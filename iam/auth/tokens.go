@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"iam/store"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// RandomToken returns n random bytes encoded as unpadded base64url
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefreshToken stores a new refresh token based on the given record and
+// returns it. An empty FamilyID starts a new token family.
+func (s *Service) IssueRefreshToken(tx *gorm.DB, refreshToken store.RefreshToken) (string, error) {
+	token, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	if refreshToken.FamilyID == "" {
+		if refreshToken.FamilyID, err = RandomToken(16); err != nil {
+			return "", err
+		}
+	}
+
+	refreshToken.TokenHash = HashToken(token)
+	refreshToken.ExpiresAt = time.Now().Add(s.Config.RefreshTokenTTL)
+	if err := tx.Create(&refreshToken).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// IssueTokens creates an access token and a refresh token for the user
+func (s *Service) IssueTokens(user store.User) (string, string, error) {
+	accessToken, err := s.GenerateJWT(user)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := s.IssueRefreshToken(s.db, store.RefreshToken{UserID: user.ID})
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// RotateRefreshToken exchanges a refresh token issued to clientID (empty for
+// first-party logins) for a new one and returns the rotated token's record.
+// The presented token is revoked; presenting it again revokes its whole
+// family and every access token of the user; the reused token's record is
+// returned along with ErrRefreshTokenReused.
+func (s *Service) RotateRefreshToken(token, clientID string) (store.RefreshToken, store.User, string, error) {
+	var current store.RefreshToken
+	var user store.User
+	var refreshToken string
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ?", HashToken(token)).First(&current).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if current.ClientID != clientID {
+			return ErrInvalidRefreshToken
+		}
+
+		if current.RevokedAt != nil {
+			return ErrRefreshTokenReused
+		}
+
+		if time.Now().After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		// Only one concurrent rotation of the same token may succeed
+		result := tx.Model(&store.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", current.ID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		if err := tx.First(&user, current.UserID).Error; err != nil || user.Disabled {
+			return ErrInvalidRefreshToken
+		}
+
+		var err error
+		refreshToken, err = s.IssueRefreshToken(tx, store.RefreshToken{
+			UserID:   user.ID,
+			FamilyID: current.FamilyID,
+			ClientID: current.ClientID,
+			Scope:    current.Scope,
+		})
+		return err
+	})
+
+	if errors.Is(err, ErrRefreshTokenReused) {
+		var reused store.RefreshToken
+		if s.db.Where("token_hash = ?", HashToken(token)).First(&reused).Error == nil {
+			log.Printf("Refresh token reuse detected for user %d, revoking all tokens", reused.UserID)
+			s.RevokeUserTokens(reused.UserID)
+			return reused, store.User{}, "", err
+		}
+	}
+	if err != nil {
+		return store.RefreshToken{}, store.User{}, "", err
+	}
+
+	return current, user, refreshToken, nil
+}
+
+// RevokeRefreshToken revokes the family the given refresh token belongs to
+func (s *Service) RevokeRefreshToken(token string) error {
+	var refreshToken store.RefreshToken
+	if err := s.db.Where("token_hash = ?", HashToken(token)).First(&refreshToken).Error; err != nil {
+		return ErrInvalidRefreshToken
+	}
+
+	return s.db.Model(&store.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", refreshToken.FamilyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAccessToken adds an access token to the revocation list
+func (s *Service) RevokeAccessToken(claims *Claims, reason string) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	return s.db.Create(&store.RevokedToken{
+		JTI:       claims.ID,
+		Reason:    reason,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}).Error
+}
+
+// RevokeUserTokens invalidates every access and refresh token issued to a
+// user by bumping the user's token version. Authorization codes not yet
+// redeemed are spent, so they cannot be exchanged for new tokens.
+func (s *Service) RevokeUserTokens(userID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&store.User{}).Where("id = ?", userID).
+			UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&store.AuthorizationCode{}).
+			Where("user_id = ? AND used_at IS NULL", userID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&store.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", time.Now()).Error
+	})
+}
+
+// IsTokenRevoked reports whether an access token is on the revocation list
+func (s *Service) IsTokenRevoked(jti string) bool {
+	var count int64
+	s.db.Model(&store.RevokedToken{}).Where("jti = ?", jti).Count(&count)
+	return count > 0
+}
+
+// PruneTokens periodically removes expired refresh tokens and revocation
+// list entries for access tokens that have expired anyway.
+func (s *Service) PruneTokens(interval time.Duration) {
+	for {
+		now := time.Now()
+		s.db.Where("expires_at < ?", now).Delete(&store.RevokedToken{})
+		s.db.Where("expires_at < ?", now).Delete(&store.RefreshToken{})
+		if err := s.Sessions.Prune(now); err != nil {
+			log.Printf("Failed to prune sessions: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
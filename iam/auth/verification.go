@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"iam/store"
+)
+
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposePasswordReset = "password_reset"
+)
+
+// PasswordBinding ties a reset token to the current password, so every
+// outstanding reset token becomes invalid once the password changes
+func PasswordBinding(user store.User) string {
+	sum := sha256.Sum256([]byte(user.PasswordHash))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SignUserToken issues a purpose token for a user bound to a value that
+// must not change before the token is used
+func (s *Service) SignUserToken(purpose string, user store.User, binding string, ttl time.Duration) (string, error) {
+	return s.SignPurposeTokenWithBinding(purpose, strconv.FormatUint(uint64(user.ID), 10), binding, ttl)
+}
+
+// RedeemUserToken validates a token issued by SignUserToken, checks its
+// binding and revokes it so it can only be used once
+func (s *Service) RedeemUserToken(purpose, token string, binding func(store.User) string) (store.User, error) {
+	claims, err := s.ParsePurposeToken(purpose, token)
+	if err != nil {
+		return store.User{}, err
+	}
+
+	var user store.User
+	if result := s.db.First(&user, claims.Subject); result.Error != nil {
+		return store.User{}, ErrInvalidPurposeToken
+	}
+	if claims.Binding != binding(user) {
+		return store.User{}, ErrInvalidPurposeToken
+	}
+
+	s.RevokeAccessToken(&Claims{RegisteredClaims: claims.RegisteredClaims}, purpose+" used")
+	return user, nil
+}
+
+// SendVerificationEmail mails a link that verifies the user's current email
+func (s *Service) SendVerificationEmail(user store.User) error {
+	token, err := s.SignUserToken(PurposeVerifyEmail, user, user.Email, s.Config.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	s.SendMail(user.Email, "Verify your email address", fmt.Sprintf(
+		"Hello %s,\n\nconfirm your email address by opening the link below:\n\n%s/verify-email?token=%s\n\nThe link expires in %s.\n",
+		user.Username, s.Config.PublicURL, token, s.Config.EmailVerificationTTL))
+	return nil
+}
+
+// SendPasswordResetEmail mails a single-use password reset link
+func (s *Service) SendPasswordResetEmail(user store.User) error {
+	token, err := s.SignUserToken(PurposePasswordReset, user, PasswordBinding(user), s.Config.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	s.SendMail(user.Email, "Reset your password", fmt.Sprintf(
+		"Hello %s,\n\nsomeone asked to reset your password. If it was you, open the link below:\n\n%s/reset-password?token=%s\n\nThe link expires in %s. If you did not ask for a reset, ignore this email.\n",
+		user.Username, s.Config.PublicURL, token, s.Config.PasswordResetTTL))
+	return nil
+}
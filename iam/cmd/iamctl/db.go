@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"iam/auth"
+	"iam/config"
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// auditPageSize is the number of audit events read at a time
+const auditPageSize = 500
+
+// dbBackend works directly on the database of the server
+type dbBackend struct {
+	store  *store.Store
+	policy *policy.Service
+	auth   *auth.Service
+	// webhooks queues events for the server to deliver
+	webhooks *webhook.Dispatcher
+	// actor names the operator in the audit log
+	actor string
+}
+
+// newDBBackend opens the database with the settings of the server
+func newDBBackend() (*dbBackend, error) {
+	st, err := config.OpenStore()
+	if err != nil {
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+	// Failed queries are reported as errors, the query log would bury them
+	st.DB.Logger = logger.Default.LogMode(logger.Silent)
+
+	if err := st.CheckSchema(config.Env("AUTO_MIGRATE", "true") == "true"); err != nil {
+		return nil, err
+	}
+
+	pol, err := policy.New(st, config.Policy())
+	if err != nil {
+		return nil, err
+	}
+	authCfg := config.Auth()
+	au := auth.New(st, pol, config.Mailer(), config.SessionBackend(st.DB, authCfg.SessionTTL), authCfg)
+
+	actor := "iamctl"
+	if u, err := user.Current(); err == nil {
+		actor += ":" + u.Username
+	}
+	return &dbBackend{store: st, policy: pol, auth: au, webhooks: webhook.New(st, config.Webhook()), actor: actor}, nil
+}
+
+// audit records an operation performed by the operator
+func (b *dbBackend) audit(action, target string, before, after interface{}) {
+	b.store.AppendAuditEvent(store.AuditEvent{
+		Actor:  b.actor,
+		Action: action,
+		Target: target,
+		Result: store.AuditSuccess,
+		Before: store.AuditJSON(before),
+		After:  store.AuditJSON(after),
+	})
+}
+
+// emit queues a webhook event, delivered by the running server
+func (b *dbBackend) emit(event string, data map[string]interface{}) {
+	if err := b.webhooks.Emit(event, data); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to queue webhook event %s: %v\n", event, err)
+	}
+}
+
+func (b *dbBackend) findUser(username string) (store.User, error) {
+	var u store.User
+	err := b.store.DB.Where("username = ?", username).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return store.User{}, fmt.Errorf("user %q not found", username)
+	}
+	return u, err
+}
+
+func infoOf(u store.User) userInfo {
+	return userInfo{ID: u.ID, Username: u.Username, Email: u.Email, Role: u.Role}
+}
+
+func (b *dbBackend) CreateUser(username, email, password, role string) (userInfo, error) {
+	if role == "" {
+		role = b.policy.Config.DefaultRole
+	}
+	u, err := b.auth.CreateUser(username, email, password, role)
+	if err != nil {
+		return userInfo{}, err
+	}
+	b.audit("user.create", u.Username, nil, map[string]string{"email": u.Email, "role": u.Role})
+	return infoOf(u), nil
+}
+
+func (b *dbBackend) SetPassword(username, password string) error {
+	u, err := b.findUser(username)
+	if err != nil {
+		return err
+	}
+	if err := b.auth.SetPassword(&u, password); err != nil {
+		return err
+	}
+	b.audit("user.password.set", u.Username, nil, nil)
+	return nil
+}
+
+func (b *dbBackend) SetRole(username, role string) (userInfo, error) {
+	u, err := b.findUser(username)
+	if err != nil {
+		return userInfo{}, err
+	}
+	previousRole := u.Role
+	if err := b.policy.SetUserRoles(&u, []string{role}); err != nil {
+		return userInfo{}, err
+	}
+	// Tokens carrying the old role must not be used anymore
+	if err := b.auth.RevokeUserTokens(u.ID); err != nil {
+		return userInfo{}, err
+	}
+	b.audit("user.role.update", u.Username, map[string]string{"role": previousRole}, map[string]string{"role": u.Role})
+	b.emit(webhook.EventUserRoleChanged, map[string]interface{}{
+		"user":         map[string]interface{}{"id": u.ID, "username": u.Username, "email": u.Email, "role": u.Role},
+		"roles_before": []string{previousRole},
+		"roles":        b.policy.UserRoles(u),
+	})
+	return infoOf(u), nil
+}
+
+func (b *dbBackend) ListPolicies() ([][]string, error) {
+	return b.policy.Enforcer.GetPolicy()
+}
+
+func (b *dbBackend) AddPolicy(rule []string) error {
+	added, err := b.policy.Enforcer.AddPolicy(rule)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return errors.New("policy already exists")
+	}
+	b.audit("policy.add", rule[0], nil, rule)
+	b.emit(webhook.EventPolicyChanged, map[string]interface{}{"change": "add", "model": "rbac", "policy": rule})
+	return nil
+}
+
+func (b *dbBackend) RemovePolicy(rule []string) error {
+	removed, err := b.policy.Enforcer.RemovePolicy(rule)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return errors.New("policy not found")
+	}
+	b.audit("policy.remove", rule[0], rule, nil)
+	b.emit(webhook.EventPolicyChanged, map[string]interface{}{"change": "remove", "model": "rbac", "policy": rule})
+	return nil
+}
+
+func (b *dbBackend) RotateKeys() (store.SigningKey, error) {
+	if err := b.auth.Keyring.Rotate(); err != nil {
+		return store.SigningKey{}, err
+	}
+	b.audit("key.rotate", "", nil, nil)
+
+	var key store.SigningKey
+	err := b.store.DB.Where("active = ?", true).First(&key).Error
+	return key, err
+}
+
+func (b *dbBackend) ExportAudit(filter store.AuditFilter, emit func(store.AuditEvent) error) error {
+	filter.PageSize = auditPageSize
+	for filter.Page = 1; ; filter.Page++ {
+		events, _, err := b.store.QueryAuditEvents(filter)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := emit(event); err != nil {
+				return err
+			}
+		}
+		if len(events) < filter.PageSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"iam/store"
+)
+
+// httpBackend works through the HTTP API of a running server, as an admin
+type httpBackend struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// newHTTPBackend authenticates with a token, or logs in with a password
+func newHTTPBackend(baseURL, token, username, password string) (*httpBackend, error) {
+	b := &httpBackend{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	if token != "" {
+		return b, nil
+	}
+	if username == "" || password == "" {
+		return nil, errors.New("set IAM_TOKEN, or IAM_USERNAME and IAM_PASSWORD, to use -server")
+	}
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	err := b.do(http.MethodPost, "/api/auth/login", map[string]string{
+		"username": username,
+		"password": password,
+	}, &login)
+	if err != nil {
+		return nil, fmt.Errorf("login: %w", err)
+	}
+	if login.Token == "" {
+		return nil, errors.New("login needs a second factor, use IAM_TOKEN with an API key instead")
+	}
+	b.token = login.Token
+	return b, nil
+}
+
+// do sends body as JSON and decodes the response into out. Error
+// responses are returned with the message of the server.
+func (b *httpBackend) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, b.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) == nil && failure.Error != "" {
+			return fmt.Errorf("%s (%s)", failure.Error, resp.Status)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// userID resolves a username to the ID the admin endpoints take
+func (b *httpBackend) userID(username string) (uint, error) {
+	var response struct {
+		Users []struct {
+			ID       uint   `json:"id"`
+			Username string `json:"username"`
+		} `json:"users"`
+	}
+	if err := b.do(http.MethodGet, "/api/admin/users?username="+url.QueryEscape(username), nil, &response); err != nil {
+		return 0, err
+	}
+	for _, u := range response.Users {
+		if u.Username == username {
+			return u.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("user %q not found", username)
+}
+
+func (b *httpBackend) CreateUser(username, email, password, role string) (userInfo, error) {
+	var response struct {
+		User userInfo `json:"user"`
+	}
+	err := b.do(http.MethodPost, "/api/admin/users", map[string]string{
+		"username": username,
+		"email":    email,
+		"password": password,
+		"role":     role,
+	}, &response)
+	return response.User, err
+}
+
+func (b *httpBackend) SetPassword(username, password string) error {
+	id, err := b.userID(username)
+	if err != nil {
+		return err
+	}
+	return b.do(http.MethodPut, fmt.Sprintf("/api/admin/users/%d/password", id),
+		map[string]string{"password": password}, nil)
+}
+
+func (b *httpBackend) SetRole(username, role string) (userInfo, error) {
+	id, err := b.userID(username)
+	if err != nil {
+		return userInfo{}, err
+	}
+	var response struct {
+		User userInfo `json:"user"`
+	}
+	err = b.do(http.MethodPut, fmt.Sprintf("/api/admin/users/%d/role", id),
+		map[string]string{"role": role}, &response)
+	return response.User, err
+}
+
+func (b *httpBackend) ListPolicies() ([][]string, error) {
+	var response struct {
+		Policies [][]string `json:"policies"`
+	}
+	err := b.do(http.MethodGet, "/api/policies", nil, &response)
+	return response.Policies, err
+}
+
+func (b *httpBackend) AddPolicy(rule []string) error {
+	return b.do(http.MethodPost, "/api/policies", rule, nil)
+}
+
+func (b *httpBackend) RemovePolicy(rule []string) error {
+	return b.do(http.MethodDelete, "/api/policies", rule, nil)
+}
+
+func (b *httpBackend) RotateKeys() (store.SigningKey, error) {
+	if err := b.do(http.MethodPost, "/api/admin/keys/rotate", nil, nil); err != nil {
+		return store.SigningKey{}, err
+	}
+
+	var response struct {
+		Keys []store.SigningKey `json:"keys"`
+	}
+	if err := b.do(http.MethodGet, "/api/admin/keys", nil, &response); err != nil {
+		return store.SigningKey{}, err
+	}
+	for _, key := range response.Keys {
+		if key.Active {
+			return key, nil
+		}
+	}
+	return store.SigningKey{}, errors.New("server reports no active signing key")
+}
+
+func (b *httpBackend) ExportAudit(filter store.AuditFilter, emit func(store.AuditEvent) error) error {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"actor":        filter.Actor,
+		"impersonator": filter.Impersonator,
+		"action":       filter.Action,
+		"target":       filter.Target,
+		"result":       filter.Result,
+		"ip":           filter.IP,
+		"since":        filter.Since,
+		"until":        filter.Until,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	query.Set("page_size", strconv.Itoa(auditPageSize))
+
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		var response struct {
+			Events []store.AuditEvent `json:"events"`
+		}
+		if err := b.do(http.MethodGet, "/api/admin/audit?"+query.Encode(), nil, &response); err != nil {
+			return err
+		}
+		for _, event := range response.Events {
+			if err := emit(event); err != nil {
+				return err
+			}
+		}
+		if len(response.Events) < auditPageSize {
+			return nil
+		}
+	}
+}
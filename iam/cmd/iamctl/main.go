@@ -0,0 +1,396 @@
+// Command iamctl administers an iam instance, either directly through its
+// database or through the HTTP API of a running server:
+//
+//	iamctl [-server URL] [-json] <command> [arguments]
+//
+// Without -server it uses the database and settings of the server, read
+// from the same environment variables and .env file. That works before
+// the server ever ran, which makes it the safe way to create the first
+// admin. A running server only picks up policies and roles written to the
+// database when it restarts, so use -server against live instances.
+//
+// With -server requests authenticate with IAM_TOKEN, an access token or
+// API key, or else by logging in as IAM_USERNAME with IAM_PASSWORD.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"iam/auth"
+	"iam/store"
+)
+
+// userInfo is the part of a user iamctl reports
+type userInfo struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+// backend performs the operations of iamctl
+type backend interface {
+	CreateUser(username, email, password, role string) (userInfo, error)
+	SetPassword(username, password string) error
+	SetRole(username, role string) (userInfo, error)
+	ListPolicies() ([][]string, error)
+	AddPolicy(rule []string) error
+	RemovePolicy(rule []string) error
+	RotateKeys() (store.SigningKey, error)
+	// ExportAudit passes every matching event to emit, newest first
+	ExportAudit(filter store.AuditFilter, emit func(store.AuditEvent) error) error
+}
+
+// command is a subcommand such as "user create"
+type command struct {
+	name  string
+	usage string
+	run   func(ctl *ctl, args []string) error
+}
+
+var commands = []command{
+	{"user create", "-username NAME -email EMAIL [-role ROLE] [-password-stdin]", (*ctl).createUser},
+	{"user reset-password", "-username NAME [-password-stdin]", (*ctl).resetPassword},
+	{"user set-role", "-username NAME -role ROLE", (*ctl).setRole},
+	{"policy list", "[-subject SUBJECT]", (*ctl).listPolicies},
+	{"policy add", "SUBJECT [DOMAIN] OBJECT ACTION", (*ctl).addPolicy},
+	{"policy remove", "SUBJECT [DOMAIN] OBJECT ACTION", (*ctl).removePolicy},
+	{"keys rotate", "", (*ctl).rotateKeys},
+	{"audit export", "[-actor A] [-impersonator A] [-action A] [-target T] [-result R] [-ip IP] [-since T] [-until T]", (*ctl).exportAudit},
+}
+
+// errUsage is returned for malformed command lines
+var errUsage = errors.New("usage")
+
+// ctl holds the selected backend and output format
+type ctl struct {
+	backend backend
+	json    bool
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: iamctl [-server URL] [-json] <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	// The database settings come from the same .env file as the server's
+	godotenv.Load()
+
+	server := flag.String("server", os.Getenv("IAM_SERVER"), "base URL of a running server, the database is used if empty")
+	jsonOutput := flag.Bool("json", false, "print JSON for scripting")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := args[0] + " " + args[1]
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	var b backend
+	var err error
+	if *server != "" {
+		b, err = newHTTPBackend(*server, os.Getenv("IAM_TOKEN"), os.Getenv("IAM_USERNAME"), os.Getenv("IAM_PASSWORD"))
+	} else {
+		b, err = newDBBackend()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "iamctl: %v\n", err)
+		os.Exit(1)
+	}
+
+	c := &ctl{backend: b, json: *jsonOutput, stdin: os.Stdin, stdout: os.Stdout}
+	if err := cmd.run(c, args[2:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "Usage: iamctl %s %s\n", cmd.name, cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "iamctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// flags returns the flag set of a command, failing with errUsage
+func flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// print writes v as JSON, or calls text to write it for humans
+func (c *ctl) print(v interface{}, text func(w io.Writer)) error {
+	if c.json {
+		return json.NewEncoder(c.stdout).Encode(v)
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
+
+// password reads the password from stdin if asked to, and generates one
+// otherwise. Passwords are never taken as arguments, which other users
+// could see in the process list.
+func (c *ctl) password(fromStdin bool) (password string, generated bool, err error) {
+	if !fromStdin {
+		// Mix every character class, whatever the password policy asks for
+		for {
+			if password, err = auth.RandomToken(18); err != nil || auth.CharacterClasses(password) == 4 {
+				return password, true, err
+			}
+		}
+	}
+
+	line, err := bufio.NewReader(c.stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", false, err
+	}
+	password = strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", false, errors.New("empty password on stdin")
+	}
+	return password, false, nil
+}
+
+func (c *ctl) createUser(args []string) error {
+	fs := flags("user create")
+	username := fs.String("username", "", "")
+	email := fs.String("email", "", "")
+	role := fs.String("role", "", "")
+	passwordStdin := fs.Bool("password-stdin", false, "")
+	if fs.Parse(args) != nil || fs.NArg() > 0 || *username == "" || *email == "" {
+		return errUsage
+	}
+
+	password, generated, err := c.password(*passwordStdin)
+	if err != nil {
+		return err
+	}
+	user, err := c.backend.CreateUser(*username, *email, password, *role)
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		User     userInfo `json:"user"`
+		Password string   `json:"password,omitempty"`
+	}{User: user}
+	if generated {
+		result.Password = password
+	}
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Created user %s (id %d) with role %s\n", user.Username, user.ID, user.Role)
+		if generated {
+			fmt.Fprintf(w, "Password: %s\n", password)
+		}
+	})
+}
+
+func (c *ctl) resetPassword(args []string) error {
+	fs := flags("user reset-password")
+	username := fs.String("username", "", "")
+	passwordStdin := fs.Bool("password-stdin", false, "")
+	if fs.Parse(args) != nil || fs.NArg() > 0 || *username == "" {
+		return errUsage
+	}
+
+	password, generated, err := c.password(*passwordStdin)
+	if err != nil {
+		return err
+	}
+	if err := c.backend.SetPassword(*username, password); err != nil {
+		return err
+	}
+
+	result := struct {
+		Username string `json:"username"`
+		Password string `json:"password,omitempty"`
+	}{Username: *username}
+	if generated {
+		result.Password = password
+	}
+	return c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Reset password of %s, who is signed out everywhere\n", *username)
+		if generated {
+			fmt.Fprintf(w, "Password: %s\n", password)
+		}
+	})
+}
+
+func (c *ctl) setRole(args []string) error {
+	fs := flags("user set-role")
+	username := fs.String("username", "", "")
+	role := fs.String("role", "", "")
+	if fs.Parse(args) != nil || fs.NArg() > 0 || *username == "" || *role == "" {
+		return errUsage
+	}
+
+	user, err := c.backend.SetRole(*username, *role)
+	if err != nil {
+		return err
+	}
+	return c.print(struct {
+		User userInfo `json:"user"`
+	}{user}, func(w io.Writer) {
+		fmt.Fprintf(w, "Set role of %s to %s\n", user.Username, user.Role)
+	})
+}
+
+func (c *ctl) listPolicies(args []string) error {
+	fs := flags("policy list")
+	subject := fs.String("subject", "", "")
+	if fs.Parse(args) != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+
+	policies, err := c.backend.ListPolicies()
+	if err != nil {
+		return err
+	}
+	matching := [][]string{}
+	for _, rule := range policies {
+		if *subject == "" || (len(rule) > 0 && rule[0] == *subject) {
+			matching = append(matching, rule)
+		}
+	}
+
+	return c.print(struct {
+		Policies [][]string `json:"policies"`
+	}{matching}, func(w io.Writer) {
+		fmt.Fprintln(w, "SUBJECT\tDOMAIN\tOBJECT\tACTION")
+		for _, rule := range matching {
+			fmt.Fprintln(w, strings.Join(rule, "\t"))
+		}
+	})
+}
+
+// policyRule reads SUBJECT [DOMAIN] OBJECT ACTION, placing policies
+// without a domain in the global one
+func policyRule(args []string) ([]string, error) {
+	switch len(args) {
+	case 3:
+		return []string{args[0], "*", args[1], args[2]}, nil
+	case 4:
+		return args, nil
+	default:
+		return nil, errUsage
+	}
+}
+
+func (c *ctl) addPolicy(args []string) error {
+	rule, err := policyRule(args)
+	if err != nil {
+		return err
+	}
+	if err := c.backend.AddPolicy(rule); err != nil {
+		return err
+	}
+	return c.print(struct {
+		Policy []string `json:"policy"`
+	}{rule}, func(w io.Writer) {
+		fmt.Fprintf(w, "Added policy %s\n", strings.Join(rule, ", "))
+	})
+}
+
+func (c *ctl) removePolicy(args []string) error {
+	rule, err := policyRule(args)
+	if err != nil {
+		return err
+	}
+	if err := c.backend.RemovePolicy(rule); err != nil {
+		return err
+	}
+	return c.print(struct {
+		Policy []string `json:"policy"`
+	}{rule}, func(w io.Writer) {
+		fmt.Fprintf(w, "Removed policy %s\n", strings.Join(rule, ", "))
+	})
+}
+
+func (c *ctl) rotateKeys(args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+
+	key, err := c.backend.RotateKeys()
+	if err != nil {
+		return err
+	}
+	return c.print(struct {
+		Key store.SigningKey `json:"key"`
+	}{key}, func(w io.Writer) {
+		fmt.Fprintf(w, "Rotated signing key, new key %s (%s)\n", key.KID, key.Algorithm)
+	})
+}
+
+// exportAudit writes the matching audit events, as JSON Lines with -json
+func (c *ctl) exportAudit(args []string) error {
+	fs := flags("audit export")
+	var filter store.AuditFilter
+	fs.StringVar(&filter.Actor, "actor", "", "")
+	fs.StringVar(&filter.Impersonator, "impersonator", "", "")
+	fs.StringVar(&filter.Action, "action", "", "")
+	fs.StringVar(&filter.Target, "target", "", "")
+	fs.StringVar(&filter.Result, "result", "", "")
+	fs.StringVar(&filter.IP, "ip", "", "")
+	fs.StringVar(&filter.Since, "since", "", "")
+	fs.StringVar(&filter.Until, "until", "", "")
+	if fs.Parse(args) != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+	// Events appended during the export would shift the pages
+	if filter.Until == "" {
+		filter.Until = time.Now().Add(time.Second).UTC().Format(time.RFC3339)
+	}
+
+	if c.json {
+		encoder := json.NewEncoder(c.stdout)
+		return c.backend.ExportAudit(filter, func(event store.AuditEvent) error {
+			return encoder.Encode(event)
+		})
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tACTOR\tIMPERSONATOR\tACTION\tTARGET\tRESULT\tIP")
+	err := c.backend.ExportAudit(filter, func(event store.AuditEvent) error {
+		_, err := fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", event.ID, event.CreatedAt.Format(time.RFC3339),
+			event.Actor, event.Impersonator, event.Action, event.Target, event.Result, event.IP)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
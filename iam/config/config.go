@@ -0,0 +1,250 @@
+// Package config reads the settings shared by the iam server and iamctl
+// from the environment, so both hash, sign and store alike.
+package config
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"iam/auth"
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// Env returns the variable key, or fallback if it is unset
+func Env(key, fallback string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return fallback
+}
+
+// DurationEnv returns the variable key parsed as a duration
+func DurationEnv(key string, fallback time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration %q for %s, using %s", value, key, fallback)
+		return fallback
+	}
+	return d
+}
+
+// IntEnv returns the variable key parsed as a positive number
+func IntEnv(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Printf("Invalid number %q for %s, using %d", value, key, fallback)
+		return fallback
+	}
+	return n
+}
+
+// ListEnv returns the comma-separated values of the variable key
+func ListEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(Env(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// SecureCookies reports whether cookies are only sent over HTTPS. Unless
+// SESSION_COOKIE_SECURE says otherwise, that is the case when PUBLIC_URL
+// uses https.
+func SecureCookies() bool {
+	if value := Env("SESSION_COOKIE_SECURE", ""); value != "" {
+		return value == "true"
+	}
+	return strings.HasPrefix(Env("PUBLIC_URL", ""), "https://")
+}
+
+// CookieSameSite reads the SameSite mode of the session cookie
+func CookieSameSite() http.SameSite {
+	switch value := strings.ToLower(Env("SESSION_COOKIE_SAMESITE", "lax")); value {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	case "lax":
+		return http.SameSiteLaxMode
+	default:
+		log.Printf("Invalid SESSION_COOKIE_SAMESITE %q, using lax", value)
+		return http.SameSiteLaxMode
+	}
+}
+
+// OpenStore connects to the database configured by DB_DRIVER and DB_DSN
+func OpenStore() (*store.Store, error) {
+	return store.Open(Env("DB_DRIVER", "sqlite"), Env("DB_DSN", "auth.db"))
+}
+
+// Policy reads the authorization settings
+func Policy() policy.Config {
+	cfg := policy.Config{
+		// Self-registration always grants the default role, elevated roles need an invitation
+		DefaultRole:           Env("DEFAULT_ROLE", "user"),
+		GrantMaxDuration:      DurationEnv("GRANT_MAX_DURATION", 8*time.Hour),
+		BreakGlassMaxDuration: DurationEnv("BREAK_GLASS_MAX_DURATION", time.Hour),
+		// Only these roles can be taken without approval, none by default
+		BreakGlassRoles: ListEnv("BREAK_GLASS_ROLES"),
+		ABACLocation:    time.Local,
+	}
+	if name := Env("ABAC_TIMEZONE", ""); name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			log.Fatalf("Invalid ABAC_TIMEZONE: %v", err)
+		}
+		cfg.ABACLocation = loc
+	}
+	return cfg
+}
+
+// Auth reads the credential settings
+func Auth() auth.Config {
+	return auth.Config{
+		// Tokens are signed with asymmetric keys (RS256 or EdDSA) from the keyring
+		Issuer:              Env("JWT_ISSUER", "iam"),
+		SigningAlgorithm:    Env("JWT_SIGNING_ALG", "RS256"),
+		SigningKeyRetention: DurationEnv("SIGNING_KEY_RETENTION", 24*time.Hour),
+		PublicURL:           Env("PUBLIC_URL", "http://localhost:8080"),
+		TOTPIssuer:          Env("TOTP_ISSUER", "IAM"),
+
+		// Access tokens are short-lived, refresh tokens are used to obtain new ones
+		AccessTokenTTL:  DurationEnv("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: DurationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		InvitationTTL:   DurationEnv("INVITATION_TTL", 72*time.Hour),
+
+		// Email verification and password reset links are mailed to the user
+		EmailVerificationTTL: DurationEnv("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		PasswordResetTTL:     DurationEnv("PASSWORD_RESET_TTL", time.Hour),
+		SessionTTL:           DurationEnv("SESSION_TTL", 30*24*time.Hour),
+
+		// Admins impersonating a user do so for a limited time
+		ImpersonationTTL: DurationEnv("IMPERSONATION_TTL", 30*time.Minute),
+
+		// Failed logins back off exponentially and eventually lock the account
+		Throttle: auth.LoginThrottle{
+			UserBackoffAfter: IntEnv("LOGIN_BACKOFF_AFTER", 3),
+			IPBackoffAfter:   IntEnv("LOGIN_IP_BACKOFF_AFTER", 20),
+			BaseDelay:        DurationEnv("LOGIN_BACKOFF_BASE", time.Second),
+			MaxDelay:         DurationEnv("LOGIN_BACKOFF_MAX", 15*time.Minute),
+			LockoutAfter:     IntEnv("LOGIN_LOCKOUT_AFTER", 10),
+			LockoutDuration:  DurationEnv("LOGIN_LOCKOUT_DURATION", 30*time.Minute),
+			Window:           DurationEnv("LOGIN_ATTEMPT_WINDOW", time.Hour),
+		},
+
+		// bcrypt at cost 14 takes about a second of CPU, so only a few hashes
+		// may run at once and the rest wait briefly for a slot
+		HashConcurrency: IntEnv("PASSWORD_HASH_CONCURRENCY", runtime.NumCPU()),
+		HashQueueWait:   DurationEnv("PASSWORD_HASH_QUEUE_TIMEOUT", 5*time.Second),
+
+		// Changing the algorithm or cost upgrades hashes as users log in
+		HashAlgorithm: hashAlgorithm(),
+		BcryptCost:    IntEnv("BCRYPT_COST", 14),
+		Argon2: auth.Argon2Params{
+			Memory:      uint32(IntEnv("ARGON2_MEMORY_KIB", 64*1024)),
+			Iterations:  uint32(IntEnv("ARGON2_ITERATIONS", 3)),
+			Parallelism: uint8(min(IntEnv("ARGON2_PARALLELISM", 4), 255)),
+		},
+
+		Password: auth.PasswordPolicy{
+			MinLength:  IntEnv("PASSWORD_MIN_LENGTH", 8),
+			MinClasses: IntEnv("PASSWORD_MIN_CLASSES", 1),
+			Blocklist:  passwordBlocklist(),
+			History:    IntEnv("PASSWORD_HISTORY", 5),
+		},
+	}
+}
+
+// hashAlgorithm reads PASSWORD_HASH_ALG
+func hashAlgorithm() string {
+	alg := Env("PASSWORD_HASH_ALG", auth.HashBcrypt)
+	if alg != auth.HashBcrypt && alg != auth.HashArgon2id {
+		log.Fatalf("Unknown PASSWORD_HASH_ALG %q, expected bcrypt or argon2id", alg)
+	}
+	return alg
+}
+
+// passwordBlocklist loads the common passwords, extended with the
+// breached or banned ones in PASSWORD_BLOCKLIST_FILE
+func passwordBlocklist() map[string]struct{} {
+	blocklist, err := auth.LoadPasswordBlocklist(Env("PASSWORD_BLOCKLIST_FILE", ""))
+	if err != nil {
+		log.Fatalf("Failed to load PASSWORD_BLOCKLIST_FILE: %v", err)
+	}
+	return blocklist
+}
+
+// Mailer selects the mailer
+func Mailer() auth.Mailer {
+	from := Env("MAIL_FROM", "iam@localhost")
+
+	switch Env("MAILER", "log") {
+	case "smtp":
+		return &auth.SMTPMailer{
+			Host:     Env("SMTP_HOST", "localhost"),
+			Port:     Env("SMTP_PORT", "587"),
+			Username: Env("SMTP_USERNAME", ""),
+			Password: Env("SMTP_PASSWORD", ""),
+			From:     from,
+		}
+	case "log":
+		return &auth.LogMailer{Path: Env("MAIL_FILE", ""), From: from}
+	default:
+		log.Fatalf("Unknown MAILER %q, expected smtp or log", Env("MAILER", ""))
+		return nil
+	}
+}
+
+// SessionBackend selects where server-side sessions are kept
+func SessionBackend(db *gorm.DB, ttl time.Duration) auth.SessionBackend {
+	switch Env("SESSION_STORE", "gorm") {
+	case "gorm":
+		return auth.GormSessionBackend{DB: db}
+	case "redis":
+		options, err := redis.ParseURL(Env("REDIS_URL", "redis://localhost:6379/0"))
+		if err != nil {
+			log.Fatalf("Invalid REDIS_URL: %v", err)
+		}
+		client := redis.NewClient(options)
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		return auth.RedisSessionBackend{Client: client, TTL: ttl}
+	default:
+		log.Fatalf("Unknown SESSION_STORE %q, expected gorm or redis", Env("SESSION_STORE", ""))
+		return nil
+	}
+}
+
+// Webhook reads the webhook delivery settings
+func Webhook() webhook.Config {
+	return webhook.Config{
+		MaxAttempts: IntEnv("WEBHOOK_MAX_ATTEMPTS", 8),
+		BaseDelay:   DurationEnv("WEBHOOK_RETRY_DELAY", 30*time.Second),
+		MaxDelay:    DurationEnv("WEBHOOK_RETRY_MAX_DELAY", time.Hour),
+		Timeout:     DurationEnv("WEBHOOK_TIMEOUT", 10*time.Second),
+	}
+}
@@ -0,0 +1,184 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// ownerFunc returns the ID of the user owning the resource a request
+// targets, 0 if the request targets no single resource
+type ownerFunc func(c *gin.Context) (uint, error)
+
+// Requests about owned resources are denied when ownership cannot be
+// compared, since an unknown owner and a caller without a user would
+// otherwise both be 0
+var (
+	errUnknownOwner  = errors.New("owner of the resource is unknown")
+	errNoSubjectUser = errors.New("owned resources need a user as subject")
+)
+
+// useABAC selects the ABAC model for every route in group. owner may be nil
+// for resources without an owner.
+func (s *Server) useABAC(group *gin.RouterGroup, owner ownerFunc) {
+	s.abacGroups[group.BasePath()] = owner
+}
+
+// abacGroupFor returns the owner lookup of the ABAC group a route belongs to
+func (s *Server) abacGroupFor(route string) (ownerFunc, bool) {
+	if route == "" {
+		return nil, false
+	}
+	for base, owner := range s.abacGroups {
+		if route == base || strings.HasPrefix(route, base+"/") {
+			return owner, true
+		}
+	}
+	return nil, false
+}
+
+// userOwner resolves the :id route parameter, a username, to a user ID
+func (s *Server) userOwner(c *gin.Context) (uint, error) {
+	if c.Param("id") == "" {
+		return 0, nil
+	}
+	var user store.User
+	if result := s.db.Select("id").Where("username = ?", c.Param("id")).First(&user); result.Error != nil {
+		return 0, errUnknownOwner
+	}
+	return user.ID, nil
+}
+
+// requestContext collects the attributes of a request for the ABAC model
+func (s *Server) requestContext(c *gin.Context, role string, user *store.User, owner ownerFunc) (policy.RequestContext, error) {
+	now := time.Now().In(s.policy.Config.ABACLocation)
+	ctx := policy.RequestContext{
+		Role:    role,
+		Org:     activeOrg(c),
+		IP:      c.ClientIP(),
+		Hour:    now.Hour(),
+		Weekday: int(now.Weekday()),
+	}
+	if user != nil {
+		ctx.UserID = user.ID
+		ctx.Username = user.Username
+		ctx.Email = user.Email
+		ctx.Verified = user.EmailVerified
+		ctx.Role = user.Role
+	}
+	if owner != nil {
+		ownerID, err := owner(c)
+		if err != nil {
+			return ctx, err
+		}
+		if ownerID != 0 && ctx.UserID == 0 {
+			return ctx, errNoSubjectUser
+		}
+		ctx.OwnerID = ownerID
+	}
+	return ctx, nil
+}
+
+// decideABAC checks a request against the ABAC model. Requests whose
+// owner cannot be compared with the caller are denied.
+func (s *Server) decideABAC(c *gin.Context, role string, user *store.User, owner ownerFunc) (policy.Decision, error) {
+	ctx, err := s.requestContext(c, role, user, owner)
+	if err != nil {
+		return policy.Decision{}, nil
+	}
+	return s.policy.DecideABAC(role, user, c.Request.URL.Path, c.Request.Method, ctx)
+}
+
+// listABACPolicies returns the ABAC policies and the route groups using them
+func (s *Server) listABACPolicies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policies, _ := s.policy.ABAC.GetPolicy()
+
+		groups := make([]string, 0, len(s.abacGroups))
+		for base := range s.abacGroups {
+			groups = append(groups, base)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"policies": policies,
+			"groups":   groups,
+		})
+	}
+}
+
+// addABACPolicy adds a policy with a condition
+func (s *Server) addABACPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body []string
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rule, err := s.policy.ABACPolicy(body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		added, err := s.policy.ABAC.AddPolicy(rule[0], rule[1], rule[2], rule[3], rule[4])
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add policy"})
+			return
+		}
+		if !added {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Policy already exists"})
+			return
+		}
+
+		s.audit(c, "abac.policy.add", rule[0], store.AuditSuccess, nil, rule)
+		s.emit(webhook.EventPolicyChanged, gin.H{"change": "add", "model": "abac", "policy": rule})
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Policy added successfully",
+			"policy":  rule,
+		})
+	}
+}
+
+// removeABACPolicy removes a policy with a condition
+func (s *Server) removeABACPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rule []string
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(rule) == 4 {
+			rule = []string{rule[0], policy.GlobalDomain, rule[1], rule[2], rule[3]}
+		}
+		if len(rule) != 5 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Policy must have subject, object, action and condition"})
+			return
+		}
+
+		removed, err := s.policy.ABAC.RemovePolicy(rule[0], rule[1], rule[2], rule[3], rule[4])
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove policy"})
+			return
+		}
+		if !removed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Policy not found"})
+			return
+		}
+
+		s.audit(c, "abac.policy.remove", rule[0], store.AuditSuccess, rule, nil)
+		s.emit(webhook.EventPolicyChanged, gin.H{"change": "remove", "model": "abac", "policy": rule})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Policy removed successfully",
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/auth"
+	"iam/store"
+)
+
+// listAPIKeys returns the API keys of the authenticated user
+func (s *Server) listAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		var keys []store.APIKey
+		s.db.Where("user_id = ?", user.ID).Order("created_at desc").Find(&keys)
+
+		c.JSON(http.StatusOK, gin.H{
+			"keys": keys,
+		})
+	}
+}
+
+// createAPIKey issues an API key for the authenticated user
+func (s *Server) createAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		s.respondWithNewAPIKey(c, user.ID)
+	}
+}
+
+// respondWithNewAPIKey creates a key from the request body for a user
+func (s *Server) respondWithNewAPIKey(c *gin.Context, userID uint) {
+	var keyDTO auth.APIKeyDTO
+	if err := c.ShouldBindJSON(&keyDTO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, rawKey, err := s.auth.CreateAPIKey(userID, keyDTO)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit(c, "apikey.create", "apikey:"+strconv.FormatUint(uint64(key.ID), 10), store.AuditSuccess, nil, key)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created successfully",
+		"api_key": rawKey,
+		"key":     key,
+	})
+}
+
+// deleteAPIKey revokes one of the authenticated user's API keys
+func (s *Server) deleteAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		if err := s.auth.RevokeAPIKey(user.ID, c.Param("id")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+
+		s.audit(c, "apikey.revoke", "apikey:"+c.Param("id"), store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "API key revoked successfully",
+		})
+	}
+}
+
+// serviceAccountByID loads the service account from the :id route parameter
+func (s *Server) serviceAccountByID(c *gin.Context) (store.User, bool) {
+	var user store.User
+	if result := s.db.Where("service_account = ?", true).First(&user, c.Param("id")); result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+		return store.User{}, false
+	}
+	return user, true
+}
+
+// listServiceAccounts returns all service account users
+func (s *Server) listServiceAccounts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var users []store.User
+		s.db.Where("service_account = ?", true).Find(&users)
+
+		c.JSON(http.StatusOK, gin.H{
+			"service_accounts": users,
+		})
+	}
+}
+
+// createServiceAccount creates a user that has no password and can only
+// authenticate with API keys
+func (s *Server) createServiceAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var accountDTO struct {
+			Username string `json:"username" binding:"required"`
+			Email    string `json:"email"`
+			Role     string `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&accountDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var role store.Role
+		if result := s.db.Where("name = ?", accountDTO.Role).First(&role); result.Error != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role does not exist"})
+			return
+		}
+
+		var existingUser store.User
+		if result := s.db.Where("username = ?", accountDTO.Username).First(&existingUser); result.Error == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username already exists"})
+			return
+		}
+
+		// Emails are unique, so accounts without one get a non-routable address
+		if accountDTO.Email == "" {
+			accountDTO.Email = accountDTO.Username + "@service-account.invalid"
+		}
+
+		user := store.User{
+			Username:       accountDTO.Username,
+			Email:          accountDTO.Email,
+			Role:           role.Name,
+			ServiceAccount: true,
+		}
+		if result := s.db.Create(&user); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+			return
+		}
+		if err := s.policy.AssignRole(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+			return
+		}
+
+		s.audit(c, "service_account.create", user.Username, store.AuditSuccess, nil, gin.H{"role": user.Role})
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":         "Service account created successfully",
+			"service_account": user,
+		})
+	}
+}
+
+// listServiceAccountKeys returns the API keys of a service account
+func (s *Server) listServiceAccountKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.serviceAccountByID(c)
+		if !ok {
+			return
+		}
+
+		var keys []store.APIKey
+		s.db.Where("user_id = ?", user.ID).Order("created_at desc").Find(&keys)
+
+		c.JSON(http.StatusOK, gin.H{
+			"keys": keys,
+		})
+	}
+}
+
+// createServiceAccountKey issues an API key for a service account
+func (s *Server) createServiceAccountKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.serviceAccountByID(c)
+		if !ok {
+			return
+		}
+
+		s.respondWithNewAPIKey(c, user.ID)
+	}
+}
+
+// deleteServiceAccountKey revokes an API key of a service account
+func (s *Server) deleteServiceAccountKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.serviceAccountByID(c)
+		if !ok {
+			return
+		}
+
+		if err := s.auth.RevokeAPIKey(user.ID, c.Param("keyID")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+
+		s.audit(c, "apikey.revoke", "apikey:"+c.Param("keyID"), store.AuditSuccess, nil, gin.H{"service_account": user.Username})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "API key revoked successfully",
+		})
+	}
+}
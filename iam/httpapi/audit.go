@@ -0,0 +1,132 @@
+package httpapi
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/store"
+)
+
+// audit records an event performed within a request. The actor is the
+// authenticated user or client of the request.
+func (s *Server) audit(c *gin.Context, action, target, result string, before, after interface{}) {
+	event := store.AuditEvent{
+		Actor:     "anonymous",
+		Action:    action,
+		Target:    target,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Result:    result,
+		Before:    store.AuditJSON(before),
+		After:     store.AuditJSON(after),
+	}
+
+	if user, exists := currentUser(c); exists {
+		event.ActorID = &user.ID
+		event.Actor = user.Username
+		if impersonator, exists := c.Get("impersonator"); exists {
+			event.Impersonator = impersonator.(store.User).Username
+		}
+	} else if client, exists := c.Get("client"); exists {
+		event.Actor = "client:" + client.(store.OAuthClient).ClientID
+	}
+
+	if err := s.store.AppendAuditEvent(event); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// bindAuditFilter reads the filter from the query string with sane paging
+func bindAuditFilter(c *gin.Context) (store.AuditFilter, error) {
+	var filter store.AuditFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		return store.AuditFilter{}, err
+	}
+
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 500 {
+		filter.PageSize = 50
+	}
+	return filter, nil
+}
+
+// listAuditEvents returns audit events matching the query filters
+func (s *Server) listAuditEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := bindAuditFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		events, total, err := s.store.QueryAuditEvents(filter)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"events":    events,
+			"total":     total,
+			"page":      filter.Page,
+			"page_size": filter.PageSize,
+		})
+	}
+}
+
+// verifyAuditEvents checks the integrity of the audit log
+func (s *Server) verifyAuditEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		brokenAt, checked, err := s.store.VerifyAuditChain()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit log"})
+			return
+		}
+
+		response := gin.H{
+			"valid":   brokenAt == 0,
+			"checked": checked,
+		}
+		if brokenAt != 0 {
+			response["broken_at"] = brokenAt
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// auditPage renders the audit log in the admin dashboard
+func (s *Server) auditPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := bindAuditFilter(c)
+		if err != nil {
+			filter = store.AuditFilter{Page: 1, PageSize: 50}
+		}
+
+		events, total, err := s.store.QueryAuditEvents(filter)
+		if err != nil {
+			events = nil
+		}
+
+		s.render(c, http.StatusOK, "audit.html", gin.H{
+			"title":    "Audit Log",
+			"events":   events,
+			"filter":   filter,
+			"total":    total,
+			"prevPage": filter.Page - 1,
+			"nextPage": nextPage(filter.Page, filter.PageSize, total),
+			"error":    err,
+		})
+	}
+}
+
+// nextPage returns the next page number, or 0 on the last page
+func nextPage(page, pageSize int, total int64) int {
+	if int64(page*pageSize) >= total {
+		return 0
+	}
+	return page + 1
+}
@@ -0,0 +1,352 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"iam/auth"
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// UserDTO for registration. New users get the default role unless they
+// present an invitation granting another one.
+type UserDTO struct {
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	Email      string `json:"email" binding:"required,email"`
+	Invitation string `json:"invitation"`
+}
+
+// LoginDTO for login requests
+type LoginDTO struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// completeLogin starts a session for the user and responds with a new token pair
+func (s *Server) completeLogin(c *gin.Context, user store.User, extra gin.H) {
+	token, refreshToken, err := s.auth.IssueTokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Set session
+	session := sessions.Default(c)
+	session.Set("user_id", user.ID)
+	session.Set("login_at", time.Now().Unix())
+	session.Delete(csrfSessionKey)
+	session.Save()
+
+	s.auth.ResetLoginFailures(user.Username)
+
+	c.Set("user", user)
+	s.audit(c, "auth.login", user.Username, store.AuditSuccess, nil, nil)
+
+	response := gin.H{
+		"message":       "Login successful",
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(s.auth.Config.AccessTokenTTL.Seconds()),
+		"user": gin.H{
+			"username": user.Username,
+			"email":    user.Email,
+			"role":     user.Role,
+		},
+	}
+	for k, v := range extra {
+		response[k] = v
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// register creates a new user
+func (s *Server) register() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var userDTO UserDTO
+		if err := c.ShouldBindJSON(&userDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Check if username already exists, deleted users keep theirs
+		var existingUser store.User
+		if result := s.db.Unscoped().Where("username = ?", userDTO.Username).First(&existingUser); result.Error == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username already exists"})
+			return
+		}
+
+		// Check if email already exists
+		if result := s.db.Unscoped().Where("email = ?", userDTO.Email).First(&existingUser); result.Error == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email already exists"})
+			return
+		}
+
+		// Roles other than the default can only be granted by an invitation
+		role := s.policy.Config.DefaultRole
+		var invitation *store.Invitation
+		if userDTO.Invitation != "" {
+			found, err := s.auth.FindInvitation(userDTO.Invitation, userDTO.Email)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			invitation = &found
+			role = found.Role
+		}
+
+		var roleRecord store.Role
+		if result := s.db.Where("name = ?", role).First(&roleRecord); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Registration role does not exist"})
+			return
+		}
+
+		// Check the password against the policy and hash it
+		candidate := store.User{Username: userDTO.Username, Email: userDTO.Email}
+		if err := s.auth.ValidatePassword(candidate, userDTO.Password); err != nil {
+			passwordError(c, err)
+			return
+		}
+		hashedPassword, err := s.auth.HashPassword(userDTO.Password)
+		if errors.Is(err, auth.ErrPasswordHashBusy) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server busy, try again later"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+
+		// Create user
+		user := store.User{
+			Username:     userDTO.Username,
+			PasswordHash: hashedPassword,
+			Email:        userDTO.Email,
+			Role:         role,
+			// Invitations are mailed, so accepting one proves the address
+			EmailVerified: invitation != nil,
+		}
+		if user.EmailVerified {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			if invitation != nil {
+				return auth.AcceptInvitation(tx, *invitation, user)
+			}
+			return nil
+		})
+		if errors.Is(err, auth.ErrInvalidInvitation) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err == nil {
+			err = s.policy.AssignRole(user)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+
+		s.audit(c, "auth.register", user.Username, store.AuditSuccess, nil, gin.H{"email": user.Email, "role": user.Role})
+		s.emit(webhook.EventUserRegistered, gin.H{"user": webhookUser(user), "invited": invitation != nil})
+
+		if !user.EmailVerified {
+			if err := s.auth.SendVerificationEmail(user); err != nil {
+				log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+			}
+		}
+
+		// Generate JWT and refresh tokens
+		token, refreshToken, err := s.auth.IssueTokens(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		// Set session
+		session := sessions.Default(c)
+		session.Set("user_id", user.ID)
+		session.Set("login_at", time.Now().Unix())
+		session.Delete(csrfSessionKey)
+		session.Save()
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":       "User registered successfully",
+			"token":         token,
+			"refresh_token": refreshToken,
+			"expires_in":    int(s.auth.Config.AccessTokenTTL.Seconds()),
+			"user": gin.H{
+				"username": user.Username,
+				"email":    user.Email,
+				"role":     user.Role,
+			},
+		})
+	}
+}
+
+// login checks the credentials of a user and starts a session
+func (s *Server) login() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var loginDTO LoginDTO
+		if err := c.ShouldBindJSON(&loginDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Throttle repeated failures before doing any password hashing
+		if wait, err := s.auth.CheckLoginAllowed(loginDTO.Username, c.ClientIP()); err != nil {
+			s.audit(c, "auth.login", loginDTO.Username, store.AuditFailure, nil, gin.H{"reason": err.Error()})
+			throttledLogin(c, wait, err)
+			return
+		}
+
+		// Find user by username
+		var user store.User
+		if result := s.db.Where("username = ?", loginDTO.Username).First(&user); result.Error != nil {
+			s.auth.RecordLoginFailure(loginDTO.Username, c.ClientIP())
+			s.audit(c, "auth.login", loginDTO.Username, store.AuditFailure, nil, gin.H{"reason": "unknown user"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+
+		// Check password, service accounts can only use API keys
+		valid := false
+		if !user.ServiceAccount {
+			var err error
+			if valid, err = s.auth.CheckPasswordHash(loginDTO.Password, user.PasswordHash); err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server busy, try again later"})
+				return
+			}
+		}
+		if valid && user.Disabled {
+			s.audit(c, "auth.login", user.Username, store.AuditFailure, nil, gin.H{"reason": "account disabled"})
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+			return
+		}
+		if !valid {
+			if s.auth.RecordLoginFailure(user.Username, c.ClientIP()) {
+				s.audit(c, "user.lock", user.Username, store.AuditSuccess, nil, gin.H{"duration": s.auth.Config.Throttle.LockoutDuration.String()})
+				s.emit(webhook.EventUserLockedOut, gin.H{
+					"user":         webhookUser(user),
+					"locked_until": time.Now().Add(s.auth.Config.Throttle.LockoutDuration).UTC(),
+				})
+			}
+			s.audit(c, "auth.login", user.Username, store.AuditFailure, nil, gin.H{"reason": "invalid password"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+
+		// The password is known now, so an outdated hash can be replaced
+		if err := s.auth.UpgradePasswordHash(&user, loginDTO.Password); err != nil {
+			log.Printf("Failed to upgrade password hash of %s: %v", user.Username, err)
+		}
+
+		// Users with MFA get a challenge instead of a token
+		if user.MFAEnabled || s.policy.MFARequired(policy.AuthSubject(user)) {
+			mfaToken, err := s.auth.SignPurposeToken(auth.PurposeMFA, user.Username, auth.MFAChallengeTTL)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+				return
+			}
+
+			s.audit(c, "auth.mfa.challenge", user.Username, store.AuditSuccess, nil, nil)
+			c.JSON(http.StatusOK, gin.H{
+				"message":             "MFA required",
+				"mfa_required":        true,
+				"mfa_token":           mfaToken,
+				"enrollment_required": !user.MFAEnabled,
+			})
+			return
+		}
+
+		s.completeLogin(c, user, nil)
+	}
+}
+
+// refresh exchanges a refresh token for a new token pair
+func (s *Server) refresh() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var refreshDTO struct {
+			RefreshToken string `json:"refresh_token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&refreshDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		reused, user, refreshToken, err := s.auth.RotateRefreshToken(refreshDTO.RefreshToken, "")
+		if err != nil {
+			if errors.Is(err, auth.ErrRefreshTokenReused) {
+				var owner store.User
+				s.db.Select("username").First(&owner, reused.UserID)
+				s.audit(c, "auth.refresh", owner.Username, store.AuditFailure, nil, gin.H{"reason": "refresh token reused"})
+			}
+			if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+			return
+		}
+
+		token, err := s.auth.GenerateJWT(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"token":         token,
+			"refresh_token": refreshToken,
+			"expires_in":    int(s.auth.Config.AccessTokenTTL.Seconds()),
+			"user": gin.H{
+				"username": user.Username,
+				"email":    user.Email,
+				"role":     user.Role,
+			},
+		})
+	}
+}
+
+// logout revokes the tokens of the request and ends the session
+func (s *Server) logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Clear()
+		session.Save()
+
+		// Revoke the presented access token and refresh token family
+		if claims, exists := c.Get("claims"); exists {
+			s.auth.RevokeAccessToken(claims.(*auth.Claims), "logout")
+		}
+
+		var logoutDTO struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if c.ShouldBindJSON(&logoutDTO) == nil && logoutDTO.RefreshToken != "" {
+			s.auth.RevokeRefreshToken(logoutDTO.RefreshToken)
+		}
+
+		if user, exists := currentUser(c); exists {
+			s.audit(c, "auth.logout", user.Username, store.AuditSuccess, nil, nil)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Logout successful",
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"iam/store"
+)
+
+// The user directory is paged with cursors rather than offsets, so pages
+// stay consistent while users are added or removed. A cursor holds the sort
+// key and ID of the last user on a page.
+
+var errInvalidDirectoryQuery = errors.New("invalid query")
+
+// User statuses to filter the directory by. Deleted users are soft-deleted
+// and only listed to administrators. Other users only see active users,
+// search them by username and cannot filter or sort by email or role, so
+// the directory does not reveal account states, email addresses or who
+// holds which role.
+const (
+	userStatusActive   = "active"
+	userStatusDisabled = "disabled"
+	userStatusDeleted  = "deleted"
+)
+
+// directorySorts maps the sort keys of the directory to their columns
+var directorySorts = map[string]string{
+	"id":         "id",
+	"username":   "username",
+	"email":      "email",
+	"created_at": "created_at",
+}
+
+// directoryQuery selects a page of the user directory
+type directoryQuery struct {
+	Q             string `form:"q"` // searches usernames, and emails for administrators
+	Username      string `form:"username"`
+	Role          string `form:"role"`
+	EmailDomain   string `form:"email_domain"`
+	CreatedAfter  string `form:"created_after"`
+	CreatedBefore string `form:"created_before"`
+	Status        string `form:"status"`
+	Sort          string `form:"sort"` // a key of directorySorts, with - for descending
+	Limit         int    `form:"limit"`
+	Cursor        string `form:"cursor"`
+}
+
+// directoryCursor is the position after the last user of a page
+type directoryCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    uint   `json:"id"`
+}
+
+// queryDirectory returns a page of users and the cursor of the next page,
+// empty on the last one. admin selects the administrators' directory.
+func (s *Server) queryDirectory(c *gin.Context, admin bool) ([]store.User, string, error) {
+	var q directoryQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errInvalidDirectoryQuery, err)
+	}
+	if q.Limit < 1 || q.Limit > 200 {
+		q.Limit = 50
+	}
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	descending := strings.HasPrefix(q.Sort, "-")
+	column, ok := directorySorts[strings.TrimPrefix(q.Sort, "-")]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: cannot sort by %q", errInvalidDirectoryQuery, q.Sort)
+	}
+
+	if !admin {
+		if q.Status != "" && q.Status != userStatusActive {
+			return nil, "", fmt.Errorf("%w: unknown status %q", errInvalidDirectoryQuery, q.Status)
+		}
+		if q.EmailDomain != "" {
+			return nil, "", fmt.Errorf("%w: cannot filter by email_domain", errInvalidDirectoryQuery)
+		}
+		if q.Role != "" {
+			return nil, "", fmt.Errorf("%w: cannot filter by role", errInvalidDirectoryQuery)
+		}
+		if column == "email" {
+			return nil, "", fmt.Errorf("%w: cannot sort by %q", errInvalidDirectoryQuery, q.Sort)
+		}
+		q.Status = userStatusActive
+	}
+
+	query := s.db.Model(&store.User{})
+	switch q.Status {
+	case "":
+	case userStatusActive:
+		query = query.Where("disabled = ?", false)
+	case userStatusDisabled:
+		query = query.Where("disabled = ?", true)
+	case userStatusDeleted:
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	default:
+		return nil, "", fmt.Errorf("%w: unknown status %q", errInvalidDirectoryQuery, q.Status)
+	}
+
+	if q.Q != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(q.Q)) + "%"
+		if admin {
+			query = query.Where(`(LOWER(username) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!')`, pattern, pattern)
+		} else {
+			query = query.Where(`LOWER(username) LIKE ? ESCAPE '!'`, pattern)
+		}
+	}
+	if q.Username != "" {
+		query = query.Where("username = ?", q.Username)
+	}
+	if q.Role != "" {
+		// Roles are held through grouping rules, which also carry
+		// inherited roles and active grants
+		ids, err := s.policy.UserIDsWithRole(q.Role)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where("id IN ?", ids)
+	}
+	if q.EmailDomain != "" {
+		domain := strings.ToLower(strings.TrimPrefix(q.EmailDomain, "@"))
+		query = query.Where(`LOWER(email) LIKE ? ESCAPE '!'`, "%@"+likeEscaper.Replace(domain))
+	}
+	for _, bound := range []struct {
+		value, op string
+	}{{q.CreatedAfter, ">="}, {q.CreatedBefore, "<"}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := parseDirectoryTime(bound.value)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where("created_at "+bound.op+" ?", t)
+	}
+
+	// Continue after the cursor, with the ID breaking ties of the sort key
+	if q.Cursor != "" {
+		cursor, err := decodeDirectoryCursor(q.Cursor)
+		if err != nil || cursor.Sort != q.Sort {
+			return nil, "", fmt.Errorf("%w: bad cursor", errInvalidDirectoryQuery)
+		}
+		op := ">"
+		if descending {
+			op = "<"
+		}
+		if column == "id" {
+			query = query.Where("id "+op+" ?", cursor.ID)
+		} else {
+			var value interface{} = cursor.Value
+			if column == "created_at" {
+				if value, err = time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+					return nil, "", fmt.Errorf("%w: bad cursor", errInvalidDirectoryQuery)
+				}
+			}
+			query = query.Where("("+column+" "+op+" ? OR ("+column+" = ? AND id "+op+" ?))", value, value, cursor.ID)
+		}
+	}
+
+	order := column
+	if descending {
+		order = column + " DESC, id DESC"
+	} else if column != "id" {
+		order = column + ", id"
+	}
+
+	// One user more than the page tells whether there is a next page
+	var users []store.User
+	if err := query.Order(order).Limit(q.Limit + 1).Find(&users).Error; err != nil {
+		return nil, "", err
+	}
+	if len(users) <= q.Limit {
+		return users, "", nil
+	}
+	users = users[:q.Limit]
+
+	last := users[len(users)-1]
+	next := directoryCursor{Sort: q.Sort, ID: last.ID}
+	switch column {
+	case "username":
+		next.Value = last.Username
+	case "email":
+		next.Value = last.Email
+	case "created_at":
+		next.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return users, encodeDirectoryCursor(next), nil
+}
+
+// parseDirectoryTime accepts RFC 3339 times and plain dates
+func parseDirectoryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: %q is not an RFC 3339 time or date", errInvalidDirectoryQuery, value)
+}
+
+func encodeDirectoryCursor(cursor directoryCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeDirectoryCursor(value string) (directoryCursor, error) {
+	var cursor directoryCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+// userStatus returns the directory status of a user
+func userStatus(user store.User) string {
+	switch {
+	case user.DeletedAt.Valid:
+		return userStatusDeleted
+	case user.Disabled:
+		return userStatusDisabled
+	}
+	return userStatusActive
+}
+
+// adminUserView describes a user to administrators
+func adminUserView(user store.User) gin.H {
+	view := gin.H{
+		"id":              user.ID,
+		"username":        user.Username,
+		"email":           user.Email,
+		"role":            user.Role,
+		"status":          userStatus(user),
+		"email_verified":  user.EmailVerified,
+		"mfa_enabled":     user.MFAEnabled,
+		"service_account": user.ServiceAccount,
+		"external_id":     user.ExternalID,
+		"created_at":      user.CreatedAt,
+		"updated_at":      user.UpdatedAt,
+	}
+	if user.DeletedAt.Valid {
+		view["deleted_at"] = user.DeletedAt.Time
+	}
+	return view
+}
+
+// deletedUserByID loads a soft-deleted user by the ID of the request
+func (s *Server) deletedUserByID(c *gin.Context) (store.User, error) {
+	var user store.User
+	err := s.db.Unscoped().Where("deleted_at IS NOT NULL").First(&user, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return store.User{}, errUserNotFound
+	}
+	return user, err
+}
@@ -0,0 +1,275 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/policy"
+	"iam/store"
+)
+
+// grantError responds to a failed grant operation
+func grantError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, policy.ErrGrantNotFound), errors.Is(err, policy.ErrGrantNotActive):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, policy.ErrUnknownRole), errors.Is(err, policy.ErrGrantHeld),
+		errors.Is(err, policy.ErrGrantDuration):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, policy.ErrGrantSelf), errors.Is(err, policy.ErrBreakGlassRole):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update grant"})
+	}
+}
+
+// grantDTO is a request for a temporary role
+type grantDTO struct {
+	Role     string `json:"role" binding:"required"`
+	Reason   string `json:"reason" binding:"required"`
+	Duration string `json:"duration" binding:"required"` // e.g. "2h"
+}
+
+// requestGrant asks for a temporary role for the current user
+func (s *Server) requestGrant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		var request grantDTO
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		grant, err := s.policy.NewGrant(user, request.Role, request.Reason, request.Duration, s.policy.Config.GrantMaxDuration)
+		if err != nil {
+			grantError(c, err)
+			return
+		}
+		if result := s.db.Create(&grant); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request grant"})
+			return
+		}
+
+		s.audit(c, "grant.request", user.Username, store.AuditSuccess, nil, grant)
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Grant requested, waiting for approval",
+			"grant":   grant,
+		})
+	}
+}
+
+// breakGlassGrant assigns a temporary role to the current user at once and
+// flags the grant for review
+func (s *Server) breakGlassGrant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		var request grantDTO
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		grant, err := s.policy.NewBreakGlassGrant(user, request.Role, request.Reason, request.Duration)
+		if err != nil {
+			grantError(c, err)
+			return
+		}
+		if result := s.db.Create(&grant); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create grant"})
+			return
+		}
+		if err := s.policy.ActivateGrant(&grant, user.ID); err != nil {
+			grantError(c, err)
+			return
+		}
+
+		s.audit(c, "grant.break_glass", user.Username, store.AuditSuccess, nil, grant)
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Break-glass grant active, it will be reviewed",
+			"grant":   grant,
+		})
+	}
+}
+
+// listMyGrants returns the grants of the current user
+func (s *Server) listMyGrants() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		var grants []store.RoleGrant
+		s.db.Where("user_id = ?", user.ID).Order("created_at desc").Find(&grants)
+
+		c.JSON(http.StatusOK, gin.H{
+			"grants": grants,
+		})
+	}
+}
+
+// listGrants returns grants, optionally filtered by status or pending review
+func (s *Server) listGrants() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := s.db.Order("created_at desc")
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		if c.Query("needs_review") == "true" {
+			query = query.Where("needs_review = ?", true)
+		}
+
+		var grants []store.RoleGrant
+		query.Find(&grants)
+
+		c.JSON(http.StatusOK, gin.H{
+			"grants": grants,
+		})
+	}
+}
+
+// decidableGrant loads the grant in the :id route parameter and checks the
+// current user may decide on it
+func (s *Server) decidableGrant(c *gin.Context) (store.RoleGrant, store.User, bool) {
+	approver, exists := currentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return store.RoleGrant{}, store.User{}, false
+	}
+
+	var grant store.RoleGrant
+	if result := s.db.First(&grant, c.Param("id")); result.Error != nil {
+		grantError(c, policy.ErrGrantNotFound)
+		return store.RoleGrant{}, store.User{}, false
+	}
+	if grant.UserID == approver.ID {
+		grantError(c, policy.ErrGrantSelf)
+		return store.RoleGrant{}, store.User{}, false
+	}
+	return grant, approver, true
+}
+
+// approveGrant accepts a pending grant and assigns its role
+func (s *Server) approveGrant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		grant, approver, ok := s.decidableGrant(c)
+		if !ok {
+			return
+		}
+
+		if err := s.policy.ActivateGrant(&grant, approver.ID); err != nil {
+			grantError(c, err)
+			return
+		}
+
+		s.audit(c, "grant.approve", grant.Username, store.AuditSuccess, gin.H{"status": policy.GrantPending}, grant)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Grant approved",
+			"grant":   grant,
+		})
+	}
+}
+
+// denyGrant rejects a pending grant
+func (s *Server) denyGrant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		grant, approver, ok := s.decidableGrant(c)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		result := s.db.Model(&store.RoleGrant{}).
+			Where("id = ? AND status = ?", grant.ID, policy.GrantPending).
+			Updates(map[string]interface{}{
+				"status":        policy.GrantDenied,
+				"decided_by_id": approver.ID,
+				"decided_at":    now,
+			})
+		if result.Error != nil {
+			grantError(c, result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			grantError(c, policy.ErrGrantNotFound)
+			return
+		}
+
+		s.audit(c, "grant.deny", grant.Username, store.AuditSuccess, gin.H{"status": policy.GrantPending}, gin.H{"grant": grant.ID, "status": policy.GrantDenied})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Grant denied",
+		})
+	}
+}
+
+// revokeGrant ends an active grant before it expires
+func (s *Server) revokeGrant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var grant store.RoleGrant
+		if result := s.db.First(&grant, c.Param("id")); result.Error != nil {
+			grantError(c, policy.ErrGrantNotFound)
+			return
+		}
+
+		if err := s.policy.EndGrant(&grant, policy.GrantRevoked); err != nil {
+			grantError(c, err)
+			return
+		}
+
+		s.audit(c, "grant.revoke", grant.Username, store.AuditSuccess, gin.H{"status": policy.GrantActive}, gin.H{"grant": grant.ID, "status": policy.GrantRevoked})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Grant revoked",
+		})
+	}
+}
+
+// reviewGrant marks a break-glass grant as reviewed
+func (s *Server) reviewGrant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		grant, reviewer, ok := s.decidableGrant(c)
+		if !ok {
+			return
+		}
+
+		result := s.db.Model(&store.RoleGrant{}).
+			Where("id = ? AND needs_review = ?", grant.ID, true).
+			Updates(map[string]interface{}{
+				"needs_review":   false,
+				"reviewed_by_id": reviewer.ID,
+				"reviewed_at":    time.Now(),
+			})
+		if result.Error != nil {
+			grantError(c, result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Grant does not need review"})
+			return
+		}
+
+		s.audit(c, "grant.review", grant.Username, store.AuditSuccess, nil, gin.H{"grant": grant.ID, "role": grant.Role})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Grant reviewed",
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"iam/auth"
+	"iam/policy"
+	"iam/store"
+)
+
+// Session keys of an admin impersonating a user in the browser. The
+// session stays the admin's, so it is neither listed nor revoked as one
+// of the user's sessions.
+const (
+	sessionImpersonateID    = "impersonate_id"
+	sessionImpersonateUntil = "impersonate_until"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// impersonatePath is the route an admin needs access to for impersonating
+// target, checked again on every impersonated request
+func impersonatePath(target store.User) string {
+	return fmt.Sprintf("/api/admin/users/%d/impersonate", target.ID)
+}
+
+// mayImpersonate reports whether admin may impersonate target
+func (s *Server) mayImpersonate(admin, target store.User) bool {
+	decision, err := s.policy.Decide(policy.EffectiveRole(admin), &admin, impersonatePath(target), http.MethodPost)
+	return err == nil && decision.Allowed
+}
+
+// impersonationTarget loads the user of the request to impersonate. Users
+// who may impersonate others themselves cannot be impersonated, so an
+// impersonation never lends more rights than the user has.
+func (s *Server) impersonationTarget(c *gin.Context, admin store.User) (store.User, error) {
+	var target store.User
+	if result := s.db.First(&target, c.Param("id")); result.Error != nil {
+		return store.User{}, errUserNotFound
+	}
+
+	switch {
+	case !s.mayImpersonate(admin, target):
+		return store.User{}, errors.New("not allowed to impersonate this user")
+	case target.ID == admin.ID:
+		return store.User{}, errors.New("cannot impersonate yourself")
+	case target.Disabled:
+		return store.User{}, errors.New("disabled users cannot be impersonated")
+	case target.ServiceAccount:
+		return store.User{}, errors.New("service accounts cannot be impersonated")
+	case s.mayImpersonate(target, admin):
+		return store.User{}, errors.New("users who may impersonate others cannot be impersonated")
+	}
+	return target, nil
+}
+
+// tokenImpersonator returns the admin named in the act claim of a token,
+// as long as they may still impersonate the user
+func (s *Server) tokenImpersonator(act *auth.ActorClaim, target store.User) (store.User, bool) {
+	var admin store.User
+	if result := s.db.Where("username = ?", act.Subject).First(&admin); result.Error != nil {
+		return store.User{}, false
+	}
+	if admin.Disabled || admin.TokenVersion != act.Version || !s.mayImpersonate(admin, target) {
+		return store.User{}, false
+	}
+	return admin, true
+}
+
+// sessionImpersonation returns the user the admin signed in through the
+// session impersonates. Expired or no longer permitted impersonations end.
+func (s *Server) sessionImpersonation(c *gin.Context, admin store.User) (store.User, bool) {
+	session := sessions.Default(c)
+	targetID := session.Get(sessionImpersonateID)
+	if targetID == nil {
+		return store.User{}, false
+	}
+
+	var target store.User
+	until, _ := session.Get(sessionImpersonateUntil).(int64)
+	if time.Now().Unix() < until && s.db.First(&target, targetID).Error == nil &&
+		!target.Disabled && s.mayImpersonate(admin, target) {
+		return target, true
+	}
+
+	session.Delete(sessionImpersonateID)
+	session.Delete(sessionImpersonateUntil)
+	session.Save()
+
+	event := store.AuditEvent{
+		ActorID:   &admin.ID,
+		Actor:     admin.Username,
+		Action:    "user.impersonate.end",
+		Target:    target.Username,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Result:    store.AuditSuccess,
+		After:     store.AuditJSON(gin.H{"reason": "expired or revoked"}),
+	}
+	s.store.AppendAuditEvent(event)
+	return store.User{}, false
+}
+
+// impersonatingSession reports whether the admin signed in through the
+// session impersonates a user, for handlers not using Authentication
+func impersonatingSession(c *gin.Context) bool {
+	return sessions.Default(c).Get(sessionImpersonateID) != nil
+}
+
+// denyImpersonated refuses the request while an admin impersonates the
+// user. Impersonated sessions cannot change credentials or roles.
+func denyImpersonated() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, impersonating := c.Get("impersonator"); impersonating {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Not allowed while impersonating a user"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// impersonateUser issues a short-lived token acting as a user, for
+// support staff reproducing what the user sees
+func (s *Server) impersonateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admin, _ := currentUser(c)
+
+		var impersonateDTO struct {
+			Reason string `json:"reason" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&impersonateDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		target, err := s.impersonationTarget(c, admin)
+		if errors.Is(err, errUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		token, expiresAt, err := s.auth.IssueImpersonationToken(admin, target)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		s.audit(c, "user.impersonate.start", target.Username, store.AuditSuccess, nil,
+			gin.H{"reason": impersonateDTO.Reason, "expires_at": expiresAt, "via": "token"})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Impersonation started",
+			"token":      token,
+			"expires_in": int(time.Until(expiresAt).Seconds()),
+			"user": gin.H{
+				"id":       target.ID,
+				"username": target.Username,
+				"email":    target.Email,
+				"role":     target.Role,
+			},
+		})
+	}
+}
+
+// impersonateUserPage starts impersonating a user in the admin's browser
+// session and sends them to the start page
+func (s *Server) impersonateUserPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admin, _ := currentUser(c)
+		session := sessions.Default(c)
+		if session.Get("user_id") != admin.ID {
+			c.String(http.StatusBadRequest, "Sign in with the login form to impersonate in the browser")
+			return
+		}
+
+		reason := c.PostForm("reason")
+		if reason == "" {
+			c.String(http.StatusBadRequest, "A reason is required")
+			return
+		}
+
+		target, err := s.impersonationTarget(c, admin)
+		if errors.Is(err, errUserNotFound) {
+			c.String(http.StatusNotFound, "User not found")
+			return
+		}
+		if err != nil {
+			c.String(http.StatusForbidden, err.Error())
+			return
+		}
+
+		expiresAt := time.Now().Add(s.auth.Config.ImpersonationTTL)
+		session.Set(sessionImpersonateID, target.ID)
+		session.Set(sessionImpersonateUntil, expiresAt.Unix())
+		session.Save()
+
+		s.audit(c, "user.impersonate.start", target.Username, store.AuditSuccess, nil,
+			gin.H{"reason": reason, "expires_at": expiresAt, "via": "session"})
+
+		c.Redirect(http.StatusSeeOther, "/")
+	}
+}
+
+// stopImpersonation ends the impersonation of the request, revoking the
+// impersonation token or returning the session to the admin
+func (s *Server) stopImpersonation(c *gin.Context) (store.User, bool) {
+	if _, impersonating := c.Get("impersonator"); !impersonating {
+		return store.User{}, false
+	}
+	target, _ := currentUser(c)
+
+	if claims, exists := c.Get("claims"); exists && claims.(*auth.Claims).Act != nil {
+		s.auth.RevokeAccessToken(claims.(*auth.Claims), "impersonation ended")
+	} else {
+		session := sessions.Default(c)
+		session.Delete(sessionImpersonateID)
+		session.Delete(sessionImpersonateUntil)
+		session.Save()
+	}
+
+	s.audit(c, "user.impersonate.end", target.Username, store.AuditSuccess, nil, nil)
+	return target, true
+}
+
+// endImpersonation ends the impersonation of the request
+func (s *Server) endImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := s.stopImpersonation(c); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Not impersonating a user"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Impersonation ended",
+		})
+	}
+}
+
+// endImpersonationPage ends the impersonation from the banner and returns
+// the admin to the user they impersonated
+func (s *Server) endImpersonationPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target, ok := s.stopImpersonation(c)
+		if !ok {
+			c.Redirect(http.StatusSeeOther, "/")
+			return
+		}
+		c.Redirect(http.StatusSeeOther, fmt.Sprintf("/admin/users/%d/permissions", target.ID))
+	}
+}
+
+// render renders a page with the CSRF token for its forms and the nonce
+// of its scripts. Pages show a banner with a way out while an admin
+// impersonates the signed in user.
+func (s *Server) render(c *gin.Context, status int, name string, data gin.H) {
+	data["csrf_token"] = csrfToken(c)
+	data["csp_nonce"] = c.GetString("csp_nonce")
+	if impersonator, exists := c.Get("impersonator"); exists {
+		user, _ := currentUser(c)
+		data["impersonation"] = gin.H{
+			"user":         user,
+			"impersonator": impersonator,
+		}
+	}
+	c.HTML(status, name, data)
+}
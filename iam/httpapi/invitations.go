@@ -0,0 +1,115 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/auth"
+	"iam/store"
+)
+
+// listInvitations returns invitations, optionally only those that are pending
+func (s *Server) listInvitations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := s.db.Order("created_at desc")
+		if c.Query("status") == "pending" {
+			query = query.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", time.Now())
+		}
+
+		var invitations []store.Invitation
+		query.Find(&invitations)
+
+		c.JSON(http.StatusOK, gin.H{
+			"invitations": invitations,
+		})
+	}
+}
+
+// createInvitation creates an invitation and returns its signed link
+func (s *Server) createInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		inviter, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		var invitationDTO struct {
+			Email string `json:"email" binding:"required,email"`
+			Role  string `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&invitationDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var role store.Role
+		if result := s.db.Where("name = ?", invitationDTO.Role).First(&role); result.Error != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role does not exist"})
+			return
+		}
+
+		var existingUser store.User
+		if result := s.db.Where("email = ?", invitationDTO.Email).First(&existingUser); result.Error == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email already exists"})
+			return
+		}
+
+		invitation := store.Invitation{
+			Email:       invitationDTO.Email,
+			Role:        role.Name,
+			InvitedByID: inviter.ID,
+			ExpiresAt:   time.Now().Add(s.auth.Config.InvitationTTL),
+		}
+		if result := s.db.Create(&invitation); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+			return
+		}
+
+		token, err := s.auth.SignPurposeToken(auth.PurposeInvitation, strconv.FormatUint(uint64(invitation.ID), 10), s.auth.Config.InvitationTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign invitation"})
+			return
+		}
+
+		s.audit(c, "invitation.create", invitation.Email, store.AuditSuccess, nil, invitation)
+
+		s.auth.SendMail(invitation.Email, "You have been invited", fmt.Sprintf(
+			"Hello,\n\n%s invited you to join as %s. Register with this email address using the link below:\n\n%s\n\nThe invitation expires in %s.\n",
+			inviter.Username, invitation.Role, s.auth.InvitationLink(token), s.auth.Config.InvitationTTL))
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":    "Invitation created successfully",
+			"invitation": invitation,
+			"token":      token,
+			"link":       s.auth.InvitationLink(token),
+		})
+	}
+}
+
+// revokeInvitation revokes a pending invitation
+func (s *Server) revokeInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result := s.db.Model(&store.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", c.Param("id")).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pending invitation not found"})
+			return
+		}
+
+		s.audit(c, "invitation.revoke", "invitation:"+c.Param("id"), store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Invitation revoked successfully",
+		})
+	}
+}
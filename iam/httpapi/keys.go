@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/store"
+)
+
+// jwks publishes the public keys for offline token verification by other services
+func (s *Server) jwks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{
+			"keys": s.auth.Keyring.JWKS(),
+		})
+	}
+}
+
+// listSigningKeys returns the published signing keys
+func (s *Server) listSigningKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var keys []store.SigningKey
+		s.db.Order("created_at desc").Find(&keys)
+
+		c.JSON(http.StatusOK, gin.H{
+			"keys": keys,
+		})
+	}
+}
+
+// rotateSigningKeys creates a new active signing key
+func (s *Server) rotateSigningKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := s.auth.Keyring.Rotate(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate signing key"})
+			return
+		}
+
+		s.audit(c, "key.rotate", "", store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Signing key rotated successfully",
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package httpapi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/store"
+)
+
+// throttledLogin responds to a login that may not be attempted now
+func throttledLogin(c *gin.Context, wait time.Duration, err error) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       err.Error(),
+		"retry_after": int(math.Ceil(wait.Seconds())),
+	})
+}
+
+// listLockouts returns the usernames and IPs that currently have failures
+// or are locked
+func (s *Server) listLockouts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var attempts []store.LoginAttempt
+		s.db.Where("last_failure_at > ? OR locked_until > ?", time.Now().Add(-s.auth.Config.Throttle.Window), time.Now()).
+			Order("last_failure_at desc").
+			Find(&attempts)
+
+		c.JSON(http.StatusOK, gin.H{
+			"attempts": attempts,
+		})
+	}
+}
+
+// unlockUser lifts a lockout and clears the failed attempts of a user
+func (s *Server) unlockUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user store.User
+		if result := s.db.First(&user, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err := s.auth.ResetLoginFailures(user.Username); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+			return
+		}
+
+		s.audit(c, "user.unlock", user.Username, store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User unlocked successfully",
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"iam/auth"
+	"iam/policy"
+	"iam/store"
+)
+
+// currentUser returns the authenticated user of the request
+func currentUser(c *gin.Context) (store.User, bool) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		return store.User{}, false
+	}
+	return userInterface.(store.User), true
+}
+
+// enrollTOTP starts TOTP enrolment for the authenticated user
+func (s *Server) enrollTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		if user.MFAEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is already enabled"})
+			return
+		}
+
+		if err := s.auth.StartTOTPEnrollment(&user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":           user.TOTPSecret,
+			"provisioning_uri": s.auth.ProvisioningURI(user),
+		})
+	}
+}
+
+// verifyTOTPEnrollment enables MFA once the user proves their authenticator works
+func (s *Server) verifyTOTPEnrollment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		var verifyDTO struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&verifyDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if user.MFAEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is already enabled"})
+			return
+		}
+
+		if user.TOTPSecret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "MFA enrollment has not been started"})
+			return
+		}
+
+		if !s.auth.ValidateTOTP(&user, verifyDTO.Code) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		codes, err := s.auth.EnableMFA(&user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable MFA"})
+			return
+		}
+
+		s.audit(c, "mfa.enable", user.Username, store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "MFA enabled successfully",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// regenerateRecoveryCodes replaces the recovery codes of the authenticated user
+func (s *Server) regenerateRecoveryCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		var codeDTO struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&codeDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !user.MFAEnabled || !s.auth.ValidateTOTP(&user, codeDTO.Code) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		codes, err := s.auth.GenerateRecoveryCodes(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// disableMFA turns MFA off for the authenticated user unless their role requires it
+func (s *Server) disableMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		var codeDTO struct {
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := c.ShouldBindJSON(&codeDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !user.MFAEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+			return
+		}
+
+		if s.policy.MFARequired(policy.AuthSubject(user)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "MFA is required for your role"})
+			return
+		}
+
+		if !s.auth.VerifySecondFactor(&user, codeDTO.Code, codeDTO.RecoveryCode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"mfa_enabled":    false,
+				"totp_secret":    "",
+				"totp_last_step": 0,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Where("user_id = ?", user.ID).Delete(&store.RecoveryCode{}).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable MFA"})
+			return
+		}
+
+		s.audit(c, "mfa.disable", user.Username, store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "MFA disabled successfully",
+		})
+	}
+}
+
+// enrollTOTPChallenge lets a user whose role requires MFA enrol during login
+func (s *Server) enrollTOTPChallenge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var challengeDTO struct {
+			MFAToken string `json:"mfa_token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&challengeDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		_, user, err := s.auth.UserFromMFAToken(challengeDTO.MFAToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		if user.MFAEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is already enabled"})
+			return
+		}
+
+		if err := s.auth.StartTOTPEnrollment(&user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":           user.TOTPSecret,
+			"provisioning_uri": s.auth.ProvisioningURI(user),
+		})
+	}
+}
+
+// verifyMFAChallenge completes a login that is pending the second factor
+func (s *Server) verifyMFAChallenge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var challengeDTO struct {
+			MFAToken     string `json:"mfa_token" binding:"required"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := c.ShouldBindJSON(&challengeDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, user, err := s.auth.UserFromMFAToken(challengeDTO.MFAToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Wrong codes count towards the same lockout as wrong passwords
+		if wait, err := s.auth.CheckLoginAllowed(user.Username, c.ClientIP()); err != nil {
+			throttledLogin(c, wait, err)
+			return
+		}
+
+		// Users required to use MFA finish their enrolment with the first code
+		var recoveryCodes []string
+		if !user.MFAEnabled {
+			if user.TOTPSecret == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "MFA enrollment has not been started"})
+				return
+			}
+			if !s.auth.ValidateTOTP(&user, challengeDTO.Code) {
+				s.auth.RecordLoginFailure(user.Username, c.ClientIP())
+				s.audit(c, "auth.mfa", user.Username, store.AuditFailure, nil, gin.H{"reason": "invalid code"})
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+				return
+			}
+			if recoveryCodes, err = s.auth.EnableMFA(&user); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable MFA"})
+				return
+			}
+		} else if !s.auth.VerifySecondFactor(&user, challengeDTO.Code, challengeDTO.RecoveryCode) {
+			s.auth.RecordLoginFailure(user.Username, c.ClientIP())
+			s.audit(c, "auth.mfa", user.Username, store.AuditFailure, nil, gin.H{"reason": "invalid code"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		// The challenge cannot be used for a second login
+		s.auth.RevokeAccessToken(&auth.Claims{RegisteredClaims: claims.RegisteredClaims}, "mfa challenge completed")
+
+		extra := gin.H{}
+		if recoveryCodes != nil {
+			extra["recovery_codes"] = recoveryCodes
+		}
+		s.completeLogin(c, user, extra)
+	}
+}
+
+// setRoleMFA requires or stops requiring MFA for a role through the policy set
+func (s *Server) setRoleMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		var role store.Role
+		if result := s.db.Where("name = ?", name).First(&role); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+
+		var mfaDTO struct {
+			Required bool `json:"required"`
+		}
+		if err := c.ShouldBindJSON(&mfaDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var err error
+		if mfaDTO.Required {
+			_, err = s.policy.Enforcer.AddPolicy(role.Name, policy.GlobalDomain, policy.MFAPolicyObject, policy.MFAPolicyAction)
+		} else {
+			_, err = s.policy.Enforcer.RemovePolicy(role.Name, policy.GlobalDomain, policy.MFAPolicyObject, policy.MFAPolicyAction)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update policy"})
+			return
+		}
+
+		s.audit(c, "role.mfa.update", role.Name, store.AuditSuccess, nil, gin.H{"mfa_required": mfaDTO.Required})
+
+		c.JSON(http.StatusOK, gin.H{
+			"role":         role.Name,
+			"mfa_required": s.policy.MFARequired(role.Name),
+		})
+	}
+}
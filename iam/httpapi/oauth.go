@@ -0,0 +1,660 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"iam/auth"
+	"iam/policy"
+	"iam/store"
+)
+
+var supportedScopes = []string{"openid", "profile", "email"}
+
+var errInvalidClient = errors.New("invalid client")
+
+// oauthError responds with an RFC 6749 error
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// redirectWithError sends an authorization error back to the client
+func redirectWithError(c *gin.Context, redirectURI, state, code, description string) {
+	params := url.Values{}
+	params.Set("error", code)
+	params.Set("error_description", description)
+	if state != "" {
+		params.Set("state", state)
+	}
+	c.Redirect(http.StatusFound, appendQuery(redirectURI, params))
+}
+
+// appendQuery adds query parameters to a URI that may already have some
+func appendQuery(uri string, params url.Values) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + params.Encode()
+	}
+	return uri + "?" + params.Encode()
+}
+
+// containsValue reports whether a space separated list contains a value
+func containsValue(list, value string) bool {
+	for _, v := range strings.Fields(list) {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateClient identifies the client of a token request from HTTP
+// Basic credentials or the client_id and client_secret form parameters
+func (s *Server) authenticateClient(c *gin.Context) (store.OAuthClient, error) {
+	clientID, secret, hasBasic := c.Request.BasicAuth()
+	if !hasBasic {
+		clientID = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+
+	var client store.OAuthClient
+	if clientID == "" || s.db.Where("client_id = ?", clientID).First(&client).Error != nil {
+		return store.OAuthClient{}, errInvalidClient
+	}
+
+	if client.Public {
+		return client, nil
+	}
+
+	if secret == "" || subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return store.OAuthClient{}, errInvalidClient
+	}
+	return client, nil
+}
+
+// authorizeRequest holds the validated parameters of an authorization request
+type authorizeRequest struct {
+	Client              store.OAuthClient
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// parseAuthorizeRequest validates the parameters of an authorization request.
+// Errors that can be sent back to the client are redirected, otherwise the
+// error is shown to the user. It returns false when a response was written.
+func (s *Server) parseAuthorizeRequest(c *gin.Context, param func(string) string) (authorizeRequest, bool) {
+	var client store.OAuthClient
+	if result := s.db.Where("client_id = ?", param("client_id")).First(&client); result.Error != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "Unknown client")
+		return authorizeRequest{}, false
+	}
+
+	redirectURI := param("redirect_uri")
+	if !containsValue(client.RedirectURIs, redirectURI) {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "Redirect URI is not registered")
+		return authorizeRequest{}, false
+	}
+
+	request := authorizeRequest{
+		Client:              client,
+		RedirectURI:         redirectURI,
+		Scope:               param("scope"),
+		State:               param("state"),
+		Nonce:               param("nonce"),
+		CodeChallenge:       param("code_challenge"),
+		CodeChallengeMethod: param("code_challenge_method"),
+	}
+
+	if param("response_type") != "code" {
+		redirectWithError(c, redirectURI, request.State, "unsupported_response_type", "Only the code response type is supported")
+		return authorizeRequest{}, false
+	}
+
+	if !containsValue(client.GrantTypes, "authorization_code") {
+		redirectWithError(c, redirectURI, request.State, "unauthorized_client", "Client may not use the authorization code grant")
+		return authorizeRequest{}, false
+	}
+
+	// Public clients cannot keep a secret and must prove possession with PKCE
+	if request.CodeChallenge == "" && client.Public {
+		redirectWithError(c, redirectURI, request.State, "invalid_request", "PKCE is required")
+		return authorizeRequest{}, false
+	}
+	if request.CodeChallenge != "" && request.CodeChallengeMethod != "S256" {
+		redirectWithError(c, redirectURI, request.State, "invalid_request", "Only the S256 code challenge method is supported")
+		return authorizeRequest{}, false
+	}
+
+	return request, true
+}
+
+// issueAuthorizationCode stores a code for the request and redirects back to the client
+func (s *Server) issueAuthorizationCode(c *gin.Context, user store.User, request authorizeRequest, scope string, authTime time.Time) {
+	code, err := auth.RandomToken(32)
+	if err != nil {
+		redirectWithError(c, request.RedirectURI, request.State, "server_error", "Failed to issue code")
+		return
+	}
+
+	authorizationCode := store.AuthorizationCode{
+		CodeHash:            auth.HashToken(code),
+		ClientID:            request.Client.ClientID,
+		UserID:              user.ID,
+		RedirectURI:         request.RedirectURI,
+		Scope:               scope,
+		Nonce:               request.Nonce,
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		AuthTime:            authTime,
+		ExpiresAt:           time.Now().Add(auth.AuthorizationCodeTTL),
+	}
+	if err := s.db.Create(&authorizationCode).Error; err != nil {
+		redirectWithError(c, request.RedirectURI, request.State, "server_error", "Failed to issue code")
+		return
+	}
+
+	params := url.Values{}
+	params.Set("code", code)
+	if request.State != "" {
+		params.Set("state", request.State)
+	}
+	c.Redirect(http.StatusFound, appendQuery(request.RedirectURI, params))
+}
+
+// sessionUser returns the user logged in through the cookie session
+func (s *Server) sessionUser(c *gin.Context) (store.User, time.Time, bool) {
+	session := sessions.Default(c)
+	userID := session.Get("user_id")
+	if userID == nil {
+		return store.User{}, time.Time{}, false
+	}
+
+	var user store.User
+	if result := s.db.First(&user, userID); result.Error != nil || user.Disabled {
+		return store.User{}, time.Time{}, false
+	}
+
+	authTime := time.Now()
+	if loginAt, ok := session.Get("login_at").(int64); ok {
+		authTime = time.Unix(loginAt, 0)
+	}
+	return user, authTime, true
+}
+
+// authorizeEndpoint starts the authorization code flow. Users without a
+// session are sent to the login page and return here afterwards.
+func (s *Server) authorizeEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request, ok := s.parseAuthorizeRequest(c, c.Query)
+		if !ok {
+			return
+		}
+
+		user, authTime, loggedIn := s.sessionUser(c)
+		if !loggedIn {
+			c.Redirect(http.StatusFound, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+			return
+		}
+
+		// Impersonated sessions must not hand out tokens for the user
+		if impersonatingSession(c) {
+			oauthError(c, http.StatusForbidden, "access_denied", "Not allowed while impersonating a user")
+			return
+		}
+
+		scope := s.policy.GrantableScopes(policy.AuthSubject(user), request.Client, request.Scope)
+
+		// Trusted clients and scopes the user already agreed to need no consent
+		var consent store.OAuthConsent
+		consented := s.db.Where("user_id = ? AND client_id = ?", user.ID, request.Client.ClientID).First(&consent).Error == nil
+		if request.Client.Trusted || (consented && coversScopes(consent.Scope, scope)) {
+			s.issueAuthorizationCode(c, user, request, scope, authTime)
+			return
+		}
+
+		consentToken, err := s.auth.SignPurposeToken(auth.PurposeConsent, user.Username, auth.AuthorizationCodeTTL)
+		if err != nil {
+			redirectWithError(c, request.RedirectURI, request.State, "server_error", "Failed to render consent")
+			return
+		}
+
+		s.render(c, http.StatusOK, "consent.html", gin.H{
+			"title":                 "Authorize " + request.Client.Name,
+			"client":                request.Client,
+			"user":                  user,
+			"scopes":                strings.Fields(scope),
+			"consent_token":         consentToken,
+			"client_id":             request.Client.ClientID,
+			"redirect_uri":          request.RedirectURI,
+			"scope":                 request.Scope,
+			"state":                 request.State,
+			"nonce":                 request.Nonce,
+			"code_challenge":        request.CodeChallenge,
+			"code_challenge_method": request.CodeChallengeMethod,
+		})
+	}
+}
+
+// consentEndpoint handles the user's decision on the consent screen
+func (s *Server) consentEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, authTime, loggedIn := s.sessionUser(c)
+		if !loggedIn {
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+		if impersonatingSession(c) {
+			oauthError(c, http.StatusForbidden, "access_denied", "Not allowed while impersonating a user")
+			return
+		}
+
+		// The consent token ties the form to the user's session
+		claims, err := s.auth.ParsePurposeToken(auth.PurposeConsent, c.PostForm("consent_token"))
+		if err != nil || claims.Subject != user.Username {
+			oauthError(c, http.StatusBadRequest, "invalid_request", "Invalid or expired consent")
+			return
+		}
+
+		request, ok := s.parseAuthorizeRequest(c, func(key string) string {
+			if key == "response_type" {
+				return "code"
+			}
+			return c.PostForm(key)
+		})
+		if !ok {
+			return
+		}
+
+		if c.PostForm("decision") != "allow" {
+			redirectWithError(c, request.RedirectURI, request.State, "access_denied", "The user denied the request")
+			return
+		}
+
+		scope := s.policy.GrantableScopes(policy.AuthSubject(user), request.Client, request.Scope)
+		consent := store.OAuthConsent{UserID: user.ID, ClientID: request.Client.ClientID}
+		s.db.Where(consent).FirstOrInit(&consent)
+		consent.Scope = mergeScopes(consent.Scope, scope)
+		s.db.Save(&consent)
+
+		s.audit(c, "oauth.consent", request.Client.ClientID, store.AuditSuccess, nil, gin.H{"scope": scope})
+
+		s.issueAuthorizationCode(c, user, request, scope, authTime)
+	}
+}
+
+// coversScopes reports whether every scope in requested is in granted
+func coversScopes(granted, requested string) bool {
+	for _, scope := range strings.Fields(requested) {
+		if !containsValue(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeScopes returns the union of two space separated scope lists
+func mergeScopes(a, b string) string {
+	merged := strings.Fields(a)
+	for _, scope := range strings.Fields(b) {
+		if !containsValue(a, scope) {
+			merged = append(merged, scope)
+		}
+	}
+	return strings.Join(merged, " ")
+}
+
+// tokenEndpoint exchanges grants for tokens
+func (s *Server) tokenEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+
+		client, err := s.authenticateClient(c)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Basic realm="iam"`)
+			oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+			return
+		}
+
+		grantType := c.PostForm("grant_type")
+		if !containsValue(client.GrantTypes, grantType) {
+			oauthError(c, http.StatusBadRequest, "unauthorized_client", "Client may not use this grant type")
+			return
+		}
+
+		switch grantType {
+		case "authorization_code":
+			s.authorizationCodeGrant(c, client)
+		case "client_credentials":
+			s.clientCredentialsGrant(c, client)
+		case "refresh_token":
+			s.refreshTokenGrant(c, client)
+		default:
+			oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant type")
+		}
+	}
+}
+
+// authorizationCodeGrant redeems an authorization code
+func (s *Server) authorizationCodeGrant(c *gin.Context, client store.OAuthClient) {
+	var code store.AuthorizationCode
+	if result := s.db.Where("code_hash = ?", auth.HashToken(c.PostForm("code"))).First(&code); result.Error != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+
+	if code.ClientID != client.ClientID || code.RedirectURI != c.PostForm("redirect_uri") ||
+		time.Now().After(code.ExpiresAt) ||
+		!auth.VerifyPKCE(c.PostForm("code_verifier"), code.CodeChallenge, code.CodeChallengeMethod) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+
+	// A code can only be redeemed once
+	result := s.db.Model(&store.AuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", code.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "Authorization code was already used")
+		return
+	}
+
+	// Codes of users revoked since are spent by RevokeUserTokens
+	var user store.User
+	if result := s.db.First(&user, code.UserID); result.Error != nil || user.Disabled {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+
+	s.respondWithClientTokens(c, &user, client, code.Scope, code.Nonce, code.AuthTime)
+}
+
+// clientCredentialsGrant issues a token to a confidential client acting on its own behalf
+func (s *Server) clientCredentialsGrant(c *gin.Context, client store.OAuthClient) {
+	if client.Public {
+		oauthError(c, http.StatusBadRequest, "unauthorized_client", "Public clients cannot use client credentials")
+		return
+	}
+
+	requested := c.PostForm("scope")
+	if requested == "" {
+		requested = client.Scopes
+	}
+
+	s.respondWithClientTokens(c, nil, client, s.policy.GrantableScopes(client.Role, client, requested), "", time.Time{})
+}
+
+// refreshTokenGrant rotates a refresh token issued to the client
+func (s *Server) refreshTokenGrant(c *gin.Context, client store.OAuthClient) {
+	current, user, refreshToken, err := s.auth.RotateRefreshToken(c.PostForm("refresh_token"), client.ClientID)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+		oauthError(c, http.StatusInternalServerError, "server_error", "Failed to refresh token")
+		return
+	}
+
+	// The scope may be narrowed but never widened
+	scope := current.Scope
+	if requested := c.PostForm("scope"); requested != "" {
+		if !coversScopes(current.Scope, requested) {
+			oauthError(c, http.StatusBadRequest, "invalid_scope", "Requested scope exceeds the original grant")
+			return
+		}
+		scope = requested
+	}
+
+	accessToken, err := s.auth.GenerateClientJWT(&user, client, scope)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(s.auth.Config.AccessTokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+		"scope":         scope,
+	})
+}
+
+// respondWithClientTokens issues the access token, and for users a refresh
+// token and, with the openid scope, an ID token
+func (s *Server) respondWithClientTokens(c *gin.Context, user *store.User, client store.OAuthClient, scope, nonce string, authTime time.Time) {
+	accessToken, err := s.auth.GenerateClientJWT(user, client, scope)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	response := gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(s.auth.Config.AccessTokenTTL.Seconds()),
+		"scope":        scope,
+	}
+
+	if user != nil && containsValue(client.GrantTypes, "refresh_token") {
+		refreshToken, err := s.auth.IssueRefreshToken(s.db, store.RefreshToken{
+			UserID:   user.ID,
+			ClientID: client.ClientID,
+			Scope:    scope,
+		})
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", "Failed to generate token")
+			return
+		}
+		response["refresh_token"] = refreshToken
+	}
+
+	if user != nil && containsValue(scope, "openid") {
+		idToken, err := s.auth.GenerateIDToken(*user, client, scope, nonce, authTime)
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", "Failed to generate token")
+			return
+		}
+		response["id_token"] = idToken
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// userinfoEndpoint returns the claims of the user an access token was issued for
+func (s *Server) userinfoEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claimsInterface, hasClaims := c.Get("claims")
+		user, hasUser := currentUser(c)
+		if !hasClaims || !hasUser {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		claims := claimsInterface.(*auth.Claims)
+		if claims.ClientID != "" && !containsValue(claims.Scope, "openid") {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_scope"})
+			return
+		}
+
+		// First-party tokens carry no scope and see every claim
+		scope := claims.Scope
+		if claims.ClientID == "" {
+			scope = strings.Join(supportedScopes, " ")
+		}
+
+		info := gin.H{"sub": user.Username}
+		if containsValue(scope, "profile") {
+			info["preferred_username"] = user.Username
+			info["role"] = user.Role
+		}
+		if containsValue(scope, "email") {
+			info["email"] = user.Email
+		}
+
+		c.JSON(http.StatusOK, info)
+	}
+}
+
+// discoveryEndpoint serves the OpenID Connect discovery document
+func (s *Server) discoveryEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		baseURL := s.auth.Config.PublicURL
+
+		c.JSON(http.StatusOK, gin.H{
+			"issuer":                                s.auth.Config.Issuer,
+			"authorization_endpoint":                baseURL + "/oauth/authorize",
+			"token_endpoint":                        baseURL + "/oauth/token",
+			"userinfo_endpoint":                     baseURL + "/userinfo",
+			"jwks_uri":                              baseURL + "/.well-known/jwks.json",
+			"response_types_supported":              []string{"code"},
+			"grant_types_supported":                 []string{"authorization_code", "client_credentials", "refresh_token"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{s.auth.Config.SigningAlgorithm},
+			"scopes_supported":                      supportedScopes,
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username", "email", "role"},
+		})
+	}
+}
+
+// listOAuthClients returns the client registry
+func (s *Server) listOAuthClients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var clients []store.OAuthClient
+		s.db.Find(&clients)
+
+		c.JSON(http.StatusOK, gin.H{
+			"clients": clients,
+		})
+	}
+}
+
+// createOAuthClient registers a client. The secret of confidential clients
+// is only returned in this response.
+func (s *Server) createOAuthClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var clientDTO struct {
+			Name         string   `json:"name" binding:"required"`
+			RedirectURIs []string `json:"redirect_uris"`
+			GrantTypes   []string `json:"grant_types" binding:"required"`
+			Scopes       []string `json:"scopes"`
+			Role         string   `json:"role"`
+			Public       bool     `json:"public"`
+			Trusted      bool     `json:"trusted"`
+		}
+		if err := c.ShouldBindJSON(&clientDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		for _, grantType := range clientDTO.GrantTypes {
+			if grantType != "authorization_code" && grantType != "client_credentials" && grantType != "refresh_token" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported grant type " + grantType})
+				return
+			}
+		}
+
+		if containsValue(strings.Join(clientDTO.GrantTypes, " "), "authorization_code") && len(clientDTO.RedirectURIs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Redirect URIs are required for the authorization code grant"})
+			return
+		}
+
+		if clientDTO.Role != "" {
+			var role store.Role
+			if result := s.db.Where("name = ?", clientDTO.Role).First(&role); result.Error != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Role does not exist"})
+				return
+			}
+		}
+
+		clientID, err := auth.RandomToken(16)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create client"})
+			return
+		}
+
+		client := store.OAuthClient{
+			ClientID:     clientID,
+			Name:         clientDTO.Name,
+			RedirectURIs: strings.Join(clientDTO.RedirectURIs, " "),
+			GrantTypes:   strings.Join(clientDTO.GrantTypes, " "),
+			Scopes:       strings.Join(clientDTO.Scopes, " "),
+			Role:         clientDTO.Role,
+			Public:       clientDTO.Public,
+			Trusted:      clientDTO.Trusted,
+		}
+
+		response := gin.H{"message": "Client created successfully"}
+		if !client.Public {
+			secret, err := auth.RandomToken(32)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create client"})
+				return
+			}
+			client.SecretHash = auth.HashToken(secret)
+			response["client_secret"] = secret
+		}
+
+		if result := s.db.Create(&client); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create client"})
+			return
+		}
+
+		s.audit(c, "oauth.client.create", client.ClientID, store.AuditSuccess, nil, client)
+
+		response["client"] = client
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// deleteOAuthClient removes a client and revokes its refresh tokens
+func (s *Server) deleteOAuthClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var client store.OAuthClient
+		if result := s.db.First(&client, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+			return
+		}
+
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&store.RefreshToken{}).
+				Where("client_id = ? AND revoked_at IS NULL", client.ClientID).
+				Update("revoked_at", time.Now()).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("client_id = ?", client.ClientID).Delete(&store.OAuthConsent{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&client).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete client"})
+			return
+		}
+
+		s.audit(c, "oauth.client.delete", client.ClientID, store.AuditSuccess, client, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Client deleted successfully",
+		})
+	}
+}
@@ -0,0 +1,445 @@
+package httpapi
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/auth"
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+var orgSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+// canAccessOrg reports whether the user may see the organization, either
+// as a member or through a global policy
+func (s *Server) canAccessOrg(c *gin.Context, user store.User, slug string) bool {
+	if s.policy.OrgRole(user, slug) != "" {
+		return true
+	}
+	allowed, err := s.policy.Enforcer.Enforce(policy.AuthSubject(user), slug, "/api/orgs/"+slug, "GET")
+	return err == nil && allowed
+}
+
+// activeOrg returns the organization the request acts in. Tokens carry it
+// in the org claim, sessions use the user's last selection.
+func activeOrg(c *gin.Context) string {
+	if claims, exists := c.Get("claims"); exists {
+		return claims.(*auth.Claims).Org
+	}
+	if user, exists := currentUser(c); exists {
+		return user.ActiveOrg
+	}
+	return ""
+}
+
+// orgBySlug loads the organization from the :org route parameter
+func (s *Server) orgBySlug(c *gin.Context) (store.Organization, bool) {
+	var org store.Organization
+	if result := s.db.Where("slug = ?", c.Param("org")).First(&org); result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return store.Organization{}, false
+	}
+	return org, true
+}
+
+// listOrgs returns the organizations the current user belongs to
+func (s *Server) listOrgs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		rules, _ := s.policy.Enforcer.GetFilteredGroupingPolicy(0, policy.UserSubject(user))
+		memberships := make([]gin.H, 0, len(rules))
+		for _, rule := range rules {
+			if rule[2] == policy.GlobalDomain {
+				continue
+			}
+			memberships = append(memberships, gin.H{
+				"org":  rule[2],
+				"role": rule[1],
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"organizations": memberships,
+			"active_org":    activeOrg(c),
+		})
+	}
+}
+
+// createOrg creates an organization with the current user as its admin
+func (s *Server) createOrg() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		var orgDTO struct {
+			Slug string `json:"slug" binding:"required"`
+			Name string `json:"name" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&orgDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !orgSlugPattern.MatchString(orgDTO.Slug) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Slug must be 2-63 lowercase letters, digits or dashes"})
+			return
+		}
+
+		var existing store.Organization
+		if result := s.db.Where("slug = ?", orgDTO.Slug).First(&existing); result.Error == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Organization already exists"})
+			return
+		}
+
+		org := store.Organization{Slug: orgDTO.Slug, Name: orgDTO.Name}
+		if result := s.db.Create(&org); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+			return
+		}
+
+		if _, err := s.policy.Enforcer.AddGroupingPolicy(policy.UserSubject(user), policy.OrgAdminRole, org.Slug); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add organization admin"})
+			return
+		}
+
+		s.audit(c, "org.create", org.Slug, store.AuditSuccess, nil, org)
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":      "Organization created successfully",
+			"organization": org,
+		})
+	}
+}
+
+// getOrg returns an organization and the current user's role in it
+func (s *Server) getOrg() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := s.orgBySlug(c)
+		if !ok {
+			return
+		}
+
+		response := gin.H{"organization": org}
+		if user, exists := currentUser(c); exists {
+			response["role"] = s.policy.OrgRole(user, org.Slug)
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// deleteOrg removes an organization with its members and policies
+func (s *Server) deleteOrg() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := s.orgBySlug(c)
+		if !ok {
+			return
+		}
+
+		if _, err := s.policy.Enforcer.RemoveFilteredGroupingPolicy(2, org.Slug); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove members"})
+			return
+		}
+		if _, err := s.policy.Enforcer.RemoveFilteredPolicy(1, org.Slug); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove policies"})
+			return
+		}
+
+		s.db.Model(&store.User{}).Where("active_org = ?", org.Slug).Update("active_org", "")
+		s.db.Delete(&org)
+
+		s.audit(c, "org.delete", org.Slug, store.AuditSuccess, org, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Organization deleted successfully",
+		})
+	}
+}
+
+// listOrgMembers returns the members of an organization with their roles
+func (s *Server) listOrgMembers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := s.orgBySlug(c)
+		if !ok {
+			return
+		}
+
+		rules, _ := s.policy.Enforcer.GetFilteredGroupingPolicy(2, org.Slug)
+		roles := make(map[string]string, len(rules))
+		ids := make([]string, 0, len(rules))
+		for _, rule := range rules {
+			id := strings.TrimPrefix(rule[0], policy.UserSubjectPrefix)
+			roles[id] = rule[1]
+			ids = append(ids, id)
+		}
+
+		var users []store.User
+		if len(ids) > 0 {
+			s.db.Where("id IN ?", ids).Find(&users)
+		}
+
+		members := make([]gin.H, 0, len(users))
+		for _, user := range users {
+			members = append(members, gin.H{
+				"id":       user.ID,
+				"username": user.Username,
+				"email":    user.Email,
+				"role":     roles[strconv.FormatUint(uint64(user.ID), 10)],
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"org":     org.Slug,
+			"members": members,
+		})
+	}
+}
+
+// setOrgMember adds a user to an organization or changes their role in it
+func (s *Server) setOrgMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := s.orgBySlug(c)
+		if !ok {
+			return
+		}
+
+		var user store.User
+		if result := s.db.Where("username = ?", c.Param("username")).First(&user); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		var memberDTO struct {
+			Role string `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&memberDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var role store.Role
+		if result := s.db.Where("name = ?", memberDTO.Role).First(&role); result.Error != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role does not exist"})
+			return
+		}
+
+		subject := policy.UserSubject(user)
+		previousRole := s.policy.OrgRole(user, org.Slug)
+		if previousRole != "" {
+			if _, err := s.policy.Enforcer.RemoveGroupingPolicy(subject, previousRole, org.Slug); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+				return
+			}
+		}
+		if _, err := s.policy.Enforcer.AddGroupingPolicy(subject, role.Name, org.Slug); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+			return
+		}
+
+		s.audit(c, "org.member.set", org.Slug+"/"+user.Username, store.AuditSuccess,
+			gin.H{"role": previousRole}, gin.H{"role": role.Name})
+		s.emit(webhook.EventUserRoleChanged, gin.H{"user": webhookUser(user), "org": org.Slug, "role_before": previousRole, "role": role.Name})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Member updated successfully",
+			"member": gin.H{
+				"username": user.Username,
+				"role":     role.Name,
+			},
+		})
+	}
+}
+
+// removeOrgMember removes a user from an organization
+func (s *Server) removeOrgMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := s.orgBySlug(c)
+		if !ok {
+			return
+		}
+
+		var user store.User
+		if result := s.db.Where("username = ?", c.Param("username")).First(&user); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		removed, err := s.policy.Enforcer.RemoveFilteredGroupingPolicy(0, policy.UserSubject(user), "", org.Slug)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+			return
+		}
+		if !removed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member"})
+			return
+		}
+
+		if user.ActiveOrg == org.Slug {
+			s.db.Model(&user).Update("active_org", "")
+		}
+
+		s.audit(c, "org.member.remove", org.Slug+"/"+user.Username, store.AuditSuccess, nil, nil)
+		s.emit(webhook.EventUserRoleChanged, gin.H{"user": webhookUser(user), "org": org.Slug, "role": ""})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Member removed successfully",
+		})
+	}
+}
+
+// listOrgPolicies returns the policies that only apply within an organization
+func (s *Server) listOrgPolicies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := s.orgBySlug(c)
+		if !ok {
+			return
+		}
+
+		policies, _ := s.policy.Enforcer.GetFilteredPolicy(1, org.Slug)
+
+		c.JSON(http.StatusOK, gin.H{
+			"org":      org.Slug,
+			"policies": policies,
+		})
+	}
+}
+
+// orgPolicyDTO is a policy within an organization
+type orgPolicyDTO struct {
+	Subject string `json:"subject" binding:"required"`
+	Object  string `json:"object" binding:"required"`
+	Action  string `json:"action" binding:"required"`
+}
+
+// addOrgPolicy adds a policy that only applies within an organization
+func (s *Server) addOrgPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := s.orgBySlug(c)
+		if !ok {
+			return
+		}
+
+		var policyDTO orgPolicyDTO
+		if err := c.ShouldBindJSON(&policyDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		added, err := s.policy.Enforcer.AddPolicy(policyDTO.Subject, org.Slug, policyDTO.Object, policyDTO.Action)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add policy"})
+			return
+		}
+		if !added {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Policy already exists"})
+			return
+		}
+
+		s.audit(c, "org.policy.add", org.Slug, store.AuditSuccess, nil, policyDTO)
+		s.emit(webhook.EventPolicyChanged, gin.H{"change": "add", "model": "rbac", "org": org.Slug,
+			"policy": []string{policyDTO.Subject, org.Slug, policyDTO.Object, policyDTO.Action}})
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Policy added successfully",
+			"policy":  []string{policyDTO.Subject, org.Slug, policyDTO.Object, policyDTO.Action},
+		})
+	}
+}
+
+// removeOrgPolicy removes a policy of an organization
+func (s *Server) removeOrgPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := s.orgBySlug(c)
+		if !ok {
+			return
+		}
+
+		var policyDTO orgPolicyDTO
+		if err := c.ShouldBindJSON(&policyDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		removed, err := s.policy.Enforcer.RemovePolicy(policyDTO.Subject, org.Slug, policyDTO.Object, policyDTO.Action)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove policy"})
+			return
+		}
+		if !removed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Policy not found"})
+			return
+		}
+
+		s.audit(c, "org.policy.remove", org.Slug, store.AuditSuccess, policyDTO, nil)
+		s.emit(webhook.EventPolicyChanged, gin.H{"change": "remove", "model": "rbac", "org": org.Slug,
+			"policy": []string{policyDTO.Subject, org.Slug, policyDTO.Object, policyDTO.Action}})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Policy removed successfully",
+		})
+	}
+}
+
+// switchOrg changes the active organization of the current user and
+// returns an access token carrying it. An empty org clears it.
+func (s *Server) switchOrg() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		var switchDTO struct {
+			Org string `json:"org"`
+		}
+		if err := c.ShouldBindJSON(&switchDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if switchDTO.Org != "" {
+			var org store.Organization
+			if result := s.db.Where("slug = ?", switchDTO.Org).First(&org); result.Error != nil ||
+				!s.canAccessOrg(c, user, org.Slug) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of the organization"})
+				return
+			}
+		}
+
+		previousOrg := user.ActiveOrg
+		user.ActiveOrg = switchDTO.Org
+		if err := s.db.Model(&user).Update("active_org", user.ActiveOrg).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch organization"})
+			return
+		}
+
+		token, err := s.auth.GenerateJWT(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		s.audit(c, "org.switch", user.Username, store.AuditSuccess, gin.H{"org": previousOrg}, gin.H{"org": user.ActiveOrg})
+
+		c.JSON(http.StatusOK, gin.H{
+			"token":      token,
+			"expires_in": int(s.auth.Config.AccessTokenTTL.Seconds()),
+			"active_org": user.ActiveOrg,
+			"role":       s.policy.OrgRole(user, user.ActiveOrg),
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"iam/store"
+)
+
+// indexPage sends signed in users to the dashboard. Admins impersonating
+// a user land here, since the user usually cannot see the dashboard.
+func (s *Server) indexPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session := sessions.Default(c)
+		userID := session.Get("user_id")
+
+		if userID != nil {
+			var admin store.User
+			if s.db.First(&admin, userID).Error == nil && !admin.Disabled {
+				if target, impersonating := s.sessionImpersonation(c, admin); impersonating {
+					c.Set("impersonator", admin)
+					c.Set("user", target)
+					s.render(c, http.StatusOK, "index.html", gin.H{
+						"title": "Auth System",
+						"user":  target,
+					})
+					return
+				}
+			}
+			c.Redirect(http.StatusFound, "/admin/dashboard")
+			return
+		}
+
+		s.render(c, http.StatusOK, "index.html", gin.H{
+			"title": "Auth System",
+		})
+	}
+}
+
+// loginPage renders the login form
+func (s *Server) loginPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.render(c, http.StatusOK, "login.html", gin.H{
+			"title": "Login",
+		})
+	}
+}
+
+// registerPage renders the registration form
+func (s *Server) registerPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.render(c, http.StatusOK, "register.html", gin.H{
+			"title": "Register",
+		})
+	}
+}
+
+// dashboardPage renders the admin dashboard
+func (s *Server) dashboardPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		s.render(c, http.StatusOK, "dashboard.html", gin.H{
+			"title": "Admin Dashboard",
+			"user":  user.(store.User),
+		})
+	}
+}
+
+// usersPage renders the user management page, which pages through the
+// directory with the admin API
+func (s *Server) usersPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var roles []store.Role
+		s.db.Order("name").Find(&roles)
+
+		s.render(c, http.StatusOK, "users.html", gin.H{
+			"title": "User Management",
+			"roles": roles,
+		})
+	}
+}
+
+// rolesPage renders the role management page
+func (s *Server) rolesPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var roles []store.Role
+		s.db.Find(&roles)
+
+		s.render(c, http.StatusOK, "roles.html", gin.H{
+			"title": "Role Management",
+			"roles": roles,
+		})
+	}
+}
+
+// policiesPage renders the policy management page
+func (s *Server) policiesPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policies, _ := s.policy.Enforcer.GetPolicy()
+
+		s.render(c, http.StatusOK, "policies.html", gin.H{
+			"title":    "Policy Management",
+			"policies": policies,
+		})
+	}
+}
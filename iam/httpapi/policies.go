@@ -0,0 +1,132 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// listPolicies returns every RBAC policy
+func (s *Server) listPolicies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policies, _ := s.policy.Enforcer.GetPolicy()
+
+		c.JSON(http.StatusOK, gin.H{
+			"policies": policies,
+		})
+	}
+}
+
+// addPolicy adds an RBAC policy
+func (s *Server) addPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rule []interface{}
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(rule) < 3 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Policy must have at least subject, object, and action"})
+			return
+		}
+		rule = policy.DomainPolicy(rule)
+
+		added, err := s.policy.Enforcer.AddPolicy(rule...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add policy"})
+			return
+		}
+
+		if !added {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Policy already exists"})
+			return
+		}
+
+		s.policy.Enforcer.SavePolicy()
+
+		s.audit(c, "policy.add", fmt.Sprint(rule[0]), store.AuditSuccess, nil, rule)
+		s.emit(webhook.EventPolicyChanged, gin.H{"change": "add", "model": "rbac", "policy": rule})
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Policy added successfully",
+			"policy":  rule,
+		})
+	}
+}
+
+// removePolicy removes an RBAC policy
+func (s *Server) removePolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rule []interface{}
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(rule) < 3 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Policy must have at least subject, object, and action"})
+			return
+		}
+		rule = policy.DomainPolicy(rule)
+
+		// Remove policy
+		removed, err := s.policy.Enforcer.RemovePolicy(rule...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove policy"})
+			return
+		}
+
+		if !removed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Policy not found"})
+			return
+		}
+
+		s.policy.Enforcer.SavePolicy()
+
+		s.audit(c, "policy.remove", fmt.Sprint(rule[0]), store.AuditSuccess, rule, nil)
+		s.emit(webhook.EventPolicyChanged, gin.H{"change": "remove", "model": "rbac", "policy": rule})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Policy removed successfully",
+		})
+	}
+}
+
+// rolePolicies returns the policies of a role
+func (s *Server) rolePolicies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.Param("role")
+		policies, _ := s.policy.Enforcer.GetFilteredPolicy(0, role)
+
+		c.JSON(http.StatusOK, gin.H{
+			"role":     role,
+			"policies": policies,
+		})
+	}
+}
+
+// getModel returns the Casbin model as text
+func (s *Server) getModel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		model := s.policy.Enforcer.GetModel()
+		modelAsText := ""
+
+		for sec, t := range model {
+			modelAsText += fmt.Sprintf("[%s]\n", sec)
+			for k, v := range t {
+				modelAsText += fmt.Sprintf("%s = %s\n", k, v.Value)
+			}
+			modelAsText += "\n"
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"model": modelAsText,
+		})
+	}
+}
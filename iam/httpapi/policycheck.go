@@ -0,0 +1,174 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// policyCheckRequest names who makes a request. Either a role or a user
+// is given; a user is checked with their effective role and memberships.
+type policyCheckRequest struct {
+	Subject string `json:"subject"`
+	User    string `json:"user"`
+	Path    string `json:"path" binding:"required"`
+	Method  string `json:"method" binding:"required"`
+}
+
+// resolveCheck returns the role and user a request is checked as
+func (s *Server) resolveCheck(r policyCheckRequest) (string, *store.User, bool) {
+	if r.User == "" {
+		return r.Subject, nil, r.Subject != ""
+	}
+
+	var user store.User
+	if result := s.db.Where("username = ?", r.User).First(&user); result.Error != nil {
+		return "", nil, false
+	}
+	return policy.EffectiveRole(user), &user, true
+}
+
+// checkPolicy explains the decision for a single request
+func (s *Server) checkPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var checkDTO policyCheckRequest
+		if err := c.ShouldBindJSON(&checkDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		role, user, ok := s.resolveCheck(checkDTO)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A known user or a subject is required"})
+			return
+		}
+
+		decision, err := s.policy.Decide(role, user, checkDTO.Path, strings.ToUpper(checkDTO.Method))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Authorization error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"role":     role,
+			"decision": decision,
+		})
+	}
+}
+
+// policySimulation is a proposed policy change with the cases it must satisfy
+type policySimulation struct {
+	policy.Change
+	Cases []struct {
+		policyCheckRequest
+		Expect string `json:"expect" binding:"required,oneof=allow deny"`
+	} `json:"cases" binding:"required,dive"`
+	Apply bool `json:"apply"` // apply the change when every case passes
+}
+
+// simulatePolicies checks a proposed policy change against expected
+// decisions on an in-memory copy of the policy set, and applies it when
+// requested and every case passes
+func (s *Server) simulatePolicies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var simulation policySimulation
+		if err := c.ShouldBindJSON(&simulation); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		for _, rules := range [][][]string{simulation.AddPolicies, simulation.RemovePolicies} {
+			for _, rule := range rules {
+				if len(rule) != 3 && len(rule) != 4 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Policies must be subject, [domain,] object, action"})
+					return
+				}
+			}
+		}
+		for _, rules := range [][][]string{simulation.AddGroupings, simulation.RemoveGroupings} {
+			for _, rule := range rules {
+				if len(rule) != 3 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Groupings must be subject, role, domain"})
+					return
+				}
+			}
+		}
+
+		simulated, err := s.policy.Simulate(simulation.Change)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to simulate change: " + err.Error()})
+			return
+		}
+
+		results := make([]gin.H, 0, len(simulation.Cases))
+		passed := true
+		for _, testCase := range simulation.Cases {
+			role, user, ok := s.resolveCheck(testCase.policyCheckRequest)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Every case needs a known user or a subject"})
+				return
+			}
+
+			method := strings.ToUpper(testCase.Method)
+			before, err := s.policy.Decide(role, user, testCase.Path, method)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Authorization error"})
+				return
+			}
+			after, err := policy.Decide(simulated, role, user, testCase.Path, method)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Authorization error"})
+				return
+			}
+
+			ok = after.Allowed == (testCase.Expect == "allow")
+			passed = passed && ok
+			results = append(results, gin.H{
+				"subject": testCase.Subject,
+				"user":    testCase.User,
+				"path":    testCase.Path,
+				"method":  method,
+				"expect":  testCase.Expect,
+				"before":  before,
+				"after":   after,
+				"pass":    ok,
+			})
+		}
+
+		response := gin.H{
+			"passed":  passed,
+			"results": results,
+			"applied": false,
+		}
+
+		if simulation.Apply {
+			if !passed {
+				response["error"] = "Not applied, some cases failed"
+				c.JSON(http.StatusConflict, response)
+				return
+			}
+
+			if err := simulation.ApplyTo(s.policy.Enforcer); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply change"})
+				return
+			}
+			response["applied"] = true
+
+			change := gin.H{
+				"add_policies":     simulation.AddPolicies,
+				"remove_policies":  simulation.RemovePolicies,
+				"add_groupings":    simulation.AddGroupings,
+				"remove_groupings": simulation.RemoveGroupings,
+			}
+			s.audit(c, "policy.apply", "", store.AuditSuccess, nil, change)
+			s.emit(webhook.EventPolicyChanged, gin.H{"change": "apply", "diff": change})
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
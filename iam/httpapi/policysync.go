@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// exportPolicies returns the policy set as a YAML or CSV file
+func (s *Server) exportPolicies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", policy.PolicyFormatYAML)
+
+		set, err := s.policy.ExportPolicySet()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export policies"})
+			return
+		}
+		data, err := policy.FormatPolicySet(set, format)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		contentType := "application/yaml"
+		if format == policy.PolicyFormatCSV {
+			contentType = "text/csv"
+		}
+		c.Header("Content-Disposition", "attachment; filename=policy."+format)
+		c.Data(http.StatusOK, contentType, data)
+	}
+}
+
+// importPolicies replaces the policy set with the uploaded file. With
+// dry_run=true it only returns the changes it would make.
+func (s *Server) importPolicies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := io.ReadAll(io.LimitReader(c.Request.Body, 10<<20))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read policy file"})
+			return
+		}
+
+		desired, err := policy.ParsePolicySet(data, c.DefaultQuery("format", policy.PolicyFormatYAML))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		diff, err := s.policy.DiffPolicySet(desired)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare policies"})
+			return
+		}
+
+		if c.Query("dry_run") == "true" || diff.Empty() {
+			c.JSON(http.StatusOK, gin.H{
+				"dry_run": c.Query("dry_run") == "true",
+				"diff":    diff,
+			})
+			return
+		}
+
+		if err := s.policy.ApplyPolicyDiff(diff); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "diff": diff})
+			return
+		}
+
+		s.audit(c, "policy.import", "", store.AuditSuccess, nil, diff)
+		s.emit(webhook.EventPolicyChanged, gin.H{"change": "import", "diff": diff})
+
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run": false,
+			"diff":    diff,
+		})
+	}
+}
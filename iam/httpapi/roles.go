@@ -0,0 +1,282 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// listRoles returns every role
+func (s *Server) listRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var roles []store.Role
+		s.db.Find(&roles)
+
+		c.JSON(http.StatusOK, gin.H{
+			"roles": roles,
+		})
+	}
+}
+
+// createRole adds a role
+func (s *Server) createRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var role store.Role
+		if err := c.ShouldBindJSON(&role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Check if role already exists
+		var existingRole store.Role
+		if result := s.db.Where("name = ?", role.Name).First(&existingRole); result.Error == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role already exists"})
+			return
+		}
+
+		if result := s.db.Create(&role); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+			return
+		}
+
+		s.audit(c, "role.create", role.Name, store.AuditSuccess, nil, role)
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Role created successfully",
+			"role":    role,
+		})
+	}
+}
+
+// deleteRole removes a role and its policies
+func (s *Server) deleteRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var role store.Role
+
+		// Check if role exists
+		if result := s.db.First(&role, id); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+
+		// Check if role is in use
+		var userCount int64
+		s.db.Model(&store.User{}).Where("role = ?", role.Name).Count(&userCount)
+		if userCount > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role is in use by users"})
+			return
+		}
+		if members, _ := s.policy.Enforcer.GetFilteredGroupingPolicy(1, role.Name); len(members) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role is assigned to users or inherited by other roles"})
+			return
+		}
+
+		// Delete role policies
+		policies, _ := s.policy.Enforcer.GetFilteredPolicy(0, role.Name)
+		for _, rule := range policies {
+			s.policy.Enforcer.RemovePolicy(rule)
+		}
+		parents := s.policy.RoleParents(role.Name)
+		s.policy.Enforcer.RemoveFilteredGroupingPolicy(0, role.Name)
+		s.policy.Enforcer.SavePolicy()
+
+		// Delete role
+		s.db.Delete(&role)
+
+		s.audit(c, "role.delete", role.Name, store.AuditSuccess, gin.H{"role": role, "policies": policies, "inherits": parents}, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Role deleted successfully",
+		})
+	}
+}
+
+// roleUpdateError responds to a failed role change
+func roleUpdateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, policy.ErrUnknownRole), errors.Is(err, policy.ErrRoleRequired),
+		errors.Is(err, policy.ErrRoleCycle), errors.Is(err, policy.ErrRoleInherited),
+		errors.Is(err, policy.ErrRoleGranted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update roles"})
+	}
+}
+
+// getUserRoles returns the roles assigned to a user
+func (s *Server) getUserRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user store.User
+		if result := s.db.First(&user, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user":  user.Username,
+			"roles": s.policy.UserRoles(user),
+		})
+	}
+}
+
+// setUserRoles replaces the roles assigned to a user
+func (s *Server) setUserRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user store.User
+		if result := s.db.First(&user, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		var rolesDTO struct {
+			Roles []string `json:"roles" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&rolesDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		previous := s.policy.UserRoles(user)
+		if err := s.policy.SetUserRoles(&user, rolesDTO.Roles); err != nil {
+			roleUpdateError(c, err)
+			return
+		}
+
+		// Tokens carrying the old role must not be used anymore
+		s.auth.RevokeUserTokens(user.ID)
+
+		s.audit(c, "user.roles.update", user.Username, store.AuditSuccess, gin.H{"roles": previous}, gin.H{"roles": s.policy.UserRoles(user)})
+		s.emit(webhook.EventUserRoleChanged, gin.H{"user": webhookUser(user), "roles_before": previous, "roles": s.policy.UserRoles(user)})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User roles updated successfully",
+			"user":    user.Username,
+			"role":    user.Role,
+			"roles":   s.policy.UserRoles(user),
+		})
+	}
+}
+
+// listRoleHierarchy returns the roles every role inherits from directly
+func (s *Server) listRoleHierarchy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var roles []store.Role
+		s.db.Order("name").Find(&roles)
+
+		hierarchy := make([]gin.H, 0, len(roles))
+		for _, role := range roles {
+			inherited, _ := s.policy.Enforcer.GetImplicitRolesForUser(role.Name, policy.GlobalDomain)
+			if inherited == nil {
+				inherited = []string{}
+			}
+			hierarchy = append(hierarchy, gin.H{
+				"role":           role.Name,
+				"inherits":       s.policy.RoleParents(role.Name),
+				"implicit_roles": inherited,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"roles": hierarchy,
+		})
+	}
+}
+
+// addRoleParent makes a role inherit another role
+func (s *Server) addRoleParent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var inheritDTO struct {
+			Role string `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&inheritDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		role := c.Param("name")
+		previous := s.policy.RoleParents(role)
+		if err := s.policy.InheritRole(role, inheritDTO.Role); err != nil {
+			roleUpdateError(c, err)
+			return
+		}
+
+		s.audit(c, "role.inherit.add", role, store.AuditSuccess, gin.H{"inherits": previous}, gin.H{"inherits": s.policy.RoleParents(role)})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Role inheritance added successfully",
+			"role":     role,
+			"inherits": s.policy.RoleParents(role),
+		})
+	}
+}
+
+// removeRoleParent stops a role from inheriting another role
+func (s *Server) removeRoleParent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.Param("name")
+		previous := s.policy.RoleParents(role)
+
+		removed, err := s.policy.Enforcer.RemoveGroupingPolicy(role, c.Param("parent"), policy.GlobalDomain)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update roles"})
+			return
+		}
+		if !removed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role does not inherit that role"})
+			return
+		}
+
+		s.audit(c, "role.inherit.remove", role, store.AuditSuccess, gin.H{"inherits": previous}, gin.H{"inherits": s.policy.RoleParents(role)})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Role inheritance removed successfully",
+			"role":     role,
+			"inherits": s.policy.RoleParents(role),
+		})
+	}
+}
+
+// userPermissions returns the effective permissions of a user
+func (s *Server) userPermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user store.User
+		if result := s.db.First(&user, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user":    user.Username,
+			"subject": policy.AuthSubject(user),
+			"domains": s.policy.EffectivePermissions(user),
+		})
+	}
+}
+
+// userPermissionsPage shows the effective permissions of a user in the
+// admin dashboard
+func (s *Server) userPermissionsPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user store.User
+		if result := s.db.First(&user, c.Param("id")); result.Error != nil {
+			s.render(c, http.StatusNotFound, "user_permissions.html", gin.H{
+				"title": "Effective Permissions",
+				"error": "User not found",
+			})
+			return
+		}
+
+		s.render(c, http.StatusOK, "user_permissions.html", gin.H{
+			"title":   "Effective Permissions",
+			"user":    user,
+			"subject": policy.AuthSubject(user),
+			"domains": s.policy.EffectivePermissions(user),
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package httpapi
+
+// routes registers every endpoint of the server
+func (s *Server) routes() {
+	r := s.engine
+
+	// Public keys for offline token verification by other services
+	r.GET("/.well-known/jwks.json", s.jwks())
+
+	// OAuth 2.0 / OpenID Connect provider
+	r.GET("/.well-known/openid-configuration", s.discoveryEndpoint())
+	r.GET("/userinfo", s.Authentication(), s.userinfoEndpoint())
+	r.POST("/userinfo", s.Authentication(), s.userinfoEndpoint())
+
+	oauth := r.Group("/oauth")
+	{
+		oauth.GET("/authorize", s.authorizeEndpoint())
+		oauth.POST("/authorize", s.consentEndpoint())
+		oauth.POST("/token", s.tokenEndpoint())
+	}
+
+	// SCIM 2.0 provisioning
+	scim := r.Group(scimBasePath)
+	scim.Use(s.Authentication(), s.scimAuthenticated(), s.Authorization(), denyImpersonated())
+	{
+		scim.GET("/ServiceProviderConfig", s.scimServiceProviderConfig())
+		scim.GET("/Users", s.listSCIMUsers())
+		scim.POST("/Users", s.createSCIMUser())
+		scim.GET("/Users/:id", s.getSCIMUser())
+		scim.PUT("/Users/:id", s.replaceSCIMUser())
+		scim.PATCH("/Users/:id", s.patchSCIMUser())
+		scim.DELETE("/Users/:id", s.deleteSCIMUser())
+		scim.GET("/Groups", s.listSCIMGroups())
+		scim.POST("/Groups", s.createSCIMGroup())
+		scim.GET("/Groups/:id", s.getSCIMGroup())
+		scim.PUT("/Groups/:id", s.replaceSCIMGroup())
+		scim.PATCH("/Groups/:id", s.patchSCIMGroup())
+		scim.DELETE("/Groups/:id", s.deleteSCIMGroup())
+	}
+
+	// Web UI routes
+	r.GET("/", s.indexPage())
+	r.GET("/login", s.loginPage())
+	r.GET("/register", s.registerPage())
+
+	// Admin dashboard routes
+	adminRoutes := r.Group("/admin")
+	adminRoutes.Use(s.Authentication(), s.Authorization())
+	{
+		adminRoutes.GET("/dashboard", s.dashboardPage())
+		adminRoutes.GET("/users", s.usersPage())
+		adminRoutes.GET("/roles", s.rolesPage())
+		adminRoutes.GET("/policies", s.policiesPage())
+		adminRoutes.GET("/audit", s.auditPage())
+		adminRoutes.GET("/users/:id/permissions", s.userPermissionsPage())
+		adminRoutes.POST("/users/:id/impersonate", denyImpersonated(), s.impersonateUserPage())
+	}
+
+	// Ending an impersonation only needs the impersonated session or token,
+	// since the user it acts as may not be authorized for these paths
+	r.POST("/impersonation/end", s.Authentication(), s.endImpersonationPage())
+	r.POST("/api/auth/impersonation/end", s.Authentication(), s.endImpersonation())
+
+	// Scripts of cookie sessions fetch the CSRF token here, before logging in too
+	r.GET("/api/auth/csrf", s.csrfTokenEndpoint())
+
+	// API routes
+	api := r.Group("/api")
+	api.Use(s.Authentication(), s.Authorization())
+	{
+		// Auth routes
+		authRoutes := api.Group("/auth")
+		{
+			authRoutes.POST("/register", s.register())
+			authRoutes.POST("/login", s.login())
+			authRoutes.POST("/mfa", s.verifyMFAChallenge())
+			authRoutes.POST("/mfa/enroll", s.enrollTOTPChallenge())
+
+			// Email verification and password reset
+			authRoutes.POST("/verify-email", s.verifyEmail())
+			authRoutes.POST("/verify-email/resend", s.resendVerificationEmail())
+			authRoutes.POST("/password/forgot", s.forgotPassword())
+			authRoutes.POST("/password/reset", s.resetPassword())
+
+			// Change the organization carried in the access token
+			authRoutes.POST("/switch-org", denyImpersonated(), s.switchOrg())
+			authRoutes.POST("/refresh", s.refresh())
+			authRoutes.POST("/logout", s.logout())
+		}
+
+		// Role management API
+		roles := api.Group("/roles")
+		{
+			roles.GET("", s.listRoles())
+			roles.POST("", denyImpersonated(), s.createRole())
+			roles.DELETE("/:id", denyImpersonated(), s.deleteRole())
+		}
+
+		// Policy management API
+		policies := api.Group("/policies")
+		{
+			policies.GET("", s.listPolicies())
+			policies.POST("", denyImpersonated(), s.addPolicy())
+			policies.DELETE("", denyImpersonated(), s.removePolicy())
+
+			// Policies of the ABAC model, with a condition over the request context
+			policies.GET("/abac", s.listABACPolicies())
+			policies.POST("/abac", denyImpersonated(), s.addABACPolicy())
+			policies.DELETE("/abac", denyImpersonated(), s.removeABACPolicy())
+
+			// Get policies by role
+			policies.GET("/role/:role", s.rolePolicies())
+		}
+
+		// Organizations, each a Casbin domain with its own members and policies
+		orgs := api.Group("/orgs")
+		{
+			orgs.GET("", s.listOrgs())
+			orgs.POST("", denyImpersonated(), s.createOrg())
+			orgs.GET("/:org", s.getOrg())
+			orgs.DELETE("/:org", denyImpersonated(), s.deleteOrg())
+			orgs.GET("/:org/members", s.listOrgMembers())
+			orgs.PUT("/:org/members/:username", denyImpersonated(), s.setOrgMember())
+			orgs.DELETE("/:org/members/:username", denyImpersonated(), s.removeOrgMember())
+			orgs.GET("/:org/policies", s.listOrgPolicies())
+			orgs.POST("/:org/policies", denyImpersonated(), s.addOrgPolicy())
+			orgs.DELETE("/:org/policies", denyImpersonated(), s.removeOrgPolicy())
+		}
+
+		// Temporary role grants
+		grants := api.Group("/grants")
+		{
+			grants.GET("", s.listMyGrants())
+			grants.POST("", denyImpersonated(), s.requestGrant())
+			grants.POST("/break-glass", denyImpersonated(), s.breakGlassGrant())
+		}
+
+		// Admin routes. Impersonated users never reach them, so an admin
+		// cannot act on their own rights through someone else's session.
+		admin := api.Group("/admin")
+		admin.Use(denyImpersonated())
+		{
+			admin.GET("/users", s.listAllUsers())
+			admin.POST("/users", s.createUser())
+
+			// Account state: disabled users keep their data, deleted users
+			// are soft-deleted and can be restored
+			admin.POST("/users/:id/disable", s.disableUser())
+			admin.POST("/users/:id/enable", s.enableUser())
+			admin.DELETE("/users/:id", s.deleteUser())
+			admin.POST("/users/:id/restore", s.restoreUser())
+
+			// Get Casbin model
+			admin.GET("/model", s.getModel())
+
+			// Update user role
+			admin.PUT("/users/:id/role", s.updateUserRole())
+
+			// Set a password without the emailed reset link
+			admin.PUT("/users/:id/password", s.setUserPassword())
+
+			// Multiple roles per user and effective permissions
+			admin.GET("/users/:id/roles", s.getUserRoles())
+			admin.PUT("/users/:id/roles", s.setUserRoles())
+			admin.GET("/users/:id/permissions", s.userPermissions())
+
+			// Require MFA for every member of a role
+			admin.PUT("/roles/:name/mfa", s.setRoleMFA())
+
+			// Approval and review of temporary role grants
+			admin.GET("/grants", s.listGrants())
+			admin.POST("/grants/:id/approve", s.approveGrant())
+			admin.POST("/grants/:id/deny", s.denyGrant())
+			admin.POST("/grants/:id/revoke", s.revokeGrant())
+			admin.POST("/grants/:id/review", s.reviewGrant())
+
+			// Role inheritance
+			admin.GET("/roles/hierarchy", s.listRoleHierarchy())
+			admin.POST("/roles/:name/parents", s.addRoleParent())
+			admin.DELETE("/roles/:name/parents/:parent", s.removeRoleParent())
+
+			// OAuth client registry
+			admin.GET("/oauth/clients", s.listOAuthClients())
+			admin.POST("/oauth/clients", s.createOAuthClient())
+			admin.DELETE("/oauth/clients/:id", s.deleteOAuthClient())
+
+			// Invitations granting elevated roles
+			admin.GET("/invitations", s.listInvitations())
+			admin.POST("/invitations", s.createInvitation())
+			admin.DELETE("/invitations/:id", s.revokeInvitation())
+
+			// Service accounts and their API keys
+			admin.GET("/service-accounts", s.listServiceAccounts())
+			admin.POST("/service-accounts", s.createServiceAccount())
+			admin.GET("/service-accounts/:id/keys", s.listServiceAccountKeys())
+			admin.POST("/service-accounts/:id/keys", s.createServiceAccountKey())
+			admin.DELETE("/service-accounts/:id/keys/:keyID", s.deleteServiceAccountKey())
+
+			// Tamper-evident audit log
+			admin.GET("/audit", s.listAuditEvents())
+			admin.GET("/audit/verify", s.verifyAuditEvents())
+
+			// Signing key management
+			admin.GET("/keys", s.listSigningKeys())
+			admin.POST("/keys/rotate", s.rotateSigningKeys())
+
+			// Explain decisions and dry-run policy changes
+			admin.POST("/policies/check", s.checkPolicy())
+			admin.POST("/policies/simulate", s.simulatePolicies())
+
+			// Declarative policy files
+			admin.GET("/policies/export", s.exportPolicies())
+			admin.POST("/policies/import", s.importPolicies())
+
+			// Brute-force lockouts
+			admin.GET("/lockouts", s.listLockouts())
+			admin.POST("/users/:id/unlock", s.unlockUser())
+
+			// Revoke every token issued to a user
+			admin.POST("/users/:id/logout", s.forceLogout())
+			admin.POST("/users/:id/revoke-tokens", s.revokeUserTokens())
+
+			// Webhook subscriptions to lifecycle events and their delivery log
+			admin.GET("/webhooks", s.listWebhooks())
+			admin.POST("/webhooks", s.createWebhook())
+			admin.PUT("/webhooks/:id", s.updateWebhook())
+			admin.DELETE("/webhooks/:id", s.deleteWebhook())
+			admin.POST("/webhooks/:id/secret", s.rotateWebhookSecret())
+			admin.GET("/webhooks/:id/deliveries", s.listWebhookDeliveries())
+			admin.POST("/webhooks/:id/deliveries/:deliveryID/redeliver", s.redeliverWebhookDelivery())
+
+			// Act as a user to reproduce what they see
+			admin.POST("/users/:id/impersonate", s.impersonateUser())
+		}
+
+		// User routes are authorized with the ABAC model, so policies can
+		// compare the caller with the user a request is about
+		users := api.Group("/users")
+		s.useABAC(users, s.userOwner)
+		{
+			users.GET("", s.listUsers())
+			users.GET("/:id", s.getUser())
+			users.PUT("/:id", denyImpersonated(), s.updateUser())
+		}
+
+		// Profile routes
+		api.GET("/profile", s.getProfile())
+
+		// Personal API keys
+		keys := api.Group("/keys")
+		{
+			keys.GET("", s.listAPIKeys())
+			keys.POST("", denyImpersonated(), s.createAPIKey())
+			keys.DELETE("/:id", denyImpersonated(), s.deleteAPIKey())
+		}
+
+		// MFA enrolment for the authenticated user. Impersonated sessions
+		// cannot change credentials.
+		api.GET("/profile/sessions", s.listSessions())
+		api.DELETE("/profile/sessions", denyImpersonated(), s.revokeOtherSessions())
+		api.DELETE("/profile/sessions/:id", denyImpersonated(), s.revokeSession())
+		api.POST("/profile/mfa/totp", denyImpersonated(), s.enrollTOTP())
+		api.POST("/profile/mfa/totp/verify", denyImpersonated(), s.verifyTOTPEnrollment())
+		api.POST("/profile/mfa/recovery-codes", denyImpersonated(), s.regenerateRecoveryCodes())
+		api.DELETE("/profile/mfa", denyImpersonated(), s.disableMFA())
+		api.PUT("/profile", denyImpersonated(), s.updateProfile())
+	}
+}
@@ -0,0 +1,1080 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"iam/auth"
+	"iam/policy"
+	"iam/store"
+)
+
+// SCIM 2.0 provisioning (RFC 7643, RFC 7644). Users map onto User, groups
+// onto Role with members given by global role assignments. Clients
+// authenticate with a bearer API key of a service account that holds the
+// scim-provisioner role.
+
+const (
+	scimUserSchema        = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema       = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema        = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema       = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimProviderSchema    = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimDefaultCount      = 100
+	scimMaxCount          = 500
+	scimContentType       = "application/scim+json"
+	scimBasePath          = "/scim/v2"
+	scimInvalidValue      = "invalidValue"
+	scimInvalidFilterType = "invalidFilter"
+	scimInvalidPath       = "invalidPath"
+	scimUniqueness        = "uniqueness"
+	scimMutability        = "mutability"
+)
+
+// Roles that are not managed through SCIM groups
+var scimProtectedRoles = []string{"admin", "guest", policy.UnverifiedRole, policy.OrgAdminRole, policy.OrgMemberRole, policy.SCIMProvisionerRole, policy.OnCallRole}
+
+var errLastRole = errors.New("a user needs at least one role")
+
+var errProtectedUser = errors.New("user holds a role that is not managed through SCIM")
+
+// scimUserAttrs are the user attributes filters may use
+var scimUserAttrs = map[string]scimAttr{
+	"id":                {column: "id", kind: scimID},
+	"username":          {column: "username", kind: scimString},
+	"externalid":        {column: "external_id", kind: scimString},
+	"emails":            {column: "email", kind: scimString},
+	"emails.value":      {column: "email", kind: scimString},
+	"active":            {column: "disabled", kind: scimBool, negate: true},
+	"meta.created":      {column: "created_at", kind: scimTime},
+	"meta.lastmodified": {column: "updated_at", kind: scimTime},
+}
+
+// scimGroupAttrs are the group attributes filters may use
+var scimGroupAttrs = map[string]scimAttr{
+	"id":          {column: "id", kind: scimID},
+	"displayname": {column: "name", kind: scimString},
+}
+
+type scimMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// scimUser is the SCIM representation of a user. Attributes the user model
+// has no field for are ignored.
+type scimUser struct {
+	Schemas    []string     `json:"schemas"`
+	ID         string       `json:"id,omitempty"`
+	ExternalID string       `json:"externalId,omitempty"`
+	UserName   string       `json:"userName"`
+	Emails     []scimEmail  `json:"emails,omitempty"`
+	Active     *bool        `json:"active,omitempty"`
+	Password   string       `json:"password,omitempty"`
+	Groups     []scimMember `json:"groups,omitempty"`
+	Meta       *scimMeta    `json:"meta,omitempty"`
+}
+
+// scimGroup is the SCIM representation of a role
+type scimGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []scimMember `json:"members,omitempty"`
+	Meta        *scimMeta    `json:"meta,omitempty"`
+}
+
+// scimPatch is a PatchOp request
+type scimPatch struct {
+	Schemas    []string `json:"schemas"`
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations" binding:"required"`
+}
+
+// scimJSON responds with a SCIM resource
+func scimJSON(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", scimContentType)
+	c.JSON(status, body)
+}
+
+// scimError responds with a SCIM error
+func scimError(c *gin.Context, status int, scimType, detail string) {
+	body := gin.H{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	c.Header("Content-Type", scimContentType)
+	c.AbortWithStatusJSON(status, body)
+}
+
+// scimAuthenticated rejects requests without credentials with a SCIM error
+func (s *Server) scimAuthenticated() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := currentUser(c); !exists {
+			scimError(c, http.StatusUnauthorized, "", "Authentication required")
+			return
+		}
+		c.Next()
+	}
+}
+
+// scimLocation returns the absolute URL of a resource
+func (s *Server) scimLocation(resource, id string) string {
+	return s.auth.Config.PublicURL + scimBasePath + "/" + resource + "/" + id
+}
+
+// scimPage reads startIndex and count
+func scimPage(c *gin.Context) (int, int) {
+	start, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil || start < 1 {
+		start = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(scimDefaultCount)))
+	if err != nil || count < 0 {
+		count = scimDefaultCount
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+	return start, count
+}
+
+// scimList responds with a page of resources
+func scimList(c *gin.Context, total int64, start int, resources interface{}, items int) {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":      []string{scimListSchema},
+		"totalResults": total,
+		"startIndex":   start,
+		"itemsPerPage": items,
+		"Resources":    resources,
+	})
+}
+
+// permanentRoles returns the global roles of a user that are not held
+// through a temporary grant, primary role first
+func (s *Server) permanentRoles(user store.User) []string {
+	granted := s.policy.GrantedRoles(user.ID)
+	var roles []string
+	for _, role := range s.policy.UserRoles(user) {
+		if slices.Contains(granted, role) {
+			continue
+		}
+		if role == user.Role {
+			roles = append([]string{role}, roles...)
+		} else {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// changeUserRoles updates the permanent roles of a user. Tokens are
+// revoked when the primary role, which tokens carry, changes.
+func (s *Server) changeUserRoles(user store.User, roles []string) error {
+	if len(roles) == 0 {
+		return errLastRole
+	}
+	primary := user.Role
+	if err := s.policy.SetUserRoles(&user, roles); err != nil {
+		return err
+	}
+	if user.Role != primary {
+		return s.auth.RevokeUserTokens(user.ID)
+	}
+	return nil
+}
+
+// toSCIMUser converts a user into its SCIM representation
+func (s *Server) toSCIMUser(user store.User) scimUser {
+	id := strconv.FormatUint(uint64(user.ID), 10)
+	active := !user.Disabled
+
+	groups := []scimMember{}
+	var roles []store.Role
+	s.db.Where("name IN ?", s.policy.UserRoles(user)).Find(&roles)
+	for _, role := range roles {
+		groupID := strconv.FormatUint(uint64(role.ID), 10)
+		groups = append(groups, scimMember{Value: groupID, Display: role.Name, Ref: s.scimLocation("Groups", groupID)})
+	}
+
+	return scimUser{
+		Schemas:    []string{scimUserSchema},
+		ID:         id,
+		ExternalID: user.ExternalID,
+		UserName:   user.Username,
+		Emails:     []scimEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active:     &active,
+		Groups:     groups,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      &user.CreatedAt,
+			LastModified: &user.UpdatedAt,
+			Location:     s.scimLocation("Users", id),
+		},
+	}
+}
+
+// primaryEmail returns the primary email of a SCIM user, or the first one
+func (u scimUser) primaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// scimUsers is the SCIM Users resource
+func (s *Server) scimUsers() *gorm.DB {
+	return s.db.Model(&store.User{}).Where("service_account = ?", false)
+}
+
+// scimUserByID loads the user in the :id route parameter
+func (s *Server) scimUserByID(c *gin.Context) (store.User, bool) {
+	var user store.User
+	if result := s.scimUsers().Where("id = ?", c.Param("id")).First(&user); result.Error != nil {
+		scimError(c, http.StatusNotFound, "", "User not found")
+		return store.User{}, false
+	}
+	return user, true
+}
+
+// protectedUserRole returns a role SCIM does not manage that the user
+// holds in any domain, directly or inherited, or "" if there is none
+func (s *Server) protectedUserRole(user store.User) string {
+	roles := []string{user.Role}
+	for _, domain := range s.policy.EffectivePermissions(user) {
+		roles = append(roles, domain.Roles...)
+		roles = append(roles, domain.ImplicitRoles...)
+	}
+	for _, role := range roles {
+		if slices.Contains(scimProtectedRoles, role) {
+			return role
+		}
+	}
+	return ""
+}
+
+// protectedGroupRole returns the role SCIM does not manage that the role
+// is or inherits, or "" if there is none
+func (s *Server) protectedGroupRole(role string) string {
+	inherited, _ := s.policy.Enforcer.GetImplicitRolesForUser(role, policy.GlobalDomain)
+	for _, name := range append([]string{role}, inherited...) {
+		if slices.Contains(scimProtectedRoles, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// checkSCIMMembers refuses membership changes of users holding a role
+// SCIM does not manage
+func (s *Server) checkSCIMMembers(users []store.User) error {
+	for _, user := range users {
+		if role := s.protectedUserRole(user); role != "" {
+			return fmt.Errorf("%w: %s holds the %s role", errProtectedUser, user.Username, role)
+		}
+	}
+	return nil
+}
+
+// scimManagedUserByID loads the user in the :id route parameter for a
+// change. Users holding a role SCIM does not manage, in any domain, are
+// refused, so a provisioner cannot take over administrators.
+func (s *Server) scimManagedUserByID(c *gin.Context) (store.User, bool) {
+	user, ok := s.scimUserByID(c)
+	if !ok {
+		return store.User{}, false
+	}
+
+	if role := s.protectedUserRole(user); role != "" {
+		scimError(c, http.StatusForbidden, "", "User holds the "+role+" role, which is not managed through SCIM")
+		return store.User{}, false
+	}
+	return user, true
+}
+
+// saveSCIMUser stores changes to a user, reporting conflicts as SCIM errors
+func (s *Server) saveSCIMUser(c *gin.Context, user *store.User, disabled bool) bool {
+	var conflicts int64
+	s.db.Unscoped().Model(&store.User{}).
+		Where("id <> ? AND (username = ? OR email = ?)", user.ID, user.Username, user.Email).
+		Count(&conflicts)
+	if conflicts > 0 {
+		scimError(c, http.StatusConflict, scimUniqueness, "userName or email is already in use")
+		return false
+	}
+
+	var stored store.User
+	if err := s.db.Select("username", "email").First(&stored, user.ID).Error; err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Failed to update user")
+		return false
+	}
+	if err := s.db.Model(user).Select("username", "email", "external_id").Updates(user).Error; err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Failed to update user")
+		return false
+	}
+
+	// Tokens name the user, so a new name or address ends them
+	if stored.Username != user.Username || stored.Email != user.Email {
+		if err := s.auth.RevokeUserTokens(user.ID); err != nil {
+			scimError(c, http.StatusInternalServerError, "", "Failed to update user")
+			return false
+		}
+	}
+	if disabled != user.Disabled {
+		if err := s.auth.SetUserDisabled(user, disabled); err != nil {
+			scimError(c, http.StatusInternalServerError, "", "Failed to update user")
+			return false
+		}
+		user.Disabled = disabled
+	}
+	return true
+}
+
+// listSCIMUsers returns users matching a filter, one page at a time
+func (s *Server) listSCIMUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := s.scimUsers()
+		if filter := c.Query("filter"); filter != "" {
+			parsed, err := parseSCIMFilter(filter, scimUserAttrs)
+			if err != nil {
+				scimError(c, http.StatusBadRequest, scimInvalidFilterType, err.Error())
+				return
+			}
+			query = query.Where(parsed.sql, parsed.args...)
+		}
+
+		var total int64
+		query.Count(&total)
+
+		start, count := scimPage(c)
+		var users []store.User
+		query.Order("id").Offset(start - 1).Limit(count).Find(&users)
+
+		resources := make([]scimUser, 0, len(users))
+		for _, user := range users {
+			resources = append(resources, s.toSCIMUser(user))
+		}
+		scimList(c, total, start, resources, len(resources))
+	}
+}
+
+// getSCIMUser returns a user
+func (s *Server) getSCIMUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.scimUserByID(c)
+		if !ok {
+			return
+		}
+		scimJSON(c, http.StatusOK, s.toSCIMUser(user))
+	}
+}
+
+// createSCIMUser provisions a user with the default role. Users created
+// without a password set one through the password reset flow.
+func (s *Server) createSCIMUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var resource scimUser
+		if err := c.ShouldBindJSON(&resource); err != nil {
+			scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+			return
+		}
+		if resource.UserName == "" || resource.primaryEmail() == "" {
+			scimError(c, http.StatusBadRequest, scimInvalidValue, "userName and an email are required")
+			return
+		}
+
+		var conflicts int64
+		s.db.Unscoped().Model(&store.User{}).Where("username = ? OR email = ?", resource.UserName, resource.primaryEmail()).Count(&conflicts)
+		if conflicts > 0 {
+			scimError(c, http.StatusConflict, scimUniqueness, "userName or email is already in use")
+			return
+		}
+
+		var passwordHash string
+		if resource.Password != "" {
+			candidate := store.User{Username: resource.UserName, Email: resource.primaryEmail()}
+			if err := s.auth.ValidatePassword(candidate, resource.Password); err != nil {
+				if errors.Is(err, auth.ErrWeakPassword) {
+					scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+				} else {
+					scimError(c, http.StatusInternalServerError, "", "Failed to check password")
+				}
+				return
+			}
+			var err error
+			passwordHash, err = s.auth.HashPassword(resource.Password)
+			if errors.Is(err, auth.ErrPasswordHashBusy) {
+				scimError(c, http.StatusServiceUnavailable, "", "Server busy, try again later")
+				return
+			}
+			if err != nil {
+				scimError(c, http.StatusInternalServerError, "", "Failed to hash password")
+				return
+			}
+		}
+
+		// The provisioning source vouches for the address
+		now := time.Now()
+		user := store.User{
+			Username:        resource.UserName,
+			Email:           resource.primaryEmail(),
+			ExternalID:      resource.ExternalID,
+			PasswordHash:    passwordHash,
+			Role:            s.policy.Config.DefaultRole,
+			EmailVerified:   true,
+			EmailVerifiedAt: &now,
+			Disabled:        resource.Active != nil && !*resource.Active,
+		}
+		if err := s.db.Create(&user).Error; err != nil {
+			scimError(c, http.StatusInternalServerError, "", "Failed to create user")
+			return
+		}
+		if err := s.policy.AssignRole(user); err != nil {
+			scimError(c, http.StatusInternalServerError, "", "Failed to create user")
+			return
+		}
+
+		s.audit(c, "scim.user.create", user.Username, store.AuditSuccess, nil, gin.H{"email": user.Email, "external_id": user.ExternalID, "active": !user.Disabled})
+
+		c.Header("Location", s.scimLocation("Users", strconv.FormatUint(uint64(user.ID), 10)))
+		scimJSON(c, http.StatusCreated, s.toSCIMUser(user))
+	}
+}
+
+// replaceSCIMUser replaces the attributes of a user
+func (s *Server) replaceSCIMUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.scimManagedUserByID(c)
+		if !ok {
+			return
+		}
+
+		var resource scimUser
+		if err := c.ShouldBindJSON(&resource); err != nil {
+			scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+			return
+		}
+		if resource.UserName == "" || resource.primaryEmail() == "" {
+			scimError(c, http.StatusBadRequest, scimInvalidValue, "userName and an email are required")
+			return
+		}
+
+		before := s.toSCIMUser(user)
+		user.Username = resource.UserName
+		user.Email = resource.primaryEmail()
+		user.ExternalID = resource.ExternalID
+		disabled := resource.Active != nil && !*resource.Active
+		if !s.saveSCIMUser(c, &user, disabled) {
+			return
+		}
+
+		after := s.toSCIMUser(user)
+		s.audit(c, "scim.user.replace", user.Username, store.AuditSuccess, before, after)
+		scimJSON(c, http.StatusOK, after)
+	}
+}
+
+// scimBoolValue reads a boolean patch value. Some clients send "True" or "False".
+func scimBoolValue(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(strings.ToLower(s))
+}
+
+// applyUserAttr applies one attribute of a patch operation to a user
+func applyUserAttr(user *store.User, disabled *bool, op, path string, value json.RawMessage) error {
+	name := scimAttrName(path)
+	if strings.HasPrefix(name, "emails") {
+		name = "emails"
+	}
+
+	switch name {
+	case "active":
+		if op == "remove" {
+			return fmt.Errorf("active cannot be removed")
+		}
+		active, err := scimBoolValue(value)
+		if err != nil {
+			return fmt.Errorf("active must be a boolean")
+		}
+		*disabled = !active
+	case "username":
+		if op == "remove" || json.Unmarshal(value, &user.Username) != nil || user.Username == "" {
+			return fmt.Errorf("userName must be a non-empty string")
+		}
+	case "externalid":
+		if op == "remove" {
+			user.ExternalID = ""
+		} else if json.Unmarshal(value, &user.ExternalID) != nil {
+			return fmt.Errorf("externalId must be a string")
+		}
+	case "emails":
+		if op == "remove" {
+			return fmt.Errorf("email cannot be removed")
+		}
+		// emails[type eq "work"].value carries a plain string
+		var email string
+		if json.Unmarshal(value, &email) != nil {
+			var emails []scimEmail
+			if json.Unmarshal(value, &emails) != nil {
+				return fmt.Errorf("emails must be a list of emails")
+			}
+			email = scimUser{Emails: emails}.primaryEmail()
+		}
+		if email == "" {
+			return fmt.Errorf("email must not be empty")
+		}
+		user.Email = email
+	}
+	return nil
+}
+
+// patchSCIMUser applies PatchOp operations to a user
+func (s *Server) patchSCIMUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.scimManagedUserByID(c)
+		if !ok {
+			return
+		}
+
+		var patch scimPatch
+		if err := c.ShouldBindJSON(&patch); err != nil {
+			scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+			return
+		}
+
+		before := s.toSCIMUser(user)
+		disabled := user.Disabled
+		for _, operation := range patch.Operations {
+			op := strings.ToLower(operation.Op)
+			if op != "add" && op != "replace" && op != "remove" {
+				scimError(c, http.StatusBadRequest, scimInvalidValue, "Unsupported op "+operation.Op)
+				return
+			}
+
+			// Without a path the value holds the attributes to change
+			if operation.Path == "" {
+				var attrs map[string]json.RawMessage
+				if err := json.Unmarshal(operation.Value, &attrs); err != nil {
+					scimError(c, http.StatusBadRequest, scimInvalidValue, "Value must be an object without a path")
+					return
+				}
+				for path, value := range attrs {
+					if err := applyUserAttr(&user, &disabled, op, path, value); err != nil {
+						scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+						return
+					}
+				}
+				continue
+			}
+
+			if err := applyUserAttr(&user, &disabled, op, operation.Path, operation.Value); err != nil {
+				scimError(c, http.StatusBadRequest, scimInvalidPath, err.Error())
+				return
+			}
+		}
+
+		if !s.saveSCIMUser(c, &user, disabled) {
+			return
+		}
+
+		after := s.toSCIMUser(user)
+		s.audit(c, "scim.user.patch", user.Username, store.AuditSuccess, before, after)
+		scimJSON(c, http.StatusOK, after)
+	}
+}
+
+// deleteSCIMUser removes a user from the directory. The row is soft
+// deleted and the user signed out everywhere.
+func (s *Server) deleteSCIMUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.scimManagedUserByID(c)
+		if !ok {
+			return
+		}
+
+		if err := s.auth.SetUserDisabled(&user, true); err != nil {
+			scimError(c, http.StatusInternalServerError, "", "Failed to delete user")
+			return
+		}
+		if err := s.db.Delete(&user).Error; err != nil {
+			scimError(c, http.StatusInternalServerError, "", "Failed to delete user")
+			return
+		}
+
+		s.audit(c, "scim.user.delete", user.Username, store.AuditSuccess, s.toSCIMUser(user), nil)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// roleMembers returns the users assigned a role globally
+func (s *Server) roleMembers(role string) []store.User {
+	rules, _ := s.policy.Enforcer.GetFilteredGroupingPolicy(1, role, policy.GlobalDomain)
+	var ids []string
+	for _, rule := range rules {
+		if strings.HasPrefix(rule[0], policy.UserSubjectPrefix) {
+			ids = append(ids, strings.TrimPrefix(rule[0], policy.UserSubjectPrefix))
+		}
+	}
+
+	var users []store.User
+	if len(ids) > 0 {
+		s.scimUsers().Where("id IN ?", ids).Order("id").Find(&users)
+	}
+	return users
+}
+
+// toSCIMGroup converts a role into its SCIM representation
+func (s *Server) toSCIMGroup(role store.Role, withMembers bool) scimGroup {
+	id := strconv.FormatUint(uint64(role.ID), 10)
+	group := scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          id,
+		DisplayName: role.Name,
+		Meta: &scimMeta{
+			ResourceType: "Group",
+			Location:     s.scimLocation("Groups", id),
+		},
+	}
+	if withMembers {
+		group.Members = []scimMember{}
+		for _, user := range s.roleMembers(role.Name) {
+			userID := strconv.FormatUint(uint64(user.ID), 10)
+			group.Members = append(group.Members, scimMember{Value: userID, Display: user.Username, Ref: s.scimLocation("Users", userID)})
+		}
+	}
+	return group
+}
+
+// scimGroupByID loads the role in the :id route parameter
+func (s *Server) scimGroupByID(c *gin.Context) (store.Role, bool) {
+	var role store.Role
+	if result := s.db.Where("id = ?", c.Param("id")).First(&role); result.Error != nil {
+		scimError(c, http.StatusNotFound, "", "Group not found")
+		return store.Role{}, false
+	}
+	return role, true
+}
+
+// scimMemberUsers resolves member references to users
+func (s *Server) scimMemberUsers(members []scimMember) ([]store.User, error) {
+	users := make([]store.User, 0, len(members))
+	for _, member := range members {
+		var user store.User
+		if result := s.scimUsers().Where("id = ?", member.Value).First(&user); result.Error != nil {
+			return nil, fmt.Errorf("unknown member %q", member.Value)
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// roleMemberChanges returns the users whose permanent roles change when
+// they are added to or removed from the role
+func (s *Server) roleMemberChanges(role string, users []store.User, add bool) []store.User {
+	var changed []store.User
+	for _, user := range users {
+		if slices.Contains(s.permanentRoles(user), role) != add {
+			changed = append(changed, user)
+		}
+	}
+	return changed
+}
+
+// addRoleMembers assigns the role to users who do not have it yet
+func (s *Server) addRoleMembers(role string, users []store.User) error {
+	added := s.roleMemberChanges(role, users, true)
+	if err := s.checkSCIMMembers(added); err != nil {
+		return err
+	}
+	for _, user := range added {
+		if err := s.changeUserRoles(user, append(s.permanentRoles(user), role)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRoleMembers takes the role away from users
+func (s *Server) removeRoleMembers(role string, users []store.User) error {
+	removed := s.roleMemberChanges(role, users, false)
+	if err := s.checkSCIMMembers(removed); err != nil {
+		return err
+	}
+	for _, user := range removed {
+		roles := slices.DeleteFunc(s.permanentRoles(user), func(r string) bool { return r == role })
+		if err := s.changeUserRoles(user, roles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setRoleMembers makes users the exact member list of the role
+func (s *Server) setRoleMembers(role string, users []store.User) error {
+	keep := make(map[uint]bool, len(users))
+	for _, user := range users {
+		keep[user.ID] = true
+	}
+
+	var removed []store.User
+	for _, member := range s.roleMembers(role) {
+		if !keep[member.ID] {
+			removed = append(removed, member)
+		}
+	}
+
+	// Check everyone first, so a refusal changes nothing
+	changed := append(s.roleMemberChanges(role, users, true), s.roleMemberChanges(role, removed, false)...)
+	if err := s.checkSCIMMembers(changed); err != nil {
+		return err
+	}
+	if err := s.addRoleMembers(role, users); err != nil {
+		return err
+	}
+	return s.removeRoleMembers(role, removed)
+}
+
+// scimMembershipError responds to a failed membership change
+func scimMembershipError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errLastRole), errors.Is(err, policy.ErrRoleGranted):
+		scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+	case errors.Is(err, errProtectedUser):
+		scimError(c, http.StatusForbidden, "", err.Error())
+	default:
+		scimError(c, http.StatusInternalServerError, "", "Failed to update members")
+	}
+}
+
+// listSCIMGroups returns roles matching a filter, one page at a time
+func (s *Server) listSCIMGroups() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := s.db.Model(&store.Role{})
+		if filter := c.Query("filter"); filter != "" {
+			parsed, err := parseSCIMFilter(filter, scimGroupAttrs)
+			if err != nil {
+				scimError(c, http.StatusBadRequest, scimInvalidFilterType, err.Error())
+				return
+			}
+			query = query.Where(parsed.sql, parsed.args...)
+		}
+
+		var total int64
+		query.Count(&total)
+
+		start, count := scimPage(c)
+		var roles []store.Role
+		query.Order("id").Offset(start - 1).Limit(count).Find(&roles)
+
+		withMembers := !strings.Contains(strings.ToLower(c.Query("excludedAttributes")), "members")
+		resources := make([]scimGroup, 0, len(roles))
+		for _, role := range roles {
+			resources = append(resources, s.toSCIMGroup(role, withMembers))
+		}
+		scimList(c, total, start, resources, len(resources))
+	}
+}
+
+// getSCIMGroup returns a role with its members
+func (s *Server) getSCIMGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, ok := s.scimGroupByID(c)
+		if !ok {
+			return
+		}
+		withMembers := !strings.Contains(strings.ToLower(c.Query("excludedAttributes")), "members")
+		scimJSON(c, http.StatusOK, s.toSCIMGroup(role, withMembers))
+	}
+}
+
+// createSCIMGroup creates a role with members. The role has no policies
+// until an administrator adds them.
+func (s *Server) createSCIMGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var resource scimGroup
+		if err := c.ShouldBindJSON(&resource); err != nil || resource.DisplayName == "" {
+			scimError(c, http.StatusBadRequest, scimInvalidValue, "displayName is required")
+			return
+		}
+
+		var existing int64
+		s.db.Model(&store.Role{}).Where("name = ?", resource.DisplayName).Count(&existing)
+		if existing > 0 {
+			scimError(c, http.StatusConflict, scimUniqueness, "A group with that displayName exists")
+			return
+		}
+
+		users, err := s.scimMemberUsers(resource.Members)
+		if err != nil {
+			scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+			return
+		}
+
+		role := store.Role{Name: resource.DisplayName}
+		if err := s.db.Create(&role).Error; err != nil {
+			scimError(c, http.StatusInternalServerError, "", "Failed to create group")
+			return
+		}
+		if err := s.addRoleMembers(role.Name, users); err != nil {
+			scimMembershipError(c, err)
+			return
+		}
+
+		group := s.toSCIMGroup(role, true)
+		s.audit(c, "scim.group.create", role.Name, store.AuditSuccess, nil, group)
+
+		c.Header("Location", group.Meta.Location)
+		scimJSON(c, http.StatusCreated, group)
+	}
+}
+
+// replaceSCIMGroup replaces the members of a role
+func (s *Server) replaceSCIMGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, ok := s.scimGroupByID(c)
+		if !ok {
+			return
+		}
+		if protected := s.protectedGroupRole(role.Name); protected != "" {
+			scimError(c, http.StatusBadRequest, scimMutability, "This group is or inherits the "+protected+" role, which is not managed through SCIM")
+			return
+		}
+
+		var resource scimGroup
+		if err := c.ShouldBindJSON(&resource); err != nil {
+			scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+			return
+		}
+		if resource.DisplayName != "" && resource.DisplayName != role.Name {
+			scimError(c, http.StatusBadRequest, scimMutability, "displayName cannot be changed")
+			return
+		}
+
+		users, err := s.scimMemberUsers(resource.Members)
+		if err != nil {
+			scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+			return
+		}
+
+		before := s.toSCIMGroup(role, true)
+		if err := s.setRoleMembers(role.Name, users); err != nil {
+			scimMembershipError(c, err)
+			return
+		}
+
+		after := s.toSCIMGroup(role, true)
+		s.audit(c, "scim.group.replace", role.Name, store.AuditSuccess, before, after)
+		scimJSON(c, http.StatusOK, after)
+	}
+}
+
+// memberFilterValue extracts the user ID from a members[value eq "id"] path
+func memberFilterValue(path string) (string, bool) {
+	inner, found := strings.CutPrefix(path, "members[")
+	if !found {
+		return "", false
+	}
+	inner, found = strings.CutSuffix(inner, "]")
+	if !found {
+		return "", false
+	}
+	fields := strings.Fields(inner)
+	if len(fields) != 3 || !strings.EqualFold(fields[0], "value") || !strings.EqualFold(fields[1], "eq") {
+		return "", false
+	}
+	value, err := strconv.Unquote(fields[2])
+	return value, err == nil
+}
+
+// patchSCIMGroup applies PatchOp operations to the members of a role
+func (s *Server) patchSCIMGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, ok := s.scimGroupByID(c)
+		if !ok {
+			return
+		}
+		if protected := s.protectedGroupRole(role.Name); protected != "" {
+			scimError(c, http.StatusBadRequest, scimMutability, "This group is or inherits the "+protected+" role, which is not managed through SCIM")
+			return
+		}
+
+		var patch scimPatch
+		if err := c.ShouldBindJSON(&patch); err != nil {
+			scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+			return
+		}
+
+		before := s.toSCIMGroup(role, true)
+		for _, operation := range patch.Operations {
+			op := strings.ToLower(operation.Op)
+			path := operation.Path
+			value := operation.Value
+
+			// Without a path the value holds the attributes to change
+			if path == "" {
+				var attrs struct {
+					DisplayName string       `json:"displayName"`
+					Members     []scimMember `json:"members"`
+				}
+				if err := json.Unmarshal(value, &attrs); err != nil {
+					scimError(c, http.StatusBadRequest, scimInvalidValue, "Value must be an object without a path")
+					return
+				}
+				if attrs.DisplayName != "" && attrs.DisplayName != role.Name {
+					scimError(c, http.StatusBadRequest, scimMutability, "displayName cannot be changed")
+					return
+				}
+				if attrs.Members == nil {
+					continue
+				}
+				path = "members"
+				value, _ = json.Marshal(attrs.Members)
+			}
+
+			if strings.EqualFold(path, "displayName") {
+				var name string
+				if json.Unmarshal(value, &name) != nil || name != role.Name {
+					scimError(c, http.StatusBadRequest, scimMutability, "displayName cannot be changed")
+					return
+				}
+				continue
+			}
+
+			var members []scimMember
+			if id, ok := memberFilterValue(path); ok && op == "remove" {
+				members = []scimMember{{Value: id}}
+			} else if strings.EqualFold(path, "members") {
+				if len(value) > 0 && json.Unmarshal(value, &members) != nil {
+					scimError(c, http.StatusBadRequest, scimInvalidValue, "members must be a list of members")
+					return
+				}
+			} else {
+				scimError(c, http.StatusBadRequest, scimInvalidPath, "Unsupported path "+path)
+				return
+			}
+
+			users, err := s.scimMemberUsers(members)
+			if err != nil {
+				scimError(c, http.StatusBadRequest, scimInvalidValue, err.Error())
+				return
+			}
+
+			switch {
+			case op == "add":
+				err = s.addRoleMembers(role.Name, users)
+			case op == "replace":
+				err = s.setRoleMembers(role.Name, users)
+			case op == "remove" && len(value) == 0 && len(members) == 0:
+				// Removing members without a value empties the group
+				err = s.setRoleMembers(role.Name, nil)
+			case op == "remove":
+				err = s.removeRoleMembers(role.Name, users)
+			default:
+				scimError(c, http.StatusBadRequest, scimInvalidValue, "Unsupported op "+operation.Op)
+				return
+			}
+			if err != nil {
+				scimMembershipError(c, err)
+				return
+			}
+		}
+
+		after := s.toSCIMGroup(role, true)
+		s.audit(c, "scim.group.patch", role.Name, store.AuditSuccess, before, after)
+		scimJSON(c, http.StatusOK, after)
+	}
+}
+
+// deleteSCIMGroup deletes a role that no user holds anymore, with its
+// policies and inheritance
+func (s *Server) deleteSCIMGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, ok := s.scimGroupByID(c)
+		if !ok {
+			return
+		}
+		if slices.Contains(scimProtectedRoles, role.Name) || role.Name == s.policy.Config.DefaultRole {
+			scimError(c, http.StatusBadRequest, scimMutability, "This group is not managed through SCIM")
+			return
+		}
+		if members, _ := s.policy.Enforcer.GetFilteredGroupingPolicy(1, role.Name); len(members) > 0 {
+			scimError(c, http.StatusBadRequest, scimMutability, "Remove the group's members and child roles first")
+			return
+		}
+
+		policies, _ := s.policy.Enforcer.GetFilteredPolicy(0, role.Name)
+		s.policy.Enforcer.RemoveFilteredPolicy(0, role.Name)
+		s.policy.Enforcer.RemoveFilteredGroupingPolicy(0, role.Name)
+		s.db.Delete(&role)
+
+		s.audit(c, "scim.group.delete", role.Name, store.AuditSuccess, gin.H{"role": role, "policies": policies}, nil)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// scimServiceProviderConfig describes the supported SCIM features
+func (s *Server) scimServiceProviderConfig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scimJSON(c, http.StatusOK, gin.H{
+			"schemas":        []string{scimProviderSchema},
+			"patch":          gin.H{"supported": true},
+			"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+			"filter":         gin.H{"supported": true, "maxResults": scimMaxCount},
+			"changePassword": gin.H{"supported": false},
+			"sort":           gin.H{"supported": false},
+			"etag":           gin.H{"supported": false},
+			"authenticationSchemes": []gin.H{{
+				"type":        "oauthbearertoken",
+				"name":        "Bearer API key",
+				"description": "An API key of a service account with the " + policy.SCIMProvisionerRole + " role",
+				"primary":     true,
+			}},
+			"meta": gin.H{
+				"resourceType": "ServiceProviderConfig",
+				"location":     s.auth.Config.PublicURL + scimBasePath + "/ServiceProviderConfig",
+			},
+		})
+	}
+}
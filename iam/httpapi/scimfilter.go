@@ -0,0 +1,301 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SCIM filters (RFC 7644 section 3.4.2.2) are translated into SQL
+// conditions over the columns an attribute maps to.
+
+var errInvalidFilter = errors.New("invalid filter")
+
+type scimAttrKind int
+
+const (
+	scimString scimAttrKind = iota
+	scimBool
+	scimTime
+	scimID
+)
+
+// scimAttr maps a SCIM attribute to a column
+type scimAttr struct {
+	column string
+	kind   scimAttrKind
+	negate bool // boolean columns storing the opposite, e.g. active and disabled
+}
+
+// scimFilter is a parsed filter ready for a GORM Where call
+type scimFilter struct {
+	sql  string
+	args []interface{}
+}
+
+// parseSCIMFilter translates a filter using the attributes in attrs
+func parseSCIMFilter(filter string, attrs map[string]scimAttr) (scimFilter, error) {
+	tokens, err := lexSCIMFilter(filter)
+	if err != nil {
+		return scimFilter{}, err
+	}
+
+	p := &scimFilterParser{tokens: tokens, attrs: attrs}
+	result, err := p.parseOr()
+	if err != nil {
+		return scimFilter{}, err
+	}
+	if p.pos != len(p.tokens) {
+		return scimFilter{}, fmt.Errorf("%w: unexpected %q", errInvalidFilter, p.tokens[p.pos].text)
+	}
+	return result, nil
+}
+
+type scimToken struct {
+	text   string
+	quoted bool
+}
+
+// lexSCIMFilter splits a filter into attribute paths, operators, values
+// and parentheses
+func lexSCIMFilter(filter string) ([]scimToken, error) {
+	var tokens []scimToken
+	for i := 0; i < len(filter); {
+		switch ch := filter[i]; {
+		case ch == ' ' || ch == '\t':
+			i++
+		case ch == '(' || ch == ')':
+			tokens = append(tokens, scimToken{text: string(ch)})
+			i++
+		case ch == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, fmt.Errorf("%w: unterminated string", errInvalidFilter)
+			}
+			value, err := strconv.Unquote(filter[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad string %s", errInvalidFilter, filter[i:end+1])
+			}
+			tokens = append(tokens, scimToken{text: value, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t()\"", rune(filter[end])) {
+				if filter[end] > unicode.MaxASCII {
+					return nil, fmt.Errorf("%w: unexpected character", errInvalidFilter)
+				}
+				end++
+			}
+			tokens = append(tokens, scimToken{text: filter[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type scimFilterParser struct {
+	tokens []scimToken
+	pos    int
+	attrs  map[string]scimAttr
+}
+
+func (p *scimFilterParser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, keyword)
+}
+
+func (p *scimFilterParser) next() (scimToken, error) {
+	if p.pos >= len(p.tokens) {
+		return scimToken{}, fmt.Errorf("%w: unexpected end", errInvalidFilter)
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token, nil
+}
+
+func (p *scimFilterParser) expect(text string) error {
+	token, err := p.next()
+	if err != nil {
+		return err
+	}
+	if token.quoted || token.text != text {
+		return fmt.Errorf("%w: expected %q", errInvalidFilter, text)
+	}
+	return nil
+}
+
+// parseOr parses expressions joined with or, which binds weakest
+func (p *scimFilterParser) parseOr() (scimFilter, error) {
+	return p.parseJoined("or", p.parseAnd)
+}
+
+// parseAnd parses expressions joined with and
+func (p *scimFilterParser) parseAnd() (scimFilter, error) {
+	return p.parseJoined("and", p.parseFactor)
+}
+
+func (p *scimFilterParser) parseJoined(keyword string, parse func() (scimFilter, error)) (scimFilter, error) {
+	left, err := parse()
+	if err != nil {
+		return scimFilter{}, err
+	}
+	for p.peekKeyword(keyword) {
+		p.pos++
+		right, err := parse()
+		if err != nil {
+			return scimFilter{}, err
+		}
+		left = scimFilter{
+			sql:  "(" + left.sql + " " + strings.ToUpper(keyword) + " " + right.sql + ")",
+			args: append(left.args, right.args...),
+		}
+	}
+	return left, nil
+}
+
+// parseFactor parses a negation, a parenthesized expression or a comparison
+func (p *scimFilterParser) parseFactor() (scimFilter, error) {
+	if p.peekKeyword("not") {
+		p.pos++
+		if err := p.expect("("); err != nil {
+			return scimFilter{}, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return scimFilter{}, err
+		}
+		if err := p.expect(")"); err != nil {
+			return scimFilter{}, err
+		}
+		return scimFilter{sql: "NOT " + inner.sql, args: inner.args}, nil
+	}
+
+	if p.peekKeyword("(") {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return scimFilter{}, err
+		}
+		if err := p.expect(")"); err != nil {
+			return scimFilter{}, err
+		}
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses attr op value or attr pr
+func (p *scimFilterParser) parseComparison() (scimFilter, error) {
+	token, err := p.next()
+	if err != nil {
+		return scimFilter{}, err
+	}
+	attr, ok := p.attrs[scimAttrName(token.text)]
+	if token.quoted || !ok {
+		return scimFilter{}, fmt.Errorf("%w: unsupported attribute %q", errInvalidFilter, token.text)
+	}
+
+	opToken, err := p.next()
+	if err != nil {
+		return scimFilter{}, err
+	}
+	op := strings.ToLower(opToken.text)
+	if op == "pr" {
+		return attr.present(), nil
+	}
+
+	value, err := p.next()
+	if err != nil {
+		return scimFilter{}, err
+	}
+	return attr.compare(op, value)
+}
+
+// scimAttrName normalizes an attribute path: schema URN prefixes are
+// dropped and names compare case-insensitively
+func scimAttrName(path string) string {
+	if i := strings.LastIndex(path, ":"); i >= 0 {
+		path = path[i+1:]
+	}
+	return strings.ToLower(path)
+}
+
+// present returns the condition of the pr operator
+func (a scimAttr) present() scimFilter {
+	if a.kind == scimString {
+		return scimFilter{sql: "(" + a.column + " IS NOT NULL AND " + a.column + " <> '')"}
+	}
+	return scimFilter{sql: a.column + " IS NOT NULL"}
+}
+
+// likeEscaper escapes the LIKE wildcards in filter values. The escape
+// character is ! since a backslash is itself an escape in MySQL strings.
+var likeEscaper = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+
+// compare returns the condition of a comparison operator
+func (a scimAttr) compare(op string, value scimToken) (scimFilter, error) {
+	sqlOps := map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+
+	switch a.kind {
+	case scimString:
+		if !value.quoted {
+			return scimFilter{}, fmt.Errorf("%w: %s needs a string", errInvalidFilter, a.column)
+		}
+		column := "LOWER(" + a.column + ")"
+		text := strings.ToLower(value.text)
+		switch op {
+		case "co":
+			return scimFilter{sql: column + ` LIKE ? ESCAPE '!'`, args: []interface{}{"%" + likeEscaper.Replace(text) + "%"}}, nil
+		case "sw":
+			return scimFilter{sql: column + ` LIKE ? ESCAPE '!'`, args: []interface{}{likeEscaper.Replace(text) + "%"}}, nil
+		case "ew":
+			return scimFilter{sql: column + ` LIKE ? ESCAPE '!'`, args: []interface{}{"%" + likeEscaper.Replace(text)}}, nil
+		}
+		if sqlOp, ok := sqlOps[op]; ok {
+			return scimFilter{sql: column + " " + sqlOp + " ?", args: []interface{}{text}}, nil
+		}
+
+	case scimBool:
+		if value.quoted || (op != "eq" && op != "ne") {
+			break
+		}
+		b, err := strconv.ParseBool(strings.ToLower(value.text))
+		if err != nil {
+			return scimFilter{}, fmt.Errorf("%w: %s needs true or false", errInvalidFilter, a.column)
+		}
+		if a.negate {
+			b = !b
+		}
+		return scimFilter{sql: a.column + " " + sqlOps[op] + " ?", args: []interface{}{b}}, nil
+
+	case scimTime:
+		t, err := time.Parse(time.RFC3339, value.text)
+		if err != nil {
+			return scimFilter{}, fmt.Errorf("%w: %s needs an RFC 3339 time", errInvalidFilter, a.column)
+		}
+		if sqlOp, ok := sqlOps[op]; ok {
+			return scimFilter{sql: a.column + " " + sqlOp + " ?", args: []interface{}{t}}, nil
+		}
+
+	case scimID:
+		id, err := strconv.ParseUint(value.text, 10, 64)
+		if err != nil {
+			// IDs are numeric, so no resource has this one
+			return scimFilter{sql: "1 = 0"}, nil
+		}
+		if sqlOp, ok := sqlOps[op]; ok {
+			return scimFilter{sql: a.column + " " + sqlOp + " ?", args: []interface{}{id}}, nil
+		}
+	}
+
+	return scimFilter{}, fmt.Errorf("%w: operator %q is not supported for %s", errInvalidFilter, op, a.column)
+}
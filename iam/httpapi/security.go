@@ -0,0 +1,110 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"iam/auth"
+)
+
+// Requests authenticated by the session cookie prove they come from one
+// of our pages with a synchronizer token kept in the session. Pages put it
+// in forms, scripts send it in a header.
+const (
+	csrfSessionKey = "csrf_token"
+	csrfHeader     = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+// csrfToken returns the CSRF token of the session, starting one if needed
+func csrfToken(c *gin.Context) string {
+	session := sessions.Default(c)
+	if token, ok := session.Get(csrfSessionKey).(string); ok && token != "" {
+		return token
+	}
+
+	token, err := auth.RandomToken(32)
+	if err != nil {
+		return ""
+	}
+	session.Set(csrfSessionKey, token)
+	session.Save()
+	return token
+}
+
+// CSRF rejects state-changing requests of a cookie session without the
+// session's token. Requests authenticated by an access token or API key
+// need none, since browsers do not attach those on their own. Any other
+// Authorization header, such as Basic credentials a browser cached, does
+// not exempt a request.
+func (s *Server) CSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		session := sessions.Default(c)
+		if session.Get("user_id") == nil {
+			c.Next()
+			return
+		}
+		if c.GetHeader("Authorization") != "" && s.authenticateHeader(c) {
+			c.Next()
+			return
+		}
+
+		expected, _ := session.Get(csrfSessionKey).(string)
+		presented := c.GetHeader(csrfHeader)
+		if presented == "" {
+			presented = c.PostForm(csrfFormField)
+		}
+		if expected == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(expected)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// csrfTokenEndpoint hands the CSRF token of the session to scripts that
+// do not get it from a rendered page
+func (s *Server) csrfTokenEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"csrf_token": csrfToken(c),
+			"header":     csrfHeader,
+		})
+	}
+}
+
+// securityHeaders sets the browser protections of every response. Inline
+// scripts of the pages run with a nonce, so injected ones do not.
+func (s *Server) securityHeaders(hsts bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nonce, err := auth.RandomToken(16)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Set("csp_nonce", nonce)
+
+		header := c.Writer.Header()
+		header.Set("Content-Security-Policy", fmt.Sprintf(
+			"default-src 'self'; script-src 'self' 'nonce-%s'; style-src 'self' 'unsafe-inline'; "+
+				"img-src 'self' data:; object-src 'none'; base-uri 'self'; frame-ancestors 'none'", nonce))
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if hsts {
+			header.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+		c.Next()
+	}
+}
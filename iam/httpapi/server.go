@@ -0,0 +1,143 @@
+// Package httpapi serves the iam API, the OAuth 2.0 and SCIM endpoints and
+// the admin pages over HTTP.
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"slices"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"iam/auth"
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// Config holds the HTTP settings
+type Config struct {
+	// Templates holds templates/*.html, Static the static/ directory
+	Templates fs.FS
+	Static    fs.FS
+	// SessionSecret authenticates the session cookie, at least 32 bytes
+	SessionSecret string
+	// SecureCookies marks the session cookie Secure and turns on HSTS, for
+	// servers reached over HTTPS
+	SecureCookies bool
+	// CookieSameSite is the SameSite mode of the session cookie, Lax by
+	// default so OAuth clients can still send users to /oauth/authorize
+	CookieSameSite http.SameSite
+	// CORSOrigins may call the API from browsers with credentials. "*"
+	// allows every origin without credentials; none are allowed by default.
+	CORSOrigins []string
+	// TrustedProxies may set the client IP in forwarded headers. Client IPs
+	// drive login throttling, so no proxy is trusted by default.
+	TrustedProxies []string
+}
+
+// Server is the HTTP interface of an iam instance
+type Server struct {
+	engine   *gin.Engine
+	store    *store.Store
+	db       *gorm.DB
+	policy   *policy.Service
+	auth     *auth.Service
+	webhooks *webhook.Dispatcher
+
+	// abacGroups maps the base path of route groups authorized with the
+	// ABAC model to the owner lookup of their resources
+	abacGroups map[string]ownerFunc
+}
+
+// New creates the server and registers its routes
+func New(cfg Config, st *store.Store, pol *policy.Service, au *auth.Service, wh *webhook.Dispatcher) (*Server, error) {
+	s := &Server{
+		engine:     gin.Default(),
+		store:      st,
+		db:         st.DB,
+		policy:     pol,
+		auth:       au,
+		webhooks:   wh,
+		abacGroups: map[string]ownerFunc{},
+	}
+	r := s.engine
+
+	if len(cfg.SessionSecret) < 32 {
+		return nil, errors.New("session secret must be at least 32 bytes")
+	}
+	if cfg.CookieSameSite == 0 {
+		cfg.CookieSameSite = http.SameSiteLaxMode
+	}
+
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+
+	// Setup templates
+	templ, err := template.New("").ParseFS(cfg.Templates, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse templates: %w", err)
+	}
+	r.SetHTMLTemplate(templ)
+
+	// Setup sessions, kept server-side so they can be listed and revoked
+	sessionStore := auth.NewSessionStore(au.Sessions, au.Config.SessionTTL, []byte(cfg.SessionSecret))
+	sessionStore.Options(sessions.Options{
+		Path:     "/",
+		MaxAge:   int(au.Config.SessionTTL.Seconds()),
+		Secure:   cfg.SecureCookies,
+		HttpOnly: true,
+		SameSite: cfg.CookieSameSite,
+	})
+	r.Use(s.securityHeaders(cfg.SecureCookies))
+	r.Use(auth.WithClientIP())
+	r.Use(sessions.Sessions("auth-session", sessionStore))
+
+	// Setup CORS for the configured origins only, since responses to
+	// credentialed requests carry the user's data
+	if len(cfg.CORSOrigins) > 0 {
+		corsConfig := cors.Config{
+			AllowMethods:  []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowHeaders:  []string{"Origin", "Content-Type", "Authorization", csrfHeader},
+			ExposeHeaders: []string{"Content-Length"},
+		}
+		if slices.Contains(cfg.CORSOrigins, "*") {
+			corsConfig.AllowAllOrigins = true
+		} else {
+			corsConfig.AllowOrigins = cfg.CORSOrigins
+			corsConfig.AllowCredentials = true
+		}
+		r.Use(cors.New(corsConfig))
+	}
+
+	// Cookie-authenticated requests need the CSRF token of the session
+	r.Use(s.CSRF())
+
+	// Serve static files
+	staticFiles, err := fs.Sub(cfg.Static, "static")
+	if err != nil {
+		return nil, fmt.Errorf("static files: %w", err)
+	}
+	r.StaticFS("/static", http.FS(staticFiles))
+
+	s.routes()
+	return s, nil
+}
+
+// ServeHTTP makes the server an http.Handler, so it can be mounted in
+// another server or driven by httptest
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.engine.ServeHTTP(w, r)
+}
+
+// Run listens on addr and serves requests until it fails
+func (s *Server) Run(addr string) error {
+	return s.engine.Run(addr)
+}
@@ -0,0 +1,1496 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"iam/auth"
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// testServer is a server backed by a private in-memory SQLite database
+type testServer struct {
+	*Server
+	t *testing.T
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	// Each test gets its own shared-cache database, kept alive by the pool
+	dsn := "file:" + strings.ReplaceAll(t.Name(), "/", "_") + "?mode=memory&cache=shared"
+	st, err := store.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := st.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := st.MigrateUp(store.LatestSchemaVersion()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	pol, err := policy.New(st, policy.Config{
+		DefaultRole:           "user",
+		GrantMaxDuration:      8 * time.Hour,
+		BreakGlassMaxDuration: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("policy: %v", err)
+	}
+
+	au := auth.New(st, pol, &auth.LogMailer{Path: os.DevNull}, auth.GormSessionBackend{DB: st.DB}, auth.Config{
+		Issuer:               "iam-test",
+		SigningAlgorithm:     "EdDSA",
+		PublicURL:            "http://iam.test",
+		TOTPIssuer:           "IAM",
+		AccessTokenTTL:       15 * time.Minute,
+		RefreshTokenTTL:      time.Hour,
+		InvitationTTL:        time.Hour,
+		EmailVerificationTTL: time.Hour,
+		PasswordResetTTL:     time.Hour,
+		SessionTTL:           time.Hour,
+		Throttle: auth.LoginThrottle{
+			UserBackoffAfter: 100,
+			IPBackoffAfter:   100,
+			BaseDelay:        time.Second,
+			MaxDelay:         time.Second,
+			LockoutAfter:     100,
+			LockoutDuration:  time.Minute,
+			Window:           time.Hour,
+		},
+		HashQueueWait: time.Second,
+		BcryptCost:    bcrypt.MinCost,
+	})
+	if err := au.Keyring.Load(); err != nil {
+		t.Fatalf("load keys: %v", err)
+	}
+
+	srv, err := New(Config{
+		Templates:     os.DirFS(".."),
+		Static:        os.DirFS(".."),
+		SessionSecret: "test-secret-test-secret-test-secret",
+	}, st, pol, au, webhook.New(st, webhook.Config{}))
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	return &testServer{Server: srv, t: t}
+}
+
+// createUser stores a verified user holding role
+func (ts *testServer) createUser(username, password, role string) store.User {
+	ts.t.Helper()
+	hash, err := ts.auth.HashPassword(password)
+	if err != nil {
+		ts.t.Fatalf("hash password: %v", err)
+	}
+	user := store.User{
+		Username:      username,
+		PasswordHash:  hash,
+		Email:         username + "@example.com",
+		Role:          role,
+		EmailVerified: true,
+	}
+	if err := ts.db.Create(&user).Error; err != nil {
+		ts.t.Fatalf("create user: %v", err)
+	}
+	if err := ts.policy.AssignRole(user); err != nil {
+		ts.t.Fatalf("assign role: %v", err)
+	}
+	return user
+}
+
+// do sends a JSON request, authenticated with token unless it is empty
+func (ts *testServer) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatalf("encode body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ts.ServeHTTP(w, req)
+	return w
+}
+
+// login signs a user in and returns the access token
+func (ts *testServer) login(username, password string) string {
+	ts.t.Helper()
+	w := ts.do(http.MethodPost, "/api/auth/login", "", gin.H{"username": username, "password": password})
+	if w.Code != http.StatusOK {
+		ts.t.Fatalf("login %s: status %d: %s", username, w.Code, w.Body)
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	decode(ts.t, w, &response)
+	return response.Token
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	ts := newTestServer(t)
+
+	w := ts.do(http.MethodPost, "/api/auth/register", "", gin.H{
+		"username": "alice",
+		"password": "correct horse",
+		"email":    "alice@example.com",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: status %d: %s", w.Code, w.Body)
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	decode(t, w, &response)
+	if response.Token == "" {
+		t.Error("register returned no token")
+	}
+
+	var user store.User
+	if err := ts.db.Where("username = ?", "alice").First(&user).Error; err != nil {
+		t.Fatalf("user not stored: %v", err)
+	}
+	if user.Role != "user" || user.EmailVerified {
+		t.Errorf("got role %q verified %v, want an unverified user", user.Role, user.EmailVerified)
+	}
+	if roles := ts.policy.UserRoles(user); len(roles) != 1 || roles[0] != "user" {
+		t.Errorf("got roles %v, want [user]", roles)
+	}
+
+	tests := []struct {
+		name string
+		body gin.H
+	}{
+		{"duplicate username", gin.H{"username": "alice", "password": "pw", "email": "other@example.com"}},
+		{"duplicate email", gin.H{"username": "other", "password": "pw", "email": "alice@example.com"}},
+		{"invalid email", gin.H{"username": "bob", "password": "pw", "email": "bob"}},
+		{"missing password", gin.H{"username": "bob", "email": "bob@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := ts.do(http.MethodPost, "/api/auth/register", "", tt.body); w.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", "correct horse", "user")
+
+	token := ts.login("alice", "correct horse")
+	claims, ok := ts.auth.ParseAccessToken(token)
+	if !ok {
+		t.Fatal("login returned an invalid access token")
+	}
+	if claims.Username != "alice" || claims.Role != "user" {
+		t.Errorf("got claims for %q with role %q", claims.Username, claims.Role)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "alice", "wrong"},
+		{"unknown user", "mallory", "correct horse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ts.do(http.MethodPost, "/api/auth/login", "", gin.H{"username": tt.username, "password": tt.password})
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status %d, want 401: %s", w.Code, w.Body)
+			}
+		})
+	}
+
+	t.Run("disabled user", func(t *testing.T) {
+		user := ts.createUser("carol", "correct horse", "user")
+		ts.db.Model(&user).Update("disabled", true)
+		w := ts.do(http.MethodPost, "/api/auth/login", "", gin.H{"username": "carol", "password": "correct horse"})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status %d, want 403: %s", w.Code, w.Body)
+		}
+	})
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", "correct horse", "user")
+
+	refresh := func(token string) (*httptest.ResponseRecorder, string) {
+		t.Helper()
+		w := ts.do(http.MethodPost, "/api/auth/refresh", "", gin.H{"refresh_token": token})
+		var response struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if w.Code == http.StatusOK {
+			decode(t, w, &response)
+		}
+		return w, response.RefreshToken
+	}
+
+	w := ts.do(http.MethodPost, "/api/auth/login", "", gin.H{"username": "alice", "password": "correct horse"})
+	var login struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	decode(t, w, &login)
+	if login.RefreshToken == "" {
+		t.Fatalf("login returned no refresh token: %s", w.Body)
+	}
+
+	// Each refresh token is exchanged once for a new one
+	w, second := refresh(login.RefreshToken)
+	if w.Code != http.StatusOK || second == "" || second == login.RefreshToken {
+		t.Fatalf("rotate: status %d: %s", w.Code, w.Body)
+	}
+	w, third := refresh(second)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotate again: status %d: %s", w.Code, w.Body)
+	}
+
+	// Reusing a rotated token revokes the whole family, the latest token too
+	if w, _ := refresh(login.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("reuse: status %d, want 401", w.Code)
+	}
+	if w, _ := refresh(third); w.Code != http.StatusUnauthorized {
+		t.Errorf("latest token after reuse: status %d, want 401", w.Code)
+	}
+	var live int64
+	ts.db.Model(&store.RefreshToken{}).Where("revoked_at IS NULL").Count(&live)
+	if live != 0 {
+		t.Errorf("%d refresh tokens of the family still valid", live)
+	}
+}
+
+func TestUpdateUserRole(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("admin", "admin password", "admin")
+	alice := ts.createUser("alice", "correct horse", "user")
+	adminToken := ts.login("admin", "admin password")
+	aliceToken := ts.login("alice", "correct horse")
+	path := "/api/admin/users/" + strconv.FormatUint(uint64(alice.ID), 10) + "/role"
+
+	if w := ts.do(http.MethodPut, path, aliceToken, gin.H{"role": "admin"}); w.Code != http.StatusForbidden {
+		t.Fatalf("user changed own role: status %d: %s", w.Code, w.Body)
+	}
+
+	if w := ts.do(http.MethodPut, path, adminToken, gin.H{"role": "nonexistent"}); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown role: status %d, want 400: %s", w.Code, w.Body)
+	}
+
+	if w := ts.do(http.MethodPut, path, adminToken, gin.H{"role": "admin"}); w.Code != http.StatusOK {
+		t.Fatalf("update role: status %d: %s", w.Code, w.Body)
+	}
+	ts.db.First(&alice, alice.ID)
+	if alice.Role != "admin" {
+		t.Errorf("got role %q, want admin", alice.Role)
+	}
+	if roles := ts.policy.UserRoles(alice); len(roles) != 1 || roles[0] != "admin" {
+		t.Errorf("got roles %v, want [admin]", roles)
+	}
+
+	// Tokens issued with the old role are revoked
+	if w := ts.do(http.MethodGet, "/api/profile", aliceToken, nil); w.Code != http.StatusForbidden {
+		t.Errorf("old token still accepted: status %d", w.Code)
+	}
+	if w := ts.do(http.MethodGet, "/api/roles", ts.login("alice", "correct horse"), nil); w.Code != http.StatusOK {
+		t.Errorf("new token lacks admin access: status %d", w.Code)
+	}
+}
+
+func TestAdminCreateUser(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("admin", "admin password", "admin")
+	token := ts.login("admin", "admin password")
+
+	w := ts.do(http.MethodPost, "/api/admin/users", token, gin.H{
+		"username": "ops",
+		"password": "ops password",
+		"email":    "ops@example.com",
+		"role":     "admin",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create user: status %d: %s", w.Code, w.Body)
+	}
+	if w := ts.do(http.MethodGet, "/api/roles", ts.login("ops", "ops password"), nil); w.Code != http.StatusOK {
+		t.Errorf("created admin lacks admin access: status %d", w.Code)
+	}
+
+	w = ts.do(http.MethodPost, "/api/admin/users", token, gin.H{
+		"username": "other",
+		"password": "pw",
+		"email":    "other@example.com",
+		"role":     "nonexistent",
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown role: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminSetUserPassword(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("admin", "admin password", "admin")
+	alice := ts.createUser("alice", "correct horse", "user")
+	token := ts.login("admin", "admin password")
+	aliceToken := ts.login("alice", "correct horse")
+
+	w := ts.do(http.MethodPut, fmt.Sprintf("/api/admin/users/%d/password", alice.ID), token, gin.H{"password": "battery staple"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("set password: status %d: %s", w.Code, w.Body)
+	}
+
+	// The user is signed out and only the new password works
+	if w := ts.do(http.MethodGet, "/api/profile", aliceToken, nil); w.Code == http.StatusOK {
+		t.Error("old token still accepted")
+	}
+	if w := ts.do(http.MethodPost, "/api/auth/login", "", gin.H{"username": "alice", "password": "correct horse"}); w.Code == http.StatusOK {
+		t.Error("old password still accepted")
+	}
+	ts.login("alice", "battery staple")
+}
+
+func TestPolicyCRUD(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("admin", "admin password", "admin")
+	token := ts.login("admin", "admin password")
+	rule := []string{"user", "/api/reports", "GET"}
+
+	hasRule := func() bool {
+		w := ts.do(http.MethodGet, "/api/policies", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("list policies: status %d: %s", w.Code, w.Body)
+		}
+		var response struct {
+			Policies [][]string `json:"policies"`
+		}
+		decode(t, w, &response)
+		for _, p := range response.Policies {
+			if strings.Join(p, ",") == "user,*,/api/reports,GET" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if hasRule() {
+		t.Fatal("policy exists before it was added")
+	}
+
+	if w := ts.do(http.MethodPost, "/api/policies", token, rule); w.Code != http.StatusCreated {
+		t.Fatalf("add policy: status %d: %s", w.Code, w.Body)
+	}
+	if !hasRule() {
+		t.Error("added policy is not listed")
+	}
+	if w := ts.do(http.MethodPost, "/api/policies", token, rule); w.Code != http.StatusBadRequest {
+		t.Errorf("duplicate policy: status %d, want 400", w.Code)
+	}
+
+	w := ts.do(http.MethodGet, "/api/policies/role/user", token, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/api/reports") {
+		t.Errorf("role policies: status %d: %s", w.Code, w.Body)
+	}
+
+	if w := ts.do(http.MethodDelete, "/api/policies", token, rule); w.Code != http.StatusOK {
+		t.Fatalf("remove policy: status %d: %s", w.Code, w.Body)
+	}
+	if hasRule() {
+		t.Error("removed policy is still listed")
+	}
+	if w := ts.do(http.MethodDelete, "/api/policies", token, rule); w.Code != http.StatusNotFound {
+		t.Errorf("removing a missing policy: status %d, want 404", w.Code)
+	}
+
+	if w := ts.do(http.MethodPost, "/api/policies", token, []string{"user", "/api/reports"}); w.Code != http.StatusBadRequest {
+		t.Errorf("incomplete policy: status %d, want 400", w.Code)
+	}
+}
+
+func TestPolicySync(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("admin", "admin password", "admin")
+	alice := ts.createUser("alice", "correct horse", "user")
+	token := ts.login("admin", "admin password")
+	for _, role := range []string{"auditor", "support"} {
+		ts.db.Create(&store.Role{Name: role})
+		if err := ts.policy.InheritRole(role, "user"); err != nil {
+			t.Fatalf("inherit role: %v", err)
+		}
+	}
+
+	importCSV := func(data string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/policies/import?format=csv", strings.NewReader(data))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, req)
+		return w
+	}
+
+	// Only role inheritance is exported, not who holds which role
+	w := ts.do(http.MethodGet, "/api/admin/policies/export?format=csv", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: status %d: %s", w.Code, w.Body)
+	}
+	exported := w.Body.String()
+	if strings.Contains(exported, policy.UserSubjectPrefix) {
+		t.Errorf("export contains user groupings:\n%s", exported)
+	}
+	if !strings.Contains(exported, "g,auditor,user,*") {
+		t.Errorf("export lacks the role inheritance:\n%s", exported)
+	}
+
+	// Importing a file without users leaves their roles alone
+	w = importCSV(strings.Replace(exported, "g,auditor,user,*\n", "", 1))
+	if w.Code != http.StatusOK {
+		t.Fatalf("import: status %d: %s", w.Code, w.Body)
+	}
+	var response struct {
+		Diff policy.PolicyDiff `json:"diff"`
+	}
+	decode(t, w, &response)
+	if len(response.Diff.RemoveGroupings) != 1 || len(response.Diff.AddGroupings) != 0 || len(response.Diff.RemovePolicies) != 0 {
+		t.Errorf("diff %+v, want only the inheritance removed", response.Diff)
+	}
+	if roles := ts.policy.UserRoles(alice); !slices.Contains(roles, "user") {
+		t.Errorf("import removed the roles of alice: %v", roles)
+	}
+	if w := ts.do(http.MethodGet, "/api/profile", ts.login("alice", "correct horse"), nil); w.Code != http.StatusOK {
+		t.Error("import removed the permissions of alice")
+	}
+
+	// Files cannot assign roles to users
+	w = importCSV(exported + "g," + policy.UserSubject(alice) + ",admin,*\n")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("import of a user grouping: status %d, want 400", w.Code)
+	}
+	if roles := ts.policy.UserRoles(alice); slices.Contains(roles, "admin") {
+		t.Error("import assigned admin to alice")
+	}
+}
+
+func TestPolicySeeds(t *testing.T) {
+	ts := newTestServer(t)
+	rule := []string{"user", policy.GlobalDomain, "/api/keys", "*"}
+	if removed, err := ts.policy.Enforcer.RemovePolicy(rule); err != nil || !removed {
+		t.Fatalf("remove seeded policy: %v %v", removed, err)
+	}
+
+	// A restart does not bring back what an admin removed
+	pol, err := policy.New(ts.store, policy.Config{DefaultRole: "user"})
+	if err != nil {
+		t.Fatalf("reload policy: %v", err)
+	}
+	if has, _ := pol.Enforcer.HasPolicy(rule); has {
+		t.Error("restart restored a removed seed policy")
+	}
+	if has, _ := pol.Enforcer.HasPolicy("guest", policy.GlobalDomain, "/api/auth/login", "POST"); !has {
+		t.Error("seed policy missing after restart")
+	}
+	var seeds int64
+	ts.db.Model(&store.PolicySeed{}).Count(&seeds)
+	if seeds != 2 {
+		t.Errorf("%d seeds recorded, want 2", seeds)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("admin", "admin password", "admin")
+	ts.createUser("alice", "correct horse", "user")
+	adminToken := ts.login("admin", "admin password")
+	aliceToken := ts.login("alice", "correct horse")
+
+	// An unverified user acts with the unverified role only
+	unverified := ts.createUser("bob", "correct horse", "user")
+	ts.db.Model(&unverified).Update("email_verified", false)
+	bobToken := ts.login("bob", "correct horse")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"guest lists roles", http.MethodGet, "/api/roles", "", http.StatusForbidden},
+		{"invalid token", http.MethodGet, "/api/roles", "not-a-token", http.StatusForbidden},
+		{"user lists roles", http.MethodGet, "/api/roles", aliceToken, http.StatusForbidden},
+		{"admin lists roles", http.MethodGet, "/api/roles", adminToken, http.StatusOK},
+		{"user reads profile", http.MethodGet, "/api/profile", aliceToken, http.StatusOK},
+		{"guest reads profile", http.MethodGet, "/api/profile", "", http.StatusForbidden},
+		{"unverified user reads profile", http.MethodGet, "/api/profile", bobToken, http.StatusOK},
+		{"unverified user lists users", http.MethodGet, "/api/users", bobToken, http.StatusForbidden},
+		{"user reads admin API", http.MethodGet, "/api/admin/users", aliceToken, http.StatusForbidden},
+		{"admin reads admin API", http.MethodGet, "/api/admin/users", adminToken, http.StatusOK},
+		{"user lists users via ABAC", http.MethodGet, "/api/users", aliceToken, http.StatusOK},
+		{"user edits own account via ABAC", http.MethodPut, "/api/users/alice", aliceToken, http.StatusOK},
+		{"user edits other account via ABAC", http.MethodPut, "/api/users/admin", aliceToken, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body interface{}
+			if tt.method == http.MethodPut {
+				body = gin.H{"email": "alice@example.com"}
+			}
+			if w := ts.do(tt.method, tt.path, tt.token, body); w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// Policies added at runtime take effect on the next request
+	if w := ts.do(http.MethodPost, "/api/policies", adminToken, []string{"user", "/api/roles", "GET"}); w.Code != http.StatusCreated {
+		t.Fatalf("add policy: status %d: %s", w.Code, w.Body)
+	}
+	if w := ts.do(http.MethodGet, "/api/roles", aliceToken, nil); w.Code != http.StatusOK {
+		t.Errorf("user lists roles after grant: status %d", w.Code)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	ts := newTestServer(t)
+	blocklist, err := auth.LoadPasswordBlocklist("")
+	if err != nil {
+		t.Fatalf("load blocklist: %v", err)
+	}
+	ts.auth.Config.Password = auth.PasswordPolicy{MinLength: 10, MinClasses: 2, Blocklist: blocklist, History: 2}
+
+	tests := []struct {
+		name     string
+		password string
+	}{
+		{"too short", "Short1"},
+		{"one class", "lowercaseonly"},
+		{"common", "Password123"},
+		{"username", "Alice12345"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ts.do(http.MethodPost, "/api/auth/register", "", gin.H{
+				"username": "alice12345",
+				"password": tt.password,
+				"email":    "alice@example.com",
+			})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+		})
+	}
+
+	ts.createUser("alice", "first password 1", "user")
+	token := ts.login("alice", "first password 1")
+	change := func(password string) int {
+		return ts.do(http.MethodPut, "/api/profile", token, gin.H{"password": password}).Code
+	}
+
+	if code := change("second password 2"); code != http.StatusOK {
+		t.Fatalf("change password: status %d", code)
+	}
+	// The current and previous passwords are remembered, older ones are not
+	if code := change("second password 2"); code != http.StatusBadRequest {
+		t.Errorf("reusing the current password: status %d", code)
+	}
+	if code := change("first password 1"); code != http.StatusBadRequest {
+		t.Errorf("reusing the previous password: status %d", code)
+	}
+	if code := change("third password 3"); code != http.StatusOK {
+		t.Fatalf("change password: status %d", code)
+	}
+	if code := change("first password 1"); code != http.StatusOK {
+		t.Errorf("password older than the history: status %d", code)
+	}
+}
+
+func TestPasswordHashUpgrade(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.createUser("alice", "correct horse", "user")
+
+	ts.auth.Config.HashAlgorithm = auth.HashArgon2id
+	ts.auth.Config.Argon2 = auth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+	ts.login("alice", "correct horse")
+
+	if err := ts.db.First(&user, user.ID).Error; err != nil {
+		t.Fatalf("reload user: %v", err)
+	}
+	if !strings.HasPrefix(user.PasswordHash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("hash not upgraded: %s", user.PasswordHash)
+	}
+	ts.login("alice", "correct horse")
+
+	// A cost change upgrades Argon2id hashes as well
+	ts.auth.Config.Argon2.Iterations = 2
+	ts.login("alice", "correct horse")
+	ts.db.First(&user, user.ID)
+	if !strings.Contains(user.PasswordHash, "t=2") {
+		t.Errorf("hash not upgraded to the new cost: %s", user.PasswordHash)
+	}
+}
+
+// totp computes the code of a base32 secret at a time as RFC 6238 defines it
+func totp(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:])&0x7fffffff)%1000000)
+}
+
+func TestTOTP(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", "correct horse", "user")
+	now := time.Unix(1700000000, 0)
+	ts.auth.Now = func() time.Time { return now }
+
+	// Enrolment
+	token := ts.login("alice", "correct horse")
+	w := ts.do(http.MethodPost, "/api/profile/mfa/totp", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("enroll: status %d: %s", w.Code, w.Body)
+	}
+	var enrollment struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+	decode(t, w, &enrollment)
+	if !strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/IAM:alice?") ||
+		!strings.Contains(enrollment.ProvisioningURI, "secret="+enrollment.Secret) {
+		t.Errorf("provisioning URI %q", enrollment.ProvisioningURI)
+	}
+
+	code := totp(t, enrollment.Secret, now)
+	wrong := fmt.Sprintf("%06d", (mustAtoi(t, code)+1)%1000000)
+	if w := ts.do(http.MethodPost, "/api/profile/mfa/totp/verify", token, gin.H{"code": wrong}); w.Code != http.StatusUnauthorized {
+		t.Errorf("verify wrong code: status %d, want 401", w.Code)
+	}
+	w = ts.do(http.MethodPost, "/api/profile/mfa/totp/verify", token, gin.H{"code": code})
+	if w.Code != http.StatusOK {
+		t.Fatalf("verify: status %d: %s", w.Code, w.Body)
+	}
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decode(t, w, &enabled)
+	if len(enabled.RecoveryCodes) != 10 {
+		t.Fatalf("got %d recovery codes, want 10", len(enabled.RecoveryCodes))
+	}
+
+	// Logins now need a second factor
+	challenge := func() string {
+		t.Helper()
+		w := ts.do(http.MethodPost, "/api/auth/login", "", gin.H{"username": "alice", "password": "correct horse"})
+		var response struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+			Token       string `json:"token"`
+		}
+		decode(t, w, &response)
+		if !response.MFARequired || response.MFAToken == "" || response.Token != "" {
+			t.Fatalf("login: status %d: %s, want an MFA challenge", w.Code, w.Body)
+		}
+		return response.MFAToken
+	}
+	verify := func(mfaToken string, body gin.H) int {
+		t.Helper()
+		body["mfa_token"] = mfaToken
+		return ts.do(http.MethodPost, "/api/auth/mfa", "", body).Code
+	}
+
+	mfaToken := challenge()
+	if status := verify(mfaToken, gin.H{"code": code}); status != http.StatusUnauthorized {
+		t.Errorf("replayed code of the enrolment step: status %d, want 401", status)
+	}
+	if status := verify(mfaToken, gin.H{"code": totp(t, enrollment.Secret, now.Add(-30*time.Second))}); status != http.StatusUnauthorized {
+		t.Errorf("code of a step before the last used one: status %d, want 401", status)
+	}
+	if status := verify(mfaToken, gin.H{"code": totp(t, enrollment.Secret, now.Add(2*time.Minute))}); status != http.StatusUnauthorized {
+		t.Errorf("code outside the allowed skew: status %d, want 401", status)
+	}
+	now = now.Add(30 * time.Second)
+	next := totp(t, enrollment.Secret, now)
+	if status := verify(mfaToken, gin.H{"code": next}); status != http.StatusOK {
+		t.Errorf("code of the next step: status %d, want 200", status)
+	}
+	if status := verify(challenge(), gin.H{"code": next}); status != http.StatusUnauthorized {
+		t.Errorf("replayed code: status %d, want 401", status)
+	}
+
+	// Code from one step ahead is accepted for clock skew, then its step is used
+	ahead := totp(t, enrollment.Secret, now.Add(30*time.Second))
+	if status := verify(challenge(), gin.H{"code": ahead}); status != http.StatusOK {
+		t.Errorf("code one step ahead: status %d, want 200", status)
+	}
+	now = now.Add(30 * time.Second)
+	if status := verify(challenge(), gin.H{"code": ahead}); status != http.StatusUnauthorized {
+		t.Errorf("code of an already used step: status %d, want 401", status)
+	}
+
+	// Recovery codes are single use and may be typed loosely
+	recovery := enabled.RecoveryCodes[0]
+	if status := verify(challenge(), gin.H{"recovery_code": " " + strings.ToUpper(recovery) + " "}); status != http.StatusOK {
+		t.Errorf("recovery code: status %d, want 200", status)
+	}
+	if status := verify(challenge(), gin.H{"recovery_code": recovery}); status != http.StatusUnauthorized {
+		t.Errorf("reused recovery code: status %d, want 401", status)
+	}
+	if status := verify(challenge(), gin.H{"recovery_code": "abcd-efgh"}); status != http.StatusUnauthorized {
+		t.Errorf("unknown recovery code: status %d, want 401", status)
+	}
+
+	// Regenerating replaces the old codes
+	now = now.Add(30 * time.Second)
+	w = ts.do(http.MethodPost, "/api/profile/mfa/recovery-codes", token, gin.H{"code": totp(t, enrollment.Secret, now)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("regenerate recovery codes: status %d: %s", w.Code, w.Body)
+	}
+	var regenerated struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decode(t, w, &regenerated)
+	if status := verify(challenge(), gin.H{"recovery_code": enabled.RecoveryCodes[1]}); status != http.StatusUnauthorized {
+		t.Errorf("replaced recovery code: status %d, want 401", status)
+	}
+	if status := verify(challenge(), gin.H{"recovery_code": regenerated.RecoveryCodes[1]}); status != http.StatusOK {
+		t.Errorf("new recovery code: status %d, want 200", status)
+	}
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	t.Helper()
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatalf("parse %q: %v", s, err)
+	}
+	return n
+}
+
+func TestAuthorizationCodeGrant(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser("alice", "correct horse", "user")
+	client := store.OAuthClient{
+		ClientID:     "app",
+		Name:         "App",
+		RedirectURIs: "https://app.example.com/callback",
+		GrantTypes:   "authorization_code refresh_token",
+		Scopes:       "openid profile",
+		Public:       true,
+	}
+	if err := ts.db.Create(&client).Error; err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	// issue stores a code as the consent step does
+	issue := func(code string) {
+		t.Helper()
+		if err := ts.db.Create(&store.AuthorizationCode{
+			CodeHash:            auth.HashToken(code),
+			ClientID:            client.ClientID,
+			UserID:              alice.ID,
+			RedirectURI:         "https://app.example.com/callback",
+			Scope:               "openid",
+			CodeChallenge:       challenge,
+			CodeChallengeMethod: "S256",
+			AuthTime:            time.Now(),
+			ExpiresAt:           time.Now().Add(auth.AuthorizationCodeTTL),
+		}).Error; err != nil {
+			t.Fatalf("create code: %v", err)
+		}
+	}
+	redeem := func(code string, override url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {client.ClientID},
+			"code":          {code},
+			"redirect_uri":  {"https://app.example.com/callback"},
+			"code_verifier": {verifier},
+		}
+		for key, values := range override {
+			form[key] = values
+		}
+		req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, req)
+		return w
+	}
+
+	issue("first")
+	tests := []struct {
+		name     string
+		override url.Values
+	}{
+		{"PKCE verifier mismatch", url.Values{"code_verifier": {strings.Repeat("x", 43)}}},
+		{"missing PKCE verifier", url.Values{"code_verifier": nil}},
+		{"redirect URI mismatch", url.Values{"redirect_uri": {"https://evil.example.com/callback"}}},
+		{"unknown code", url.Values{"code": {"unknown"}}},
+	}
+	for _, tt := range tests {
+		if w := redeem("first", tt.override); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+			t.Errorf("%s: status %d: %s, want invalid_grant", tt.name, w.Code, w.Body)
+		}
+	}
+
+	w := redeem("first", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("redeem: status %d: %s", w.Code, w.Body)
+	}
+	var tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		IDToken      string `json:"id_token"`
+	}
+	decode(t, w, &tokens)
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.IDToken == "" {
+		t.Errorf("incomplete token response: %s", w.Body)
+	}
+
+	// A code is redeemed once
+	if w := redeem("first", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("reused code: status %d, want 400", w.Code)
+	}
+	var used store.AuthorizationCode
+	ts.db.Where("code_hash = ?", auth.HashToken("first")).First(&used)
+	if used.UsedAt == nil {
+		t.Error("redeemed code has no used_at")
+	}
+
+	// Codes of revoked or disabled users are worthless
+	issue("revoked")
+	if err := ts.auth.RevokeUserTokens(alice.ID); err != nil {
+		t.Fatalf("revoke tokens: %v", err)
+	}
+	if w := redeem("revoked", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("code issued before revocation: status %d, want 400", w.Code)
+	}
+	issue("disabled")
+	ts.db.Model(&alice).Update("disabled", true)
+	if w := redeem("disabled", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("code of a disabled user: status %d, want 400", w.Code)
+	}
+}
+
+func TestSCIMProtectedUsers(t *testing.T) {
+	ts := newTestServer(t)
+	root := ts.createUser("root", "correct horse", "admin")
+	carol := ts.createUser("carol", "correct horse", "user")
+	if err := ts.policy.SetUserRoles(&carol, []string{"user", "admin"}); err != nil {
+		t.Fatalf("set roles: %v", err)
+	}
+	alice := ts.createUser("alice", "correct horse", "user")
+	ts.createUser("idp", "correct horse", policy.SCIMProvisionerRole)
+	token := ts.login("idp", "correct horse")
+
+	rename := func(name string) gin.H {
+		return gin.H{
+			"schemas":    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+			"Operations": []gin.H{{"op": "replace", "path": "userName", "value": name}},
+		}
+	}
+	for _, user := range []store.User{root, carol} {
+		path := "/scim/v2/Users/" + strconv.FormatUint(uint64(user.ID), 10)
+		if w := ts.do(http.MethodPatch, path, token, rename("mallory")); w.Code != http.StatusForbidden {
+			t.Errorf("patch %s: status %d, want 403", user.Username, w.Code)
+		}
+		if w := ts.do(http.MethodPut, path, token, gin.H{"userName": "mallory", "emails": []gin.H{{"value": "idp@example.com"}}}); w.Code != http.StatusForbidden {
+			t.Errorf("replace %s: status %d, want 403", user.Username, w.Code)
+		}
+		if w := ts.do(http.MethodDelete, path, token, nil); w.Code != http.StatusForbidden {
+			t.Errorf("delete %s: status %d, want 403", user.Username, w.Code)
+		}
+	}
+
+	// Renamed or re-addressed users sign in again
+	path := "/scim/v2/Users/" + strconv.FormatUint(uint64(alice.ID), 10)
+	aliceToken := ts.login("alice", "correct horse")
+	for _, name := range []string{"alice2", "alice"} {
+		if w := ts.do(http.MethodPatch, path, token, rename(name)); w.Code != http.StatusOK {
+			t.Fatalf("rename alice: status %d: %s", w.Code, w.Body)
+		}
+	}
+	if w := ts.do(http.MethodGet, "/api/profile", aliceToken, nil); w.Code == http.StatusOK {
+		t.Error("token still valid after rename")
+	}
+
+	aliceToken = ts.login("alice", "correct horse")
+	email := gin.H{
+		"schemas":    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []gin.H{{"op": "replace", "path": "emails", "value": []gin.H{{"value": "alice@corp.example"}}}},
+	}
+	if w := ts.do(http.MethodPatch, path, token, email); w.Code != http.StatusOK {
+		t.Fatalf("change email: status %d: %s", w.Code, w.Body)
+	}
+	if w := ts.do(http.MethodGet, "/api/profile", aliceToken, nil); w.Code == http.StatusOK {
+		t.Error("token still valid after email change")
+	}
+
+	// Groups inheriting a protected role are not managed through SCIM
+	groupPath := func(name string) string {
+		t.Helper()
+		role := store.Role{Name: name}
+		if err := ts.db.Create(&role).Error; err != nil {
+			t.Fatalf("create role: %v", err)
+		}
+		return "/scim/v2/Groups/" + strconv.FormatUint(uint64(role.ID), 10)
+	}
+	members := func(users ...store.User) []gin.H {
+		var list []gin.H
+		for _, user := range users {
+			list = append(list, gin.H{"value": strconv.FormatUint(uint64(user.ID), 10)})
+		}
+		return list
+	}
+	addMembers := func(users ...store.User) gin.H {
+		return gin.H{
+			"schemas":    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+			"Operations": []gin.H{{"op": "add", "path": "members", "value": members(users...)}},
+		}
+	}
+	ops := groupPath("ops")
+	if err := ts.policy.InheritRole("ops", "admin"); err != nil {
+		t.Fatalf("inherit role: %v", err)
+	}
+	if w := ts.do(http.MethodPatch, ops, token, addMembers(alice)); w.Code != http.StatusBadRequest {
+		t.Errorf("patch group inheriting admin: status %d, want 400", w.Code)
+	}
+	if w := ts.do(http.MethodPut, ops, token, gin.H{"displayName": "ops", "members": members(alice)}); w.Code != http.StatusBadRequest {
+		t.Errorf("replace group inheriting admin: status %d, want 400", w.Code)
+	}
+	if roles := ts.policy.UserRoles(alice); slices.Contains(roles, "ops") {
+		t.Error("alice was added to a group inheriting admin")
+	}
+
+	// Nor are memberships of protected users, and a refusal changes nothing
+	engineering := groupPath("engineering")
+	if w := ts.do(http.MethodPatch, engineering, token, addMembers(root)); w.Code != http.StatusForbidden {
+		t.Errorf("add admin to group: status %d, want 403", w.Code)
+	}
+	if w := ts.do(http.MethodPut, engineering, token, gin.H{"displayName": "engineering", "members": members(alice, carol)}); w.Code != http.StatusForbidden {
+		t.Errorf("replace members with an admin: status %d, want 403", w.Code)
+	}
+	if roles := ts.policy.UserRoles(alice); slices.Contains(roles, "engineering") {
+		t.Error("refused replace added alice")
+	}
+	if w := ts.do(http.MethodPatch, engineering, token, addMembers(alice)); w.Code != http.StatusOK {
+		t.Errorf("add alice to group: status %d: %s", w.Code, w.Body)
+	}
+	if err := ts.policy.SetUserRoles(&carol, []string{"user", "admin", "engineering"}); err != nil {
+		t.Fatalf("set roles: %v", err)
+	}
+	if w := ts.do(http.MethodPut, engineering, token, gin.H{"displayName": "engineering", "members": members(alice)}); w.Code != http.StatusForbidden {
+		t.Errorf("remove admin from group: status %d, want 403", w.Code)
+	}
+	if roles := ts.policy.UserRoles(carol); !slices.Contains(roles, "engineering") {
+		t.Error("refused replace removed carol")
+	}
+}
+
+func TestBreakGlass(t *testing.T) {
+	ts := newTestServer(t)
+	oncall := ts.createUser("olivia", "correct horse", "user")
+	if err := ts.policy.SetUserRoles(&oncall, []string{"user", policy.OnCallRole}); err != nil {
+		t.Fatalf("set roles: %v", err)
+	}
+	ts.createUser("bob", "correct horse", "user")
+	if err := ts.db.Create(&store.Role{Name: "support"}).Error; err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	ts.policy.Config.BreakGlassRoles = []string{"support"}
+
+	breakGlass := func(token, role string) int {
+		t.Helper()
+		return ts.do(http.MethodPost, "/api/grants/break-glass", token, gin.H{
+			"role": role, "reason": "incident", "duration": "30m",
+		}).Code
+	}
+
+	token := ts.login("olivia", "correct horse")
+	if code := breakGlass(token, "admin"); code != http.StatusForbidden {
+		t.Errorf("break-glass into admin: status %d, want 403", code)
+	}
+	if code := breakGlass(token, "support"); code != http.StatusCreated {
+		t.Errorf("break-glass into support: status %d, want 201", code)
+	}
+	if !slices.Contains(ts.policy.UserRoles(oncall), "support") {
+		t.Errorf("roles after break-glass: got %v", ts.policy.UserRoles(oncall))
+	}
+	if code := breakGlass(ts.login("bob", "correct horse"), "support"); code != http.StatusForbidden {
+		t.Errorf("break-glass without on-call: status %d, want 403", code)
+	}
+}
+
+func TestABACOwner(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", "correct horse", "user")
+
+	// A client credentials token acts without a user
+	client := store.OAuthClient{ClientID: "svc", Name: "svc", Role: "user", Scopes: "users"}
+	if err := ts.db.Create(&client).Error; err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	if _, err := ts.policy.Enforcer.AddPolicy(policy.ScopeSubjectPrefix+"users", policy.GlobalDomain, "/api/users/:id", "*"); err != nil {
+		t.Fatalf("add scope policy: %v", err)
+	}
+	clientToken, err := ts.auth.GenerateClientJWT(nil, client, "users")
+	if err != nil {
+		t.Fatalf("client token: %v", err)
+	}
+
+	body := gin.H{"email": "new@example.com"}
+	tests := []struct {
+		name, token, path string
+		want              int
+	}{
+		{"unknown owner", clientToken, "/api/users/ghost", http.StatusForbidden},
+		{"client without user", clientToken, "/api/users/alice", http.StatusForbidden},
+		{"unknown owner as user", ts.login("alice", "correct horse"), "/api/users/ghost", http.StatusForbidden},
+		{"owner", ts.login("alice", "correct horse"), "/api/users/alice", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := ts.do(http.MethodPut, tt.path, tt.token, body); w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser("admin", "admin password", "admin")
+	ts.createUser("ops", "ops password", "admin")
+	alice := ts.createUser("alice", "correct horse", "user")
+	adminToken := ts.login("admin", "admin password")
+	impersonate := func(user store.User, token string) *httptest.ResponseRecorder {
+		return ts.do(http.MethodPost, fmt.Sprintf("/api/admin/users/%d/impersonate", user.ID), token, gin.H{"reason": "ticket 42"})
+	}
+
+	if w := impersonate(alice, ts.login("alice", "correct horse")); w.Code != http.StatusForbidden {
+		t.Errorf("user impersonated: status %d", w.Code)
+	}
+	if w := impersonate(admin, adminToken); w.Code != http.StatusForbidden {
+		t.Errorf("admin impersonated themselves: status %d", w.Code)
+	}
+	var ops store.User
+	ts.db.Where("username = ?", "ops").First(&ops)
+	if w := impersonate(ops, adminToken); w.Code != http.StatusForbidden {
+		t.Errorf("admin impersonated another admin: status %d", w.Code)
+	}
+
+	w := impersonate(alice, adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("impersonate: status %d: %s", w.Code, w.Body)
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	decode(t, w, &response)
+	claims, ok := ts.auth.ParseAccessToken(response.Token)
+	if !ok || claims.Username != "alice" || claims.Act == nil || claims.Act.Subject != "admin" {
+		t.Fatalf("got claims %+v, want alice acted on by admin", claims)
+	}
+
+	// The token sees what alice sees, and nothing more
+	if w := ts.do(http.MethodGet, "/api/profile", response.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("profile: status %d", w.Code)
+	}
+	if w := ts.do(http.MethodGet, "/api/roles", response.Token, nil); w.Code != http.StatusForbidden {
+		t.Errorf("impersonation lends admin rights: status %d", w.Code)
+	}
+	if w := ts.do(http.MethodPut, "/api/profile", response.Token, gin.H{"password": "battery staple"}); w.Code != http.StatusForbidden {
+		t.Errorf("impersonation changed password: status %d", w.Code)
+	}
+	if w := ts.do(http.MethodPost, "/api/profile/mfa/totp", response.Token, nil); w.Code != http.StatusForbidden {
+		t.Errorf("impersonation enrolled MFA: status %d", w.Code)
+	}
+
+	// Events record who acted on the user's behalf
+	if w := ts.do(http.MethodPost, "/api/auth/impersonation/end", response.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("end impersonation: status %d: %s", w.Code, w.Body)
+	}
+	events, _, err := ts.store.QueryAuditEvents(store.AuditFilter{Impersonator: "admin", Page: 1, PageSize: 10})
+	if err != nil || len(events) != 1 || events[0].Action != "user.impersonate.end" || events[0].Actor != "alice" {
+		t.Errorf("got events %+v, %v, want the end of the impersonation", events, err)
+	}
+	if w := ts.do(http.MethodGet, "/api/profile", response.Token, nil); w.Code != http.StatusForbidden {
+		t.Errorf("token still accepted after ending: status %d", w.Code)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("admin", "admin password", "admin")
+	token := ts.login("admin", "admin password")
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 10)
+	var failing atomic.Bool
+	failing.Store(true)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{r.Header, body}
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	if w := ts.do(http.MethodPost, "/api/admin/webhooks", token, gin.H{"url": receiver.URL, "events": []string{"user.deleted"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown event: status %d, want 400", w.Code)
+	}
+	w := ts.do(http.MethodPost, "/api/admin/webhooks", token, gin.H{"url": receiver.URL, "events": []string{webhook.EventUserRegistered}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create webhook: status %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		Webhook store.Webhook `json:"webhook"`
+		Secret  string        `json:"secret"`
+	}
+	decode(t, w, &created)
+
+	// Only subscribed events are queued
+	ts.do(http.MethodPost, "/api/policies", token, []string{"user", "/api/webhook-test", "GET"})
+	ts.do(http.MethodPost, "/api/auth/register", "", gin.H{"username": "alice", "password": "correct horse", "email": "alice@example.com"})
+	if n := ts.webhooks.DeliverDue(time.Now()); n != 1 {
+		t.Fatalf("attempted %d deliveries, want 1", n)
+	}
+
+	got := <-requests
+	timestamp, _, _ := strings.Cut(strings.TrimPrefix(got.header.Get(webhook.SignatureHeader), "t="), ",")
+	sentAt, _ := strconv.ParseInt(timestamp, 10, 64)
+	if got.header.Get(webhook.SignatureHeader) != webhook.Sign(created.Secret, sentAt, got.body) {
+		t.Errorf("bad signature %q", got.header.Get(webhook.SignatureHeader))
+	}
+	var payload webhook.Payload
+	if err := json.Unmarshal(got.body, &payload); err != nil || payload.Type != webhook.EventUserRegistered {
+		t.Errorf("got payload %s, want a user.registered event", got.body)
+	}
+
+	// Failures are retried with backoff and logged
+	if n := ts.webhooks.DeliverDue(time.Now()); n != 0 {
+		t.Errorf("retried %d deliveries before the backoff", n)
+	}
+	failing.Store(false)
+	if n := ts.webhooks.DeliverDue(time.Now().Add(time.Minute)); n != 1 {
+		t.Fatalf("retried %d deliveries, want 1", n)
+	}
+	retried := <-requests
+	if retried.header.Get(webhook.EventIDHeader) != got.header.Get(webhook.EventIDHeader) {
+		t.Error("retry has a different event ID")
+	}
+
+	w = ts.do(http.MethodGet, fmt.Sprintf("/api/admin/webhooks/%d/deliveries", created.Webhook.ID), token, nil)
+	var history struct {
+		Deliveries []store.WebhookDelivery `json:"deliveries"`
+	}
+	decode(t, w, &history)
+	if len(history.Deliveries) != 1 || history.Deliveries[0].Status != webhook.StatusSucceeded || history.Deliveries[0].Attempts != 2 {
+		t.Errorf("got deliveries %+v, want one succeeded after 2 attempts", history.Deliveries)
+	}
+}
+
+func TestUserDirectory(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("admin", "admin password", "admin")
+	token := ts.login("admin", "admin password")
+	users := map[string]store.User{}
+	for _, name := range []string{"dave", "carol", "bob", "alice", "erin"} {
+		users[name] = ts.createUser(name, "correct horse", "user")
+	}
+	ts.db.Model(&store.User{}).Where("username = ?", "erin").Update("email", "erin@corp.example")
+
+	type page struct {
+		Users []struct {
+			ID       uint   `json:"id"`
+			Username string `json:"username"`
+			Status   string `json:"status"`
+		} `json:"users"`
+		NextCursor string `json:"next_cursor"`
+	}
+	list := func(query string) page {
+		t.Helper()
+		w := ts.do(http.MethodGet, "/api/admin/users?"+query, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("list %s: status %d: %s", query, w.Code, w.Body)
+		}
+		var p page
+		decode(t, w, &p)
+		return p
+	}
+	names := func(p page) string {
+		var names []string
+		for _, u := range p.Users {
+			names = append(names, u.Username)
+		}
+		return strings.Join(names, ",")
+	}
+
+	// Pages follow each other without gaps or repeats
+	var all []string
+	cursor := ""
+	for {
+		p := list("sort=-username&limit=2&cursor=" + cursor)
+		all = append(all, names(p))
+		if cursor = p.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if got := strings.Join(all, ","); got != "erin,dave,carol,bob,alice,admin" {
+		t.Errorf("got %s across pages", got)
+	}
+
+	if got := names(list("role=admin")); got != "admin" {
+		t.Errorf("role filter: got %s", got)
+	}
+
+	// Roles count however they are held: assigned, inherited or granted
+	for _, role := range []string{"auditor", "senior-auditor"} {
+		if err := ts.db.Create(&store.Role{Name: role}).Error; err != nil {
+			t.Fatalf("create role: %v", err)
+		}
+	}
+	if err := ts.policy.InheritRole("senior-auditor", "auditor"); err != nil {
+		t.Fatalf("inherit role: %v", err)
+	}
+	carol, dave := users["carol"], users["dave"]
+	if err := ts.policy.SetUserRoles(&carol, []string{"user", "auditor"}); err != nil {
+		t.Fatalf("set roles: %v", err)
+	}
+	if err := ts.policy.SetUserRoles(&dave, []string{"user", "senior-auditor"}); err != nil {
+		t.Fatalf("set roles: %v", err)
+	}
+	grant, err := ts.policy.NewGrant(users["erin"], "auditor", "audit", "1h", time.Hour)
+	if err != nil {
+		t.Fatalf("new grant: %v", err)
+	}
+	ts.db.Create(&grant)
+	if err := ts.policy.ActivateGrant(&grant, 1); err != nil {
+		t.Fatalf("activate grant: %v", err)
+	}
+	if got := names(list("role=auditor&sort=username")); got != "carol,dave,erin" {
+		t.Errorf("role filter across assignments: got %s", got)
+	}
+	if got := names(list("email_domain=corp.example")); got != "erin" {
+		t.Errorf("email domain filter: got %s", got)
+	}
+	if got := names(list("q=AR&sort=username")); got != "carol" {
+		t.Errorf("search: got %s", got)
+	}
+	if w := ts.do(http.MethodGet, "/api/admin/users?sort=password_hash", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("sort by an unknown key: status %d", w.Code)
+	}
+
+	// Disabled and deleted users cannot sign in, restored users stay disabled
+	bob := list("username=bob").Users[0]
+	path := fmt.Sprintf("/api/admin/users/%d", bob.ID)
+	if w := ts.do(http.MethodPost, path+"/disable", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("disable: status %d: %s", w.Code, w.Body)
+	}
+	if got := names(list("status=disabled")); got != "bob" {
+		t.Errorf("disabled users: got %s", got)
+	}
+
+	// Other users only see active users and search usernames
+	aliceToken := ts.login("alice", "correct horse")
+	publicList := func(query string) *httptest.ResponseRecorder {
+		return ts.do(http.MethodGet, "/api/users?"+query, aliceToken, nil)
+	}
+	for _, query := range []string{"status=disabled", "status=deleted", "email_domain=corp.example", "role=admin", "sort=email", "sort=-email"} {
+		if w := publicList(query); w.Code != http.StatusBadRequest {
+			t.Errorf("public %s: status %d, want 400", query, w.Code)
+		}
+	}
+	var public page
+	w := publicList("sort=username")
+	decode(t, w, &public)
+	if got := names(public); got != "admin,alice,carol,dave,erin" {
+		t.Errorf("public directory: got %s", got)
+	}
+	if strings.Contains(w.Body.String(), "email") {
+		t.Errorf("public directory shows emails: %s", w.Body)
+	}
+	decode(t, publicList("q=corp"), &public)
+	if got := names(public); got != "" {
+		t.Errorf("public email search: got %s", got)
+	}
+	if w := ts.do(http.MethodPost, path+"/enable", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("enable: status %d", w.Code)
+	}
+	ts.login("bob", "correct horse")
+
+	if w := ts.do(http.MethodDelete, path, token, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", w.Code, w.Body)
+	}
+	if w := ts.do(http.MethodPost, "/api/auth/login", "", gin.H{"username": "bob", "password": "correct horse"}); w.Code == http.StatusOK {
+		t.Error("deleted user signed in")
+	}
+	if got := names(list("")); strings.Contains(got, "bob") {
+		t.Errorf("deleted user listed: %s", got)
+	}
+	if got := names(list("status=deleted")); got != "bob" {
+		t.Errorf("deleted users: got %s", got)
+	}
+	if w := ts.do(http.MethodPost, "/api/auth/register", "", gin.H{"username": "bob", "password": "battery staple", "email": "new@example.com"}); w.Code != http.StatusBadRequest {
+		t.Errorf("registered the name of a deleted user: status %d", w.Code)
+	}
+
+	if w := ts.do(http.MethodPost, path+"/restore", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("restore: status %d: %s", w.Code, w.Body)
+	}
+	if p := list("username=bob"); len(p.Users) != 1 || p.Users[0].Status != "disabled" {
+		t.Errorf("restored user: got %+v, want disabled", p.Users)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", "correct horse", "user")
+
+	// send makes a request of the cookie session, with a CSRF token unless it is empty
+	var cookies []*http.Cookie
+	send := func(method, path, csrf string, body interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		if csrf != "" {
+			req.Header.Set(csrfHeader, csrf)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, req)
+		if set := w.Result().Cookies(); len(set) > 0 {
+			cookies = set
+		}
+		return w
+	}
+
+	w := send(http.MethodPost, "/api/auth/login", "", gin.H{"username": "alice", "password": "correct horse"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body)
+	}
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Errorf("session cookie: got %+v, want HttpOnly and SameSite=Lax", cookies)
+	}
+	for header, want := range map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"X-Frame-Options":        "DENY",
+		"Referrer-Policy":        "no-referrer",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s: got %q, want %q", header, got, want)
+		}
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Errorf("Content-Security-Policy: got %q", csp)
+	}
+
+	if w := send(http.MethodPut, "/api/profile", "", gin.H{}); w.Code != http.StatusForbidden {
+		t.Errorf("without token: status %d, want 403", w.Code)
+	}
+	if w := send(http.MethodPut, "/api/profile", "forged", gin.H{}); w.Code != http.StatusForbidden {
+		t.Errorf("forged token: status %d, want 403", w.Code)
+	}
+
+	w = send(http.MethodGet, "/api/auth/csrf", "", nil)
+	var resp struct {
+		Token string `json:"csrf_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("csrf token: status %d: %s", w.Code, w.Body)
+	}
+	if w := send(http.MethodPut, "/api/profile", resp.Token, gin.H{}); w.Code != http.StatusOK {
+		t.Errorf("with token: status %d: %s", w.Code, w.Body)
+	}
+
+	// Bearer tokens are not sent by browsers on their own
+	token := ts.login("alice", "correct horse")
+	if w := ts.do(http.MethodPut, "/api/profile", token, gin.H{}); w.Code != http.StatusOK {
+		t.Errorf("bearer token: status %d: %s", w.Code, w.Body)
+	}
+
+	// Only a header that authenticates the request exempts a cookie session
+	withHeader := func(authorization string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/api/profile", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", authorization)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, req)
+		return w.Code
+	}
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:correct horse"))
+	if status := withHeader(basic); status != http.StatusForbidden {
+		t.Errorf("cookie with Basic credentials: status %d, want 403", status)
+	}
+	if status := withHeader("Bearer forged"); status != http.StatusForbidden {
+		t.Errorf("cookie with a forged bearer token: status %d, want 403", status)
+	}
+	if status := withHeader("Bearer " + token); status != http.StatusOK {
+		t.Errorf("cookie with a bearer token: status %d, want 200", status)
+	}
+}
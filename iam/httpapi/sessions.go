@@ -0,0 +1,145 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+
+	"iam/auth"
+	"iam/store"
+)
+
+// currentSessionID returns the ID of the request's session, or ""
+func currentSessionID(c *gin.Context) string {
+	if token := sessions.Default(c).ID(); token != "" {
+		return auth.SessionID(token)
+	}
+	return ""
+}
+
+// listSessions returns the active sessions of the current user
+func (s *Server) listSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		records, err := s.auth.Sessions.ListByUser(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+			return
+		}
+
+		current := currentSessionID(c)
+		result := make([]gin.H, 0, len(records))
+		for _, record := range records {
+			result = append(result, gin.H{
+				"id":           record.ID,
+				"ip":           record.IP,
+				"user_agent":   record.UserAgent,
+				"created_at":   record.CreatedAt,
+				"last_seen_at": record.LastSeenAt,
+				"expires_at":   record.ExpiresAt,
+				"current":      record.ID == current,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"sessions": result,
+		})
+	}
+}
+
+// revokeSession signs the current user out of one of their sessions
+func (s *Server) revokeSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		record, err := s.auth.Sessions.Load(c.Param("id"))
+		if err != nil || record.UserID != user.ID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		if err := s.auth.Sessions.Delete(record.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+			return
+		}
+
+		s.audit(c, "session.revoke", user.Username, store.AuditSuccess, gin.H{"session": record.ID, "ip": record.IP}, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Session revoked successfully",
+		})
+	}
+}
+
+// revokeOtherSessions signs the current user out of every other session
+func (s *Server) revokeOtherSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		records, err := s.auth.Sessions.ListByUser(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+			return
+		}
+
+		current := currentSessionID(c)
+		revoked := 0
+		for _, record := range records {
+			if record.ID == current {
+				continue
+			}
+			if err := s.auth.Sessions.Delete(record.ID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+				return
+			}
+			revoked++
+		}
+
+		s.audit(c, "session.revoke_others", user.Username, store.AuditSuccess, nil, gin.H{"revoked": revoked})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Other sessions revoked successfully",
+			"revoked": revoked,
+		})
+	}
+}
+
+// forceLogout signs a user out everywhere: every session ends and every
+// access and refresh token is revoked
+func (s *Server) forceLogout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user store.User
+		if result := s.db.First(&user, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err := s.auth.Sessions.DeleteByUser(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end sessions"})
+			return
+		}
+		if err := s.auth.RevokeUserTokens(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+			return
+		}
+
+		s.audit(c, "user.logout.force", user.Username, store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User signed out everywhere",
+		})
+	}
+}
@@ -0,0 +1,496 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"iam/auth"
+	"iam/policy"
+	"iam/store"
+	"iam/webhook"
+)
+
+// passwordError responds to a password that could not be set
+func passwordError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrWeakPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrPasswordHashBusy):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server busy, try again later"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set password"})
+	}
+}
+
+// directoryError responds to a directory query that failed
+func directoryError(c *gin.Context, err error) {
+	if errors.Is(err, errInvalidDirectoryQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+}
+
+// listAllUsers returns a page of users with their account state, deleted
+// users included when asked for with status=deleted
+func (s *Server) listAllUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		users, next, err := s.queryDirectory(c, true)
+		if err != nil {
+			directoryError(c, err)
+			return
+		}
+
+		views := make([]gin.H, 0, len(users))
+		for _, user := range users {
+			views = append(views, adminUserView(user))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"users":       views,
+			"next_cursor": next,
+		})
+	}
+}
+
+// managedUser loads the user of the request for an account state change,
+// which administrators cannot apply to themselves
+func (s *Server) managedUser(c *gin.Context) (store.User, bool) {
+	var user store.User
+	if result := s.db.First(&user, c.Param("id")); result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return store.User{}, false
+	}
+	if current, _ := currentUser(c); current.ID == user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot change the state of your own account"})
+		return store.User{}, false
+	}
+	return user, true
+}
+
+// disableUser signs a user out everywhere and stops them from signing in
+func (s *Server) disableUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.managedUser(c)
+		if !ok {
+			return
+		}
+
+		if err := s.auth.SetUserDisabled(&user, true); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable user"})
+			return
+		}
+
+		s.audit(c, "user.disable", user.Username, store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User disabled successfully",
+			"user":    adminUserView(user),
+		})
+	}
+}
+
+// enableUser lets a disabled user sign in again
+func (s *Server) enableUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.managedUser(c)
+		if !ok {
+			return
+		}
+
+		if err := s.auth.SetUserDisabled(&user, false); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable user"})
+			return
+		}
+
+		s.audit(c, "user.enable", user.Username, store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User enabled successfully",
+			"user":    adminUserView(user),
+		})
+	}
+}
+
+// deleteUser soft-deletes a user. Like SCIM deprovisioning, the user is
+// disabled as well, so a restored account has to be enabled explicitly.
+func (s *Server) deleteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := s.managedUser(c)
+		if !ok {
+			return
+		}
+
+		if err := s.auth.SetUserDisabled(&user, true); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+			return
+		}
+		if err := s.db.Delete(&user).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+			return
+		}
+
+		s.audit(c, "user.delete", user.Username, store.AuditSuccess, gin.H{"email": user.Email, "role": user.Role}, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User deleted successfully",
+		})
+	}
+}
+
+// restoreUser brings back a soft-deleted user, still disabled
+func (s *Server) restoreUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := s.deletedUserByID(c)
+		if errors.Is(err, errUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore user"})
+			return
+		}
+
+		if result := s.db.Unscoped().Model(&user).Update("deleted_at", nil); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore user"})
+			return
+		}
+		user.DeletedAt = gorm.DeletedAt{}
+
+		s.audit(c, "user.restore", user.Username, store.AuditSuccess, nil, gin.H{"email": user.Email, "role": user.Role})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User restored successfully",
+			"user":    adminUserView(user),
+		})
+	}
+}
+
+// updateUserRole replaces the roles of a user with a single role
+func (s *Server) updateUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var user store.User
+
+		if result := s.db.First(&user, id); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		var roleUpdate struct {
+			Role string `json:"role" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&roleUpdate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Replace every role of the user with the given one
+		previousRole := user.Role
+		if err := s.policy.SetUserRoles(&user, []string{roleUpdate.Role}); err != nil {
+			roleUpdateError(c, err)
+			return
+		}
+
+		// Tokens carrying the old role must not be used anymore
+		s.auth.RevokeUserTokens(user.ID)
+
+		s.audit(c, "user.role.update", user.Username, store.AuditSuccess, gin.H{"role": previousRole}, gin.H{"role": user.Role})
+		s.emit(webhook.EventUserRoleChanged, gin.H{"user": webhookUser(user), "roles_before": []string{previousRole}, "roles": s.policy.UserRoles(user)})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User role updated successfully",
+			"user": gin.H{
+				"id":       user.ID,
+				"username": user.Username,
+				"email":    user.Email,
+				"role":     user.Role,
+			},
+		})
+	}
+}
+
+// createUser creates a user with any role, for administrators provisioning
+// accounts without an invitation
+func (s *Server) createUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var createDTO struct {
+			Username string `json:"username" binding:"required"`
+			Password string `json:"password" binding:"required"`
+			Email    string `json:"email" binding:"required,email"`
+			Role     string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&createDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if createDTO.Role == "" {
+			createDTO.Role = s.policy.Config.DefaultRole
+		}
+
+		user, err := s.auth.CreateUser(createDTO.Username, createDTO.Email, createDTO.Password, createDTO.Role)
+		switch {
+		case errors.Is(err, auth.ErrUsernameTaken), errors.Is(err, auth.ErrEmailTaken),
+			errors.Is(err, policy.ErrUnknownRole), errors.Is(err, auth.ErrWeakPassword):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, auth.ErrPasswordHashBusy):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server busy, try again later"})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+
+		s.audit(c, "user.create", user.Username, store.AuditSuccess, nil, gin.H{"email": user.Email, "role": user.Role})
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "User created successfully",
+			"user": gin.H{
+				"id":       user.ID,
+				"username": user.Username,
+				"email":    user.Email,
+				"role":     user.Role,
+			},
+		})
+	}
+}
+
+// setUserPassword replaces the password of a user, who is signed out everywhere
+func (s *Server) setUserPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var user store.User
+
+		if result := s.db.First(&user, id); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		var passwordDTO struct {
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&passwordDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := s.auth.SetPassword(&user, passwordDTO.Password); err != nil {
+			passwordError(c, err)
+			return
+		}
+
+		s.audit(c, "user.password.set", user.Username, store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Password set successfully",
+		})
+	}
+}
+
+// revokeUserTokens invalidates every token issued to a user
+func (s *Server) revokeUserTokens() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var user store.User
+
+		if result := s.db.First(&user, id); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err := s.auth.RevokeUserTokens(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+			return
+		}
+
+		s.audit(c, "user.tokens.revoke", user.Username, store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User tokens revoked successfully",
+		})
+	}
+}
+
+// listUsers returns a page of the directory of users
+func (s *Server) listUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		users, next, err := s.queryDirectory(c, false)
+		if err != nil {
+			directoryError(c, err)
+			return
+		}
+
+		entries := make([]gin.H, 0, len(users))
+		for _, user := range users {
+			entries = append(entries, gin.H{
+				"username": user.Username,
+				"role":     user.Role,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"users":       entries,
+			"next_cursor": next,
+		})
+	}
+}
+
+// getUser returns a single user
+func (s *Server) getUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var user store.User
+		if result := s.db.Where("username = ?", id).First(&user); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user": gin.H{
+				"username": user.Username,
+				"email":    user.Email,
+				"role":     user.Role,
+			},
+		})
+	}
+}
+
+// updateUser changes the email of a user, who has to verify it again
+func (s *Server) updateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var user store.User
+		if result := s.db.Where("username = ?", id).First(&user); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		var updateDTO struct {
+			Email string `json:"email" binding:"required,email"`
+		}
+		if err := c.ShouldBindJSON(&updateDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		before := user.Email
+		if updateDTO.Email != user.Email {
+			user.Email = updateDTO.Email
+			user.EmailVerified = false
+			user.EmailVerifiedAt = nil
+
+			if result := s.db.Save(&user); result.Error != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+				return
+			}
+
+			// A new address has to be verified again
+			if err := s.auth.SendVerificationEmail(user); err != nil {
+				log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+			}
+		}
+
+		s.audit(c, "user.update", user.Username, store.AuditSuccess,
+			gin.H{"email": before}, gin.H{"email": user.Email})
+
+		c.JSON(http.StatusOK, gin.H{
+			"user": gin.H{
+				"username": user.Username,
+				"email":    user.Email,
+				"role":     user.Role,
+			},
+		})
+	}
+}
+
+// getProfile returns the authenticated user
+func (s *Server) getProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userInterface, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		user := userInterface.(store.User)
+		c.JSON(http.StatusOK, gin.H{
+			"user": gin.H{
+				"id":             user.ID,
+				"username":       user.Username,
+				"email":          user.Email,
+				"email_verified": user.EmailVerified,
+				"role":           user.Role,
+			},
+		})
+	}
+}
+
+// updateProfile changes the email or password of the authenticated user
+func (s *Server) updateProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userInterface, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		user := userInterface.(store.User)
+
+		var updateDTO struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+
+		if err := c.ShouldBindJSON(&updateDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Update user fields
+		emailChanged := updateDTO.Email != "" && updateDTO.Email != user.Email
+		if emailChanged {
+			user.Email = updateDTO.Email
+			user.EmailVerified = false
+			user.EmailVerifiedAt = nil
+		}
+
+		if updateDTO.Password != "" {
+			if err := s.auth.ChangePassword(&user, updateDTO.Password); err != nil {
+				passwordError(c, err)
+				return
+			}
+		}
+
+		// Save changes
+		if result := s.db.Save(&user); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+			return
+		}
+
+		s.audit(c, "profile.update", user.Username, store.AuditSuccess,
+			gin.H{"email": userInterface.(store.User).Email},
+			gin.H{"email": user.Email, "password_changed": updateDTO.Password != ""})
+
+		// A new address has to be verified again
+		if emailChanged {
+			if err := s.auth.SendVerificationEmail(user); err != nil {
+				log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Profile updated successfully",
+			"user": gin.H{
+				"username": user.Username,
+				"email":    user.Email,
+				"role":     user.Role,
+			},
+		})
+	}
+}
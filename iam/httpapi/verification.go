@@ -0,0 +1,142 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/auth"
+	"iam/store"
+)
+
+// verifyEmail marks the email address in a verification token as verified
+func (s *Server) verifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var verifyDTO struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&verifyDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := s.auth.RedeemUserToken(auth.PurposeVerifyEmail, verifyDTO.Token, func(u store.User) string { return u.Email })
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now := time.Now()
+		if err := s.db.Model(&user).Updates(map[string]interface{}{
+			"email_verified":    true,
+			"email_verified_at": now,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+
+		s.audit(c, "user.email.verify", user.Username, store.AuditSuccess, nil, gin.H{"email": user.Email})
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Email verified successfully",
+		})
+	}
+}
+
+// resendVerificationEmail sends a new verification link to the current user
+func (s *Server) resendVerificationEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := currentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+
+		if user.EmailVerified {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+			return
+		}
+
+		if err := s.auth.SendVerificationEmail(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Verification email sent",
+		})
+	}
+}
+
+// forgotPassword mails a reset link. It responds the same way whether or
+// not the address belongs to an account.
+func (s *Server) forgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var forgotDTO struct {
+			Email string `json:"email" binding:"required,email"`
+		}
+		if err := c.ShouldBindJSON(&forgotDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user store.User
+		if result := s.db.Where("email = ? AND service_account = ? AND disabled = ?", forgotDTO.Email, false, false).First(&user); result.Error == nil {
+			if err := s.auth.SendPasswordResetEmail(user); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reset email"})
+				return
+			}
+			s.audit(c, "auth.password.forgot", user.Username, store.AuditSuccess, nil, nil)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "If the address belongs to an account, a reset link has been sent",
+		})
+	}
+}
+
+// resetPassword sets a new password with a reset token and signs the user
+// out everywhere
+func (s *Server) resetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var resetDTO struct {
+			Token    string `json:"token" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&resetDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := s.auth.RedeemUserToken(auth.PurposePasswordReset, resetDTO.Token, auth.PasswordBinding)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := s.auth.ChangePassword(&user, resetDTO.Password); err != nil {
+			passwordError(c, err)
+			return
+		}
+
+		// Receiving the reset email proves the address as well
+		if err := s.db.Model(&user).Updates(map[string]interface{}{
+			"email_verified":    true,
+			"email_verified_at": time.Now(),
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+
+		s.auth.RevokeUserTokens(user.ID)
+		s.auth.Sessions.DeleteByUser(user.ID)
+		s.auth.ResetLoginFailures(user.Username)
+
+		s.audit(c, "auth.password.reset", user.Username, store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Password reset successfully",
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"iam/store"
+	"iam/webhook"
+)
+
+// emit queues a webhook event. A failure loses the notification, not the
+// change it describes, so it is only logged.
+func (s *Server) emit(event string, data gin.H) {
+	if err := s.webhooks.Emit(event, data); err != nil {
+		log.Printf("Failed to queue webhook event %s: %v", event, err)
+	}
+}
+
+// webhookUser describes a user in webhook payloads
+func webhookUser(user store.User) gin.H {
+	return gin.H{
+		"id":       user.ID,
+		"username": user.Username,
+		"email":    user.Email,
+		"role":     user.Role,
+	}
+}
+
+// webhookDTO is the editable part of a webhook
+type webhookDTO struct {
+	URL         string   `json:"url" binding:"required"`
+	Description string   `json:"description"`
+	Events      []string `json:"events" binding:"required"`
+	Active      *bool    `json:"active"`
+}
+
+// apply validates the DTO and copies it onto hook
+func (dto webhookDTO) apply(hook *store.Webhook) error {
+	target, err := url.Parse(dto.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	events, err := webhook.ParseEvents(dto.Events)
+	if err != nil {
+		return err
+	}
+
+	hook.URL = dto.URL
+	hook.Description = dto.Description
+	hook.Events = events
+	if dto.Active != nil {
+		hook.Active = *dto.Active
+	}
+	return nil
+}
+
+// webhookByID loads the webhook of the request or responds with 404
+func (s *Server) webhookByID(c *gin.Context) (store.Webhook, bool) {
+	var hook store.Webhook
+	if result := s.db.First(&hook, c.Param("id")); result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return store.Webhook{}, false
+	}
+	return hook, true
+}
+
+// listWebhooks returns every webhook subscription
+func (s *Server) listWebhooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var hooks []store.Webhook
+		s.db.Order("id").Find(&hooks)
+
+		c.JSON(http.StatusOK, gin.H{
+			"webhooks": hooks,
+			"events":   webhook.Events,
+		})
+	}
+}
+
+// createWebhook subscribes a URL to events. The signing secret is only
+// shown in the response.
+func (s *Server) createWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var createDTO webhookDTO
+		if err := c.ShouldBindJSON(&createDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		hook := store.Webhook{Active: true}
+		if err := createDTO.apply(&hook); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+			return
+		}
+		hook.Secret = secret
+
+		if result := s.db.Create(&hook); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+			return
+		}
+
+		s.audit(c, "webhook.create", hook.URL, store.AuditSuccess, nil, hook)
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Webhook created successfully",
+			"webhook": hook,
+			"secret":  secret,
+		})
+	}
+}
+
+// updateWebhook changes the URL, events or state of a webhook
+func (s *Server) updateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hook, ok := s.webhookByID(c)
+		if !ok {
+			return
+		}
+
+		var updateDTO webhookDTO
+		if err := c.ShouldBindJSON(&updateDTO); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		before := hook
+		if err := updateDTO.apply(&hook); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if result := s.db.Save(&hook); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+			return
+		}
+
+		s.audit(c, "webhook.update", hook.URL, store.AuditSuccess, before, hook)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Webhook updated successfully",
+			"webhook": hook,
+		})
+	}
+}
+
+// deleteWebhook removes a webhook with its delivery log
+func (s *Server) deleteWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hook, ok := s.webhookByID(c)
+		if !ok {
+			return
+		}
+
+		s.db.Where("webhook_id = ?", hook.ID).Delete(&store.WebhookDelivery{})
+		if result := s.db.Delete(&hook); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+			return
+		}
+
+		s.audit(c, "webhook.delete", hook.URL, store.AuditSuccess, hook, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Webhook deleted successfully",
+		})
+	}
+}
+
+// rotateWebhookSecret replaces the signing secret of a webhook
+func (s *Server) rotateWebhookSecret() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hook, ok := s.webhookByID(c)
+		if !ok {
+			return
+		}
+
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+			return
+		}
+		if result := s.db.Model(&hook).Update("secret", secret); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate secret"})
+			return
+		}
+
+		s.audit(c, "webhook.secret.rotate", hook.URL, store.AuditSuccess, nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Webhook secret rotated successfully",
+			"secret":  secret,
+		})
+	}
+}
+
+// listWebhookDeliveries returns the delivery log of a webhook, newest
+// first, optionally limited to one status
+func (s *Server) listWebhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hook, ok := s.webhookByID(c)
+		if !ok {
+			return
+		}
+
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+		if page < 1 {
+			page = 1
+		}
+		if pageSize < 1 || pageSize > 500 {
+			pageSize = 50
+		}
+
+		query := s.db.Model(&store.WebhookDelivery{}).Where("webhook_id = ?", hook.ID)
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+
+		var total int64
+		var deliveries []store.WebhookDelivery
+		query.Count(&total)
+		if result := query.Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&deliveries); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load deliveries"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"deliveries": deliveries,
+			"total":      total,
+			"page":       page,
+			"page_size":  pageSize,
+		})
+	}
+}
+
+// redeliverWebhookDelivery queues a delivery again, for instance once the
+// receiver is fixed after the retries gave up
+func (s *Server) redeliverWebhookDelivery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hook, ok := s.webhookByID(c)
+		if !ok {
+			return
+		}
+
+		var delivery store.WebhookDelivery
+		if result := s.db.Where("webhook_id = ?", hook.ID).First(&delivery, c.Param("deliveryID")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+			return
+		}
+		if delivery.Status == webhook.StatusPending {
+			c.JSON(http.StatusConflict, gin.H{"error": "Delivery is still pending"})
+			return
+		}
+
+		if err := s.webhooks.Redeliver(&delivery); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue delivery"})
+			return
+		}
+
+		s.audit(c, "webhook.redeliver", hook.URL, store.AuditSuccess, nil, gin.H{"delivery": delivery.ID, "event": delivery.Event})
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":  "Delivery queued",
+			"delivery": delivery,
+		})
+	}
+}
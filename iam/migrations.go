@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"iam/config"
+	"iam/store"
+)
+
+// runMigrate implements the migrate command:
+//
+//	iam migrate [up [version]]   apply pending migrations
+//	iam migrate down [version]   revert to version, one step by default
+//	iam migrate status           list migrations and whether they are applied
+func runMigrate(args []string) int {
+	st, err := config.OpenStore()
+	if err != nil {
+		log.Printf("Failed to connect to database: %v", err)
+		return 1
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	var target int
+	if len(args) > 1 {
+		if target, err = strconv.Atoi(args[1]); err != nil || target < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid version %q\n", args[1])
+			return 2
+		}
+	}
+
+	switch command {
+	case "up":
+		if len(args) < 2 {
+			target = store.LatestSchemaVersion()
+		}
+		err = st.MigrateUp(target)
+	case "down":
+		if len(args) < 2 {
+			current, verr := st.SchemaVersion()
+			if verr != nil {
+				err = verr
+				break
+			}
+			target = max(current-1, 0)
+		}
+		err = st.MigrateDown(target)
+	case "status":
+		err = printMigrationStatus(st)
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: iam migrate [up [version] | down [version] | status]")
+		return 2
+	}
+
+	if err != nil {
+		log.Printf("Migration failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// printMigrationStatus lists every migration with the time it was applied
+func printMigrationStatus(st *store.Store) error {
+	statuses, err := st.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range statuses {
+		status := "pending"
+		switch {
+		case m.Unknown:
+			status = "applied by a newer build"
+		case m.AppliedAt != nil:
+			status = "applied " + m.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-34s %s\n", m.Version, m.Name, status)
+	}
+	return nil
+}
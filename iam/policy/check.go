@@ -0,0 +1,144 @@
+package policy
+
+import (
+	"github.com/casbin/casbin/v2"
+
+	"iam/store"
+)
+
+// Decision is the outcome of checking a request against the policy set
+type Decision struct {
+	Allowed bool     `json:"allowed"`
+	Subject string   `json:"subject"` // the Casbin subject that was allowed or last checked
+	Domain  string   `json:"domain"`
+	Policy  []string `json:"policy,omitempty"` // the policy line that allowed the request
+	Chain   []string `json:"chain,omitempty"`  // subject, inherited roles, policy subject
+}
+
+// Decide checks a request the way the authorization middleware does, in
+// the request's domain. Users are checked through their role assignments,
+// anyone else with the role. user may be nil.
+func Decide(e *casbin.Enforcer, role string, user *store.User, path, method string) (Decision, error) {
+	domain := GlobalDomain
+	if org, ok := OrgFromPath(path); ok {
+		domain = org
+	}
+
+	subject := role
+	if user != nil {
+		subject = AuthSubject(*user)
+	}
+
+	allowed, explain, err := e.EnforceEx(subject, domain, path, method)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := Decision{Allowed: allowed, Subject: subject, Domain: domain}
+	if allowed {
+		decision.Policy = explain
+		if len(explain) > 0 {
+			decision.Chain = RoleChain(e, subject, explain[0], domain)
+		}
+	}
+	return decision, nil
+}
+
+// Decide checks a request against the RBAC policy set
+func (s *Service) Decide(role string, user *store.User, path, method string) (Decision, error) {
+	return Decide(s.Enforcer, role, user, path, method)
+}
+
+// RoleChain returns how subject inherits the policy subject target in the
+// domain, e.g. [user:3 org-admin], or nil if it does not
+func RoleChain(e *casbin.Enforcer, subject, target, domain string) []string {
+	if subject == target {
+		return []string{subject}
+	}
+
+	// Breadth-first search over direct role assignments finds the shortest chain
+	parents := map[string]string{subject: ""}
+	queue := []string{subject}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, next := range e.GetRolesForUserInDomain(current, domain) {
+			if _, seen := parents[next]; seen {
+				continue
+			}
+			parents[next] = current
+			if next == target {
+				chain := []string{next}
+				for p := current; p != ""; p = parents[p] {
+					chain = append([]string{p}, chain...)
+				}
+				return chain
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+// DomainRules places rules given as subject, object, action in the global domain
+func DomainRules(rules [][]string) [][]string {
+	result := make([][]string, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule) == 3 {
+			rule = []string{rule[0], GlobalDomain, rule[1], rule[2]}
+		}
+		result = append(result, rule)
+	}
+	return result
+}
+
+// Change is a set of policies and groupings to add and remove
+type Change struct {
+	AddPolicies     [][]string `json:"add_policies"`
+	RemovePolicies  [][]string `json:"remove_policies"`
+	AddGroupings    [][]string `json:"add_groupings"`
+	RemoveGroupings [][]string `json:"remove_groupings"`
+}
+
+// ApplyTo applies the change to an enforcer
+func (ch Change) ApplyTo(e *casbin.Enforcer) error {
+	if len(ch.RemovePolicies) > 0 {
+		if _, err := e.RemovePolicies(DomainRules(ch.RemovePolicies)); err != nil {
+			return err
+		}
+	}
+	if len(ch.RemoveGroupings) > 0 {
+		if _, err := e.RemoveGroupingPolicies(ch.RemoveGroupings); err != nil {
+			return err
+		}
+	}
+	if len(ch.AddPolicies) > 0 {
+		if _, err := e.AddPolicies(DomainRules(ch.AddPolicies)); err != nil {
+			return err
+		}
+	}
+	if len(ch.AddGroupings) > 0 {
+		if _, err := e.AddGroupingPolicies(ch.AddGroupings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Simulate returns an in-memory copy of the RBAC policy set with the change
+// applied. The copy has no adapter, so changes to it are never persisted.
+func (s *Service) Simulate(ch Change) (*casbin.Enforcer, error) {
+	simulated, err := casbin.NewEnforcer(s.Enforcer.GetModel().Copy())
+	if err != nil {
+		return nil, err
+	}
+	setupRoleManager(simulated)
+	if err := simulated.BuildRoleLinks(); err != nil {
+		return nil, err
+	}
+	if err := ch.ApplyTo(simulated); err != nil {
+		return nil, err
+	}
+	return simulated, nil
+}
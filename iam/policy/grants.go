@@ -0,0 +1,168 @@
+package policy
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"iam/store"
+)
+
+// Grant statuses
+const (
+	GrantPending = "pending"
+	GrantActive  = "active"
+	GrantDenied  = "denied"
+	GrantExpired = "expired"
+	GrantRevoked = "revoked"
+)
+
+var (
+	ErrGrantNotFound  = errors.New("grant not found")
+	ErrGrantHeld      = errors.New("user already holds or requested that role")
+	ErrGrantSelf      = errors.New("grants cannot be decided by the user they are for")
+	ErrGrantDuration  = errors.New("duration is invalid or exceeds the maximum")
+	ErrRoleGranted    = errors.New("role is granted temporarily, revoke the grant first")
+	ErrGrantNotActive = errors.New("grant is not active")
+	ErrBreakGlassRole = errors.New("role is not eligible for break-glass access")
+)
+
+// grantSubject returns the Casbin subject a grant assigns its role to
+func grantSubject(grant store.RoleGrant) string {
+	return UserSubjectPrefix + strconv.FormatUint(uint64(grant.UserID), 10)
+}
+
+// GrantedRoles returns the roles a user holds through active grants
+func (s *Service) GrantedRoles(userID uint) []string {
+	var roles []string
+	s.db.Model(&store.RoleGrant{}).Where("user_id = ? AND status = ?", userID, GrantActive).Pluck("role", &roles)
+	return roles
+}
+
+// NewGrant validates a grant for a user and returns it unsaved
+func (s *Service) NewGrant(user store.User, role, reason, duration string, maxDuration time.Duration) (store.RoleGrant, error) {
+	ttl, err := time.ParseDuration(duration)
+	if err != nil || ttl <= 0 || ttl > maxDuration {
+		return store.RoleGrant{}, ErrGrantDuration
+	}
+
+	var count int64
+	s.db.Model(&store.Role{}).Where("name = ?", role).Count(&count)
+	if count == 0 {
+		return store.RoleGrant{}, ErrUnknownRole
+	}
+
+	for _, held := range s.UserRoles(user) {
+		if held == role {
+			return store.RoleGrant{}, ErrGrantHeld
+		}
+	}
+	s.db.Model(&store.RoleGrant{}).
+		Where("user_id = ? AND role = ? AND status IN ?", user.ID, role, []string{GrantPending, GrantActive}).
+		Count(&count)
+	if count > 0 {
+		return store.RoleGrant{}, ErrGrantHeld
+	}
+
+	return store.RoleGrant{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     role,
+		Reason:   reason,
+		Duration: int64(ttl / time.Second),
+		Status:   GrantPending,
+	}, nil
+}
+
+// NewBreakGlassGrant prepares a break-glass grant of role, which must be
+// one of the configured break-glass roles
+func (s *Service) NewBreakGlassGrant(user store.User, role, reason, duration string) (store.RoleGrant, error) {
+	if !slices.Contains(s.Config.BreakGlassRoles, role) {
+		return store.RoleGrant{}, ErrBreakGlassRole
+	}
+	grant, err := s.NewGrant(user, role, reason, duration, s.Config.BreakGlassMaxDuration)
+	if err != nil {
+		return store.RoleGrant{}, err
+	}
+	grant.BreakGlass = true
+	grant.NeedsReview = true
+	return grant, nil
+}
+
+// ActivateGrant assigns the role of a pending grant until it expires
+func (s *Service) ActivateGrant(grant *store.RoleGrant, decidedBy uint) error {
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(grant.Duration) * time.Second)
+
+	result := s.db.Model(&store.RoleGrant{}).
+		Where("id = ? AND status = ?", grant.ID, GrantPending).
+		Updates(map[string]interface{}{
+			"status":        GrantActive,
+			"decided_by_id": decidedBy,
+			"decided_at":    now,
+			"expires_at":    expiresAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrGrantNotFound
+	}
+
+	if _, err := s.Enforcer.AddGroupingPolicy(grantSubject(*grant), grant.Role, GlobalDomain); err != nil {
+		s.db.Model(&store.RoleGrant{}).Where("id = ?", grant.ID).Update("status", GrantPending)
+		return err
+	}
+
+	grant.Status = GrantActive
+	grant.DecidedByID = &decidedBy
+	grant.DecidedAt = &now
+	grant.ExpiresAt = &expiresAt
+	return nil
+}
+
+// EndGrant removes the role of an active grant and records why it ended
+func (s *Service) EndGrant(grant *store.RoleGrant, status string) error {
+	result := s.db.Model(&store.RoleGrant{}).
+		Where("id = ? AND status = ?", grant.ID, GrantActive).
+		Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrGrantNotActive
+	}
+
+	grant.Status = status
+	_, err := s.Enforcer.RemoveGroupingPolicy(grantSubject(*grant), grant.Role, GlobalDomain)
+	return err
+}
+
+// ExpireGrants revokes every active grant that expired before now
+func (s *Service) ExpireGrants(now time.Time) {
+	var grants []store.RoleGrant
+	s.db.Where("status = ? AND expires_at <= ?", GrantActive, now).Find(&grants)
+
+	for _, grant := range grants {
+		if err := s.EndGrant(&grant, GrantExpired); err != nil {
+			if !errors.Is(err, ErrGrantNotActive) {
+				log.Printf("Failed to expire grant %d: %v", grant.ID, err)
+				s.store.AuditSystem("grant.expire", grant.Username, store.AuditFailure, nil,
+					map[string]interface{}{"grant": grant.ID, "role": grant.Role, "error": err.Error()})
+			}
+			continue
+		}
+		s.store.AuditSystem("grant.expire", grant.Username, store.AuditSuccess, nil,
+			map[string]interface{}{"grant": grant.ID, "role": grant.Role})
+	}
+}
+
+// SweepGrants expires grants periodically
+func (s *Service) SweepGrants(interval time.Duration) {
+	for {
+		s.ExpireGrants(time.Now())
+		time.Sleep(interval)
+	}
+}
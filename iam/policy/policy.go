@@ -0,0 +1,169 @@
+// Package policy holds the Casbin enforcers of an iam instance: the
+// path-based RBAC model with organization domains, the ABAC model for
+// route groups with conditions, temporary role grants and declarative
+// policy files.
+package policy
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	gormadapter "github.com/casbin/gorm-adapter/v3"
+	"gorm.io/gorm"
+
+	"iam/store"
+)
+
+//go:embed auth_model.conf
+var rbacModel string
+
+//go:embed abac_model.conf
+var abacModel string
+
+const (
+	// GlobalDomain is the domain of policies that apply everywhere
+	GlobalDomain = "*"
+
+	// Users are Casbin subjects as user:<id>, so usernames can never be
+	// mistaken for role names
+	UserSubjectPrefix = "user:"
+
+	OrgAdminRole  = "org-admin"
+	OrgMemberRole = "org-member"
+
+	// UnverifiedRole is the Casbin role of users who have not verified
+	// their email address yet
+	UnverifiedRole = "unverified"
+
+	// SCIMProvisionerRole may manage users and groups over SCIM
+	SCIMProvisionerRole = "scim-provisioner"
+
+	// OnCallRole may activate the break-glass roles without approval
+	OnCallRole = "on-call"
+)
+
+// Config holds the policy settings
+type Config struct {
+	// DefaultRole is granted on self-registration
+	DefaultRole string
+	// GrantMaxDuration caps how long an approved grant lasts
+	GrantMaxDuration time.Duration
+	// BreakGlassMaxDuration caps how long a break-glass grant lasts
+	BreakGlassMaxDuration time.Duration
+	// BreakGlassRoles are the roles break-glass grants may activate
+	BreakGlassRoles []string
+	// ABACLocation is the time zone of the Hour and Weekday attributes
+	ABACLocation *time.Location
+}
+
+// Service makes authorization decisions and manages roles and policies
+type Service struct {
+	store *store.Store
+	db    *gorm.DB
+
+	// Enforcer holds the RBAC policies and every role assignment
+	Enforcer *casbin.Enforcer
+	// ABAC holds the policies of route groups authorized with conditions
+	ABAC *casbin.Enforcer
+
+	Config Config
+}
+
+// New loads both enforcers, seeding the default policies and roles into
+// an empty database. The schema must be migrated before.
+func New(st *store.Store, cfg Config) (*Service, error) {
+	if cfg.ABACLocation == nil {
+		cfg.ABACLocation = time.Local
+	}
+
+	s := &Service{store: st, db: st.DB, Config: cfg}
+	if err := s.setupRBAC(); err != nil {
+		return nil, err
+	}
+	if err := s.setupABAC(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newAdapter returns a Casbin adapter for a rule table. The tables are
+// created by the schema migrations.
+func (s *Service) newAdapter(table string) (*gormadapter.Adapter, error) {
+	adapterDB := s.db.Session(&gorm.Session{})
+	gormadapter.TurnOffAutoMigrate(adapterDB)
+	return gormadapter.NewAdapterByDBUseTableName(adapterDB, "", table)
+}
+
+// setupRBAC creates the RBAC enforcer
+func (s *Service) setupRBAC() error {
+	adapter, err := s.newAdapter("casbin_rule")
+	if err != nil {
+		return fmt.Errorf("initialize Casbin adapter: %w", err)
+	}
+
+	m, err := model.NewModelFromString(rbacModel)
+	if err != nil {
+		return fmt.Errorf("load model: %w", err)
+	}
+
+	enforcer, err := casbin.NewEnforcer(m, adapter)
+	if err != nil {
+		return fmt.Errorf("create enforcer: %w", err)
+	}
+	setupRoleManager(enforcer)
+	s.Enforcer = enforcer
+
+	// Load policies
+	if err := enforcer.LoadPolicy(); err != nil {
+		return fmt.Errorf("load policy: %w", err)
+	}
+
+	if err := s.seedPolicies(); err != nil {
+		return fmt.Errorf("seed policies: %w", err)
+	}
+
+	// Create roles table if it doesn't exist
+	var roles []store.Role
+	if result := s.db.Find(&roles); result.Error != nil || len(roles) == 0 {
+		defaultRoles := []store.Role{
+			{Name: "admin"},
+			{Name: "user"},
+			{Name: "guest"},
+		}
+		s.db.Create(&defaultRoles)
+	}
+	for _, name := range []string{UnverifiedRole, OrgAdminRole, OrgMemberRole, SCIMProvisionerRole, OnCallRole} {
+		s.db.Where(store.Role{Name: name}).FirstOrCreate(&store.Role{})
+	}
+
+	var role store.Role
+	if result := s.db.Where("name = ?", s.Config.DefaultRole).First(&role); result.Error != nil {
+		log.Printf("Default role %q does not exist, registrations will fail", s.Config.DefaultRole)
+	}
+
+	// Users get their roles through grouping rules
+	return s.migrateUserRoles()
+}
+
+// migrateUserRoles turns the role column of users created before roles were
+// assigned through grouping rules into a role assignment
+func (s *Service) migrateUserRoles() error {
+	var users []store.User
+	if err := s.db.Find(&users).Error; err != nil {
+		return fmt.Errorf("load users for role migration: %w", err)
+	}
+
+	for _, user := range users {
+		if len(s.UserRoles(user)) > 0 || user.Role == "" {
+			continue
+		}
+		if err := s.AssignRole(user); err != nil {
+			return fmt.Errorf("migrate role of user %s: %w", user.Username, err)
+		}
+	}
+	return nil
+}
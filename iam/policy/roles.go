@@ -0,0 +1,324 @@
+package policy
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/util"
+
+	"iam/store"
+)
+
+// Global role assignments and role inheritance are grouping rules in the
+// global domain:
+//
+//	g, user:<id>, <role>, *   the user has the role
+//	g, <role>, <parent>, *    the role inherits every permission of parent
+
+var (
+	ErrUnknownRole   = errors.New("role does not exist")
+	ErrRoleRequired  = errors.New("at least one role is required")
+	ErrRoleCycle     = errors.New("inheritance would create a cycle")
+	ErrRoleInherited = errors.New("role already inherits that role")
+)
+
+const (
+	// Roles with the policy (role, *, mfa, require) must use a second factor
+	MFAPolicyObject = "mfa"
+	MFAPolicyAction = "require"
+
+	// Clients may be granted the scope s for a user whose subject has the
+	// policy (subject, *, /oauth/scopes/<s>, grant)
+	ScopePolicyPrefix = "/oauth/scopes/"
+	ScopePolicyAction = "grant"
+
+	// Scopes are Casbin subjects as well, (scope:<scope>, path, method)
+	// decides which API requests a token with that scope may make
+	ScopeSubjectPrefix = "scope:"
+)
+
+// setupRoleManager makes grouping rules in the global domain apply in every
+// organization, so global roles and their inheritance hold everywhere
+func setupRoleManager(e *casbin.Enforcer) {
+	e.AddNamedDomainMatchingFunc("g", "keyMatch", util.KeyMatch)
+}
+
+// UserSubject returns the Casbin subject of a user
+func UserSubject(user store.User) string {
+	return UserSubjectPrefix + strconv.FormatUint(uint64(user.ID), 10)
+}
+
+// EffectiveRole is the role a user acts with. Users who have not verified
+// their email are restricted to the unverified role.
+func EffectiveRole(user store.User) string {
+	if !user.EmailVerified && !user.ServiceAccount {
+		return UnverifiedRole
+	}
+	return user.Role
+}
+
+// AuthSubject is the Casbin subject a user is authorized as. Users who have
+// not verified their email act with the unverified role only.
+func AuthSubject(user store.User) string {
+	if role := EffectiveRole(user); role == UnverifiedRole {
+		return role
+	}
+	return UserSubject(user)
+}
+
+// OrgFromPath returns the organization a request path is scoped to
+func OrgFromPath(path string) (string, bool) {
+	rest, found := strings.CutPrefix(path, "/api/orgs/")
+	if !found {
+		return "", false
+	}
+	slug, _, _ := strings.Cut(rest, "/")
+	return slug, slug != ""
+}
+
+// DomainPolicy places a policy given as subject, object, action in the
+// global domain. Policies with a domain are returned unchanged.
+func DomainPolicy(policy []interface{}) []interface{} {
+	if len(policy) != 3 {
+		return policy
+	}
+	return []interface{}{policy[0], GlobalDomain, policy[1], policy[2]}
+}
+
+// UserRoles returns the global roles assigned to a user directly
+func (s *Service) UserRoles(user store.User) []string {
+	rules, _ := s.Enforcer.GetFilteredGroupingPolicy(0, UserSubject(user), "", GlobalDomain)
+	roles := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		roles = append(roles, rule[1])
+	}
+	return roles
+}
+
+// UserIDsWithRole returns the IDs of the users holding role globally,
+// assigned directly, through inheritance or through an active grant
+func (s *Service) UserIDsWithRole(role string) ([]uint, error) {
+	subjects, err := s.Enforcer.GetImplicitUsersForRole(role, GlobalDomain)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint
+	for _, subject := range subjects {
+		id, ok := strings.CutPrefix(subject, UserSubjectPrefix)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+			ids = append(ids, uint(n))
+		}
+	}
+	return ids, nil
+}
+
+// AssignRole adds the user's primary role as a global role assignment
+func (s *Service) AssignRole(user store.User) error {
+	_, err := s.Enforcer.AddGroupingPolicy(UserSubject(user), user.Role, GlobalDomain)
+	return err
+}
+
+// SetUserRoles replaces the global roles of a user. The first role becomes
+// the user's primary role, which tokens and the UI show.
+func (s *Service) SetUserRoles(user *store.User, roles []string) error {
+	var names []string
+	for _, role := range roles {
+		if !slices.Contains(names, role) {
+			names = append(names, role)
+		}
+	}
+	if len(names) == 0 {
+		return ErrRoleRequired
+	}
+
+	var count int64
+	s.db.Model(&store.Role{}).Where("name IN ?", names).Count(&count)
+	if int(count) != len(names) {
+		return ErrUnknownRole
+	}
+
+	// Roles held through grants stay until the grant ends
+	granted := s.GrantedRoles(user.ID)
+	for _, role := range names {
+		if slices.Contains(granted, role) {
+			return ErrRoleGranted
+		}
+	}
+
+	subject := UserSubject(*user)
+	for _, role := range s.UserRoles(*user) {
+		if slices.Contains(granted, role) {
+			continue
+		}
+		if _, err := s.Enforcer.RemoveGroupingPolicy(subject, role, GlobalDomain); err != nil {
+			return err
+		}
+	}
+	rules := make([][]string, 0, len(names))
+	for _, role := range names {
+		rules = append(rules, []string{subject, role, GlobalDomain})
+	}
+	if _, err := s.Enforcer.AddGroupingPolicies(rules); err != nil {
+		return err
+	}
+
+	user.Role = names[0]
+	return s.db.Model(user).Update("role", user.Role).Error
+}
+
+// RoleParents returns the roles a role inherits from directly
+func (s *Service) RoleParents(role string) []string {
+	rules, _ := s.Enforcer.GetFilteredGroupingPolicy(0, role, "", GlobalDomain)
+	parents := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		parents = append(parents, rule[1])
+	}
+	return parents
+}
+
+// InheritRole makes role inherit the permissions of parent
+func (s *Service) InheritRole(role, parent string) error {
+	var count int64
+	s.db.Model(&store.Role{}).Where("name IN ?", []string{role, parent}).Count(&count)
+	if count != 2 {
+		return ErrUnknownRole
+	}
+
+	if role == parent {
+		return ErrRoleCycle
+	}
+	ancestors, err := s.Enforcer.GetImplicitRolesForUser(parent, GlobalDomain)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(ancestors, role) {
+		return ErrRoleCycle
+	}
+
+	added, err := s.Enforcer.AddGroupingPolicy(role, parent, GlobalDomain)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return ErrRoleInherited
+	}
+	return nil
+}
+
+// DomainPermissions lists what a user may do in one domain
+type DomainPermissions struct {
+	Domain        string     `json:"domain"`
+	Roles         []string   `json:"roles"`          // roles assigned directly
+	ImplicitRoles []string   `json:"implicit_roles"` // roles held through inheritance as well
+	Permissions   [][]string `json:"permissions"`    // policies granted by any of them
+}
+
+// permissionsFor collects the policies of the subjects that apply in a domain
+func (s *Service) permissionsFor(subjects []string, domain string) [][]string {
+	permissions := [][]string{}
+	for _, subject := range subjects {
+		policies, _ := s.Enforcer.GetFilteredPolicy(0, subject)
+		for _, policy := range policies {
+			if policy[1] == GlobalDomain || policy[1] == domain {
+				permissions = append(permissions, policy)
+			}
+		}
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return strings.Join(permissions[i], ",") < strings.Join(permissions[j], ",")
+	})
+	return permissions
+}
+
+// EffectivePermissions resolves the roles and permissions a user holds
+// through the role hierarchy, globally and in each organization
+func (s *Service) EffectivePermissions(user store.User) []DomainPermissions {
+	subject := AuthSubject(user)
+
+	direct := s.UserRoles(user)
+	if subject == UnverifiedRole {
+		direct = []string{UnverifiedRole}
+	}
+	implicit, _ := s.Enforcer.GetImplicitRolesForUser(subject, GlobalDomain)
+	if subject == UnverifiedRole {
+		implicit = append([]string{UnverifiedRole}, implicit...)
+	}
+
+	result := []DomainPermissions{{
+		Domain:        GlobalDomain,
+		Roles:         direct,
+		ImplicitRoles: implicit,
+		Permissions:   s.permissionsFor(append([]string{subject}, implicit...), GlobalDomain),
+	}}
+	if subject == UnverifiedRole {
+		return result
+	}
+
+	memberships, _ := s.Enforcer.GetFilteredGroupingPolicy(0, subject)
+	for _, membership := range memberships {
+		org := membership[2]
+		if org == GlobalDomain {
+			continue
+		}
+		inherited, _ := s.Enforcer.GetImplicitRolesForUser(membership[1], org)
+		roles := append([]string{membership[1]}, inherited...)
+		result = append(result, DomainPermissions{
+			Domain:        org,
+			Roles:         []string{membership[1]},
+			ImplicitRoles: roles,
+			Permissions:   s.permissionsFor(roles, org),
+		})
+	}
+	return result
+}
+
+// OrgRole returns the role of a user in an organization, or "" if the user
+// is not a member
+func (s *Service) OrgRole(user store.User, slug string) string {
+	// Global roles apply in every organization, so only rules made for
+	// the organization itself count as membership
+	rules, _ := s.Enforcer.GetFilteredGroupingPolicy(0, UserSubject(user), "", slug)
+	if len(rules) == 0 {
+		return ""
+	}
+	return rules[0][1]
+}
+
+// MFARequired reports whether the policy set requires MFA for a role or
+// a user subject
+func (s *Service) MFARequired(subject string) bool {
+	required, err := s.Enforcer.Enforce(subject, GlobalDomain, MFAPolicyObject, MFAPolicyAction)
+	return err == nil && required
+}
+
+// GrantableScopes filters the requested scopes down to those the client may
+// request and the subject, a role or a user, may grant
+func (s *Service) GrantableScopes(subject string, client store.OAuthClient, requested string) string {
+	var granted []string
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(strings.Fields(client.Scopes), scope) {
+			continue
+		}
+		if allowed, err := s.Enforcer.Enforce(subject, GlobalDomain, ScopePolicyPrefix+scope, ScopePolicyAction); err == nil && allowed {
+			granted = append(granted, scope)
+		}
+	}
+	return strings.Join(granted, " ")
+}
+
+// ScopesAllow reports whether any of the scopes allows an API request
+func (s *Service) ScopesAllow(scopes []string, path, method string) bool {
+	for _, scope := range scopes {
+		if allowed, err := s.Enforcer.Enforce(ScopeSubjectPrefix+scope, GlobalDomain, path, method); err == nil && allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package policy
+
+import (
+	"slices"
+	"time"
+
+	"iam/store"
+)
+
+// policySeeds are the built-in policies in the order they were added to
+// the server. Each seed is added once and recorded in policy_seeds, so a
+// policy an admin removes is not restored on the next start. Policies for
+// new endpoints go in a new seed.
+var policySeeds = []struct {
+	version int
+	rules   [][]string
+}{
+	{
+		version: 1,
+		rules: [][]string{
+			// Admin policies
+			{"admin", GlobalDomain, "/api/admin/*", "*"},
+			{"admin", GlobalDomain, "/api/users", "*"},
+			{"admin", GlobalDomain, "/api/users/*", "*"},
+			{"admin", GlobalDomain, "/api/roles", "*"},
+			{"admin", GlobalDomain, "/api/roles/*", "*"},
+			{"admin", GlobalDomain, "/api/policies", "*"},
+			{"admin", GlobalDomain, "/api/policies/*", "*"},
+			{"admin", GlobalDomain, "/api/permissions", "*"},
+			{"admin", GlobalDomain, "/api/permissions/*", "*"},
+			{"admin", GlobalDomain, "/admin/*", "*"},
+
+			// User policies
+			{"user", GlobalDomain, "/api/users", "GET"},
+			{"user", GlobalDomain, "/api/users/:id", "GET"},
+			{"user", GlobalDomain, "/api/profile", "GET"},
+			{"user", GlobalDomain, "/api/profile", "PUT"},
+
+			// Guest (unauthenticated) policies
+			{"guest", GlobalDomain, "/api/auth/login", "POST"},
+			{"guest", GlobalDomain, "/api/auth/register", "POST"},
+			{"guest", GlobalDomain, "/", "GET"},
+			{"guest", GlobalDomain, "/login", "GET"},
+			{"guest", GlobalDomain, "/register", "GET"},
+			{"guest", GlobalDomain, "/static/*", "GET"},
+		},
+	},
+	{
+		version: 2,
+		rules: [][]string{
+			{"guest", GlobalDomain, "/api/auth/refresh", "POST"},
+			{"user", GlobalDomain, "/api/auth/logout", "POST"},
+			{"admin", GlobalDomain, "/api/auth/logout", "POST"},
+			{"guest", GlobalDomain, "/api/auth/mfa", "POST"},
+			{"guest", GlobalDomain, "/api/auth/mfa/enroll", "POST"},
+			{"user", GlobalDomain, "/api/profile/mfa", "DELETE"},
+			{"user", GlobalDomain, "/api/profile/mfa/*", "POST"},
+			{"admin", GlobalDomain, "/api/profile/mfa", "DELETE"},
+			{"admin", GlobalDomain, "/api/profile/mfa/*", "POST"},
+			{"user", GlobalDomain, "/oauth/scopes/openid", "grant"},
+			{"user", GlobalDomain, "/oauth/scopes/profile", "grant"},
+			{"user", GlobalDomain, "/oauth/scopes/email", "grant"},
+			{"admin", GlobalDomain, "/oauth/scopes/*", "grant"},
+			{"user", GlobalDomain, "/api/keys", "*"},
+			{"user", GlobalDomain, "/api/keys/*", "*"},
+			{"admin", GlobalDomain, "/api/keys", "*"},
+			{"admin", GlobalDomain, "/api/keys/*", "*"},
+			{"guest", GlobalDomain, "/api/auth/verify-email", "POST"},
+			{"guest", GlobalDomain, "/api/auth/password/forgot", "POST"},
+			{"guest", GlobalDomain, "/api/auth/password/reset", "POST"},
+			{UnverifiedRole, GlobalDomain, "/api/profile", "GET"},
+			{UnverifiedRole, GlobalDomain, "/api/profile", "PUT"},
+			{UnverifiedRole, GlobalDomain, "/api/auth/logout", "POST"},
+			{UnverifiedRole, GlobalDomain, "/api/auth/verify-email/resend", "POST"},
+			{"user", GlobalDomain, "/api/auth/verify-email/resend", "POST"},
+			{"admin", GlobalDomain, "/api/auth/verify-email/resend", "POST"},
+			{"user", GlobalDomain, "/api/orgs", "GET"},
+			{"user", GlobalDomain, "/api/auth/switch-org", "POST"},
+			{"admin", GlobalDomain, "/api/orgs", "*"},
+			{"admin", GlobalDomain, "/api/orgs/*", "*"},
+			{"admin", GlobalDomain, "/api/auth/switch-org", "POST"},
+			{OrgMemberRole, GlobalDomain, "/api/orgs/:org", "GET"},
+			{OrgMemberRole, GlobalDomain, "/api/orgs/:org/members", "GET"},
+			{OrgAdminRole, GlobalDomain, "/api/orgs/:org", "GET"},
+			{OrgAdminRole, GlobalDomain, "/api/orgs/:org/*", "*"},
+			{"user", GlobalDomain, "/api/grants", "GET"},
+			{"user", GlobalDomain, "/api/grants", "POST"},
+			{"admin", GlobalDomain, "/api/grants", "*"},
+			{"admin", GlobalDomain, "/api/grants/*", "*"},
+			{"user", GlobalDomain, "/api/profile/sessions", "GET"},
+			{"user", GlobalDomain, "/api/profile/sessions", "DELETE"},
+			{"user", GlobalDomain, "/api/profile/sessions/:id", "DELETE"},
+			{"admin", GlobalDomain, "/api/profile/sessions", "GET"},
+			{"admin", GlobalDomain, "/api/profile/sessions", "DELETE"},
+			{"admin", GlobalDomain, "/api/profile/sessions/:id", "DELETE"},
+			{"admin", GlobalDomain, "/scim/v2/*", "*"},
+			{SCIMProvisionerRole, GlobalDomain, "/scim/v2/*", "*"},
+			{OnCallRole, GlobalDomain, "/api/grants/break-glass", "POST"},
+		},
+	},
+}
+
+// seedPolicies adds the seeds not yet recorded in the database
+func (s *Service) seedPolicies() error {
+	var applied []int
+	if err := s.db.Model(&store.PolicySeed{}).Pluck("version", &applied).Error; err != nil {
+		return err
+	}
+
+	// Databases seeded before the seeds were recorded have the first one
+	if len(applied) == 0 {
+		policies, err := s.Enforcer.GetPolicy()
+		if err != nil {
+			return err
+		}
+		if len(policies) > 0 {
+			applied = append(applied, policySeeds[0].version)
+			if err := s.db.Create(&store.PolicySeed{Version: policySeeds[0].version, AppliedAt: time.Now()}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, seed := range policySeeds {
+		if slices.Contains(applied, seed.version) {
+			continue
+		}
+		for _, rule := range seed.rules {
+			if _, err := s.Enforcer.AddPolicy(rule); err != nil {
+				return err
+			}
+		}
+		if err := s.db.Create(&store.PolicySeed{Version: seed.version, AppliedAt: time.Now()}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+
+	"iam/store"
+)
+
+// PolicySet is the declarative authorization configuration: the roles
+// table, the Casbin p rules and the g rules between roles. Which roles a
+// user holds, directly, through a grant or in an organization, is state
+// managed through the API and not part of it. A nil section is left alone
+// on import, so a file can manage only some of them.
+type PolicySet struct {
+	Roles     []string   `json:"roles" yaml:"roles"`
+	Policies  [][]string `json:"policies" yaml:"policies"`   // sub, dom, obj, act
+	Groupings [][]string `json:"groupings" yaml:"groupings"` // role, parent role, dom
+}
+
+// PolicyDiff lists the changes that turn the current policy set into the
+// desired one
+type PolicyDiff struct {
+	AddRoles        []string   `json:"add_roles" yaml:"add_roles"`
+	RemoveRoles     []string   `json:"remove_roles" yaml:"remove_roles"`
+	AddPolicies     [][]string `json:"add_policies" yaml:"add_policies"`
+	RemovePolicies  [][]string `json:"remove_policies" yaml:"remove_policies"`
+	AddGroupings    [][]string `json:"add_groupings" yaml:"add_groupings"`
+	RemoveGroupings [][]string `json:"remove_groupings" yaml:"remove_groupings"`
+}
+
+// Empty reports whether the diff has no changes
+func (d PolicyDiff) Empty() bool {
+	return len(d.AddRoles) == 0 && len(d.RemoveRoles) == 0 &&
+		len(d.AddPolicies) == 0 && len(d.RemovePolicies) == 0 &&
+		len(d.AddGroupings) == 0 && len(d.RemoveGroupings) == 0
+}
+
+// Policy file formats
+const (
+	PolicyFormatYAML = "yaml"
+	PolicyFormatCSV  = "csv"
+)
+
+// ExportPolicySet returns the current roles and rules in a stable order
+func (s *Service) ExportPolicySet() (PolicySet, error) {
+	var roles []store.Role
+	if err := s.db.Order("name").Find(&roles).Error; err != nil {
+		return PolicySet{}, err
+	}
+	policies, err := s.Enforcer.GetPolicy()
+	if err != nil {
+		return PolicySet{}, err
+	}
+	groupings, err := s.Enforcer.GetGroupingPolicy()
+	if err != nil {
+		return PolicySet{}, err
+	}
+
+	// Casbin returns its own slices, so the filter copies
+	var roleGroupings [][]string
+	for _, rule := range groupings {
+		if !userGrouping(rule) {
+			roleGroupings = append(roleGroupings, rule)
+		}
+	}
+
+	set := PolicySet{
+		Roles:     make([]string, 0, len(roles)),
+		Policies:  sortedRules(policies),
+		Groupings: sortedRules(roleGroupings),
+	}
+	for _, role := range roles {
+		set.Roles = append(set.Roles, role.Name)
+	}
+	return set, nil
+}
+
+// userGrouping reports whether a g rule assigns a role to a user rather
+// than making one role inherit another
+func userGrouping(rule []string) bool {
+	return len(rule) > 0 && strings.HasPrefix(rule[0], UserSubjectPrefix)
+}
+
+func sortedRules(rules [][]string) [][]string {
+	if len(rules) == 0 {
+		return nil
+	}
+	sorted := make([][]string, len(rules))
+	copy(sorted, rules)
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.Join(sorted[i], "\x00") < strings.Join(sorted[j], "\x00")
+	})
+	return sorted
+}
+
+// diffRules returns the rules only in desired and the rules only in current
+func diffRules(current, desired [][]string) (add, remove [][]string) {
+	key := func(rule []string) string { return strings.Join(rule, "\x00") }
+
+	have := make(map[string]bool, len(current))
+	for _, rule := range current {
+		have[key(rule)] = true
+	}
+	want := make(map[string]bool, len(desired))
+	for _, rule := range desired {
+		want[key(rule)] = true
+		if !have[key(rule)] {
+			add = append(add, rule)
+		}
+	}
+	for _, rule := range current {
+		if !want[key(rule)] {
+			remove = append(remove, rule)
+		}
+	}
+	return sortedRules(add), sortedRules(remove)
+}
+
+// DiffPolicySet compares the current policy set with a desired one
+func (s *Service) DiffPolicySet(desired PolicySet) (PolicyDiff, error) {
+	current, err := s.ExportPolicySet()
+	if err != nil {
+		return PolicyDiff{}, err
+	}
+
+	var diff PolicyDiff
+	if desired.Roles != nil {
+		toRules := func(names []string) [][]string {
+			rules := make([][]string, len(names))
+			for i, name := range names {
+				rules[i] = []string{name}
+			}
+			return rules
+		}
+		add, remove := diffRules(toRules(current.Roles), toRules(desired.Roles))
+		for _, rule := range add {
+			diff.AddRoles = append(diff.AddRoles, rule[0])
+		}
+		for _, rule := range remove {
+			diff.RemoveRoles = append(diff.RemoveRoles, rule[0])
+		}
+	}
+	if desired.Policies != nil {
+		diff.AddPolicies, diff.RemovePolicies = diffRules(current.Policies, desired.Policies)
+	}
+	if desired.Groupings != nil {
+		diff.AddGroupings, diff.RemoveGroupings = diffRules(current.Groupings, desired.Groupings)
+	}
+	return diff, nil
+}
+
+// ApplyPolicyDiff makes the changes of a diff. Roles still assigned to
+// users are not removed, and groupings of users are not touched.
+func (s *Service) ApplyPolicyDiff(diff PolicyDiff) error {
+	for _, rule := range slices.Concat(diff.AddGroupings, diff.RemoveGroupings) {
+		if userGrouping(rule) {
+			return fmt.Errorf("grouping %v assigns a role to a user, which is not managed by policy files", rule)
+		}
+	}
+	for _, name := range diff.RemoveRoles {
+		var userCount int64
+		s.db.Model(&store.User{}).Where("role = ?", name).Count(&userCount)
+		if userCount > 0 {
+			return fmt.Errorf("role %q is in use by users", name)
+		}
+	}
+
+	if len(diff.RemovePolicies) > 0 {
+		if _, err := s.Enforcer.RemovePolicies(diff.RemovePolicies); err != nil {
+			return err
+		}
+	}
+	if len(diff.RemoveGroupings) > 0 {
+		if _, err := s.Enforcer.RemoveGroupingPolicies(diff.RemoveGroupings); err != nil {
+			return err
+		}
+	}
+	if len(diff.AddPolicies) > 0 {
+		if _, err := s.Enforcer.AddPolicies(diff.AddPolicies); err != nil {
+			return err
+		}
+	}
+	if len(diff.AddGroupings) > 0 {
+		if _, err := s.Enforcer.AddGroupingPolicies(diff.AddGroupings); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range diff.AddRoles {
+		if err := s.db.Create(&store.Role{Name: name}).Error; err != nil {
+			return err
+		}
+	}
+	if len(diff.RemoveRoles) > 0 {
+		if err := s.db.Where("name IN ?", diff.RemoveRoles).Delete(&store.Role{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParsePolicySet reads a policy set in YAML or CSV. The CSV format uses
+// Casbin's policy lines plus "role, <name>" lines for the roles table.
+func ParsePolicySet(data []byte, format string) (PolicySet, error) {
+	var set PolicySet
+	switch format {
+	case PolicyFormatYAML:
+		if err := yaml.Unmarshal(data, &set); err != nil {
+			return PolicySet{}, err
+		}
+	case PolicyFormatCSV:
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return PolicySet{}, err
+			}
+
+			switch record[0] {
+			case "role":
+				set.Roles = append(set.Roles, record[1:]...)
+			case "p":
+				set.Policies = append(set.Policies, record[1:])
+			case "g":
+				set.Groupings = append(set.Groupings, record[1:])
+			default:
+				return PolicySet{}, fmt.Errorf("unknown rule type %q", record[0])
+			}
+		}
+	default:
+		return PolicySet{}, fmt.Errorf("unknown format %q, expected yaml or csv", format)
+	}
+
+	for _, rule := range set.Policies {
+		if len(rule) != 4 {
+			return PolicySet{}, fmt.Errorf("policy %v must be subject, domain, object, action", rule)
+		}
+	}
+	for _, rule := range set.Groupings {
+		if len(rule) != 3 {
+			return PolicySet{}, fmt.Errorf("grouping %v must be role, parent role, domain", rule)
+		}
+		if userGrouping(rule) {
+			return PolicySet{}, fmt.Errorf("grouping %v assigns a role to a user, which is not managed by policy files", rule)
+		}
+	}
+	return set, nil
+}
+
+// FormatPolicySet writes a policy set in YAML or CSV
+func FormatPolicySet(set PolicySet, format string) ([]byte, error) {
+	switch format {
+	case PolicyFormatYAML:
+		return yaml.Marshal(set)
+	case PolicyFormatCSV:
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		for _, name := range set.Roles {
+			writer.Write([]string{"role", name})
+		}
+		for _, rule := range set.Policies {
+			writer.Write(append([]string{"p"}, rule...))
+		}
+		for _, rule := range set.Groupings {
+			writer.Write(append([]string{"g"}, rule...))
+		}
+		writer.Flush()
+		return buf.Bytes(), writer.Error()
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected yaml or csv", format)
+	}
+}
+
+// PolicyFormatOf infers the format of a policy file from its extension
+func PolicyFormatOf(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return PolicyFormatCSV
+	}
+	return PolicyFormatYAML
+}
+
+// ReconcilePolicyFile compares the database with a policy file. With
+// enforce it applies the differences, otherwise it only reports the drift.
+func (s *Service) ReconcilePolicyFile(path string, enforce bool) (PolicyDiff, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PolicyDiff{}, err
+	}
+	desired, err := ParsePolicySet(data, PolicyFormatOf(path))
+	if err != nil {
+		return PolicyDiff{}, err
+	}
+	diff, err := s.DiffPolicySet(desired)
+	if err != nil || diff.Empty() {
+		return diff, err
+	}
+
+	if !enforce {
+		s.store.AuditSystem("policy.drift", path, store.AuditFailure, nil, diff)
+		return diff, nil
+	}
+
+	if err := s.ApplyPolicyDiff(diff); err != nil {
+		return diff, err
+	}
+	s.store.AuditSystem("policy.sync", path, store.AuditSuccess, nil, diff)
+	return diff, nil
+}
+
+// WatchPolicyFile reconciles the policy file now and on every SIGHUP. With
+// enforce it applies the file, otherwise it only logs the drift.
+func (s *Service) WatchPolicyFile(path string, enforce bool) {
+	reconcile := func() {
+		diff, err := s.ReconcilePolicyFile(path, enforce)
+		switch {
+		case err != nil:
+			log.Printf("Policy sync with %s failed: %v", path, err)
+		case diff.Empty():
+			log.Printf("Policies are in sync with %s", path)
+		case enforce:
+			log.Printf("Applied policy changes from %s: %s", path, summarizeDiff(diff))
+		default:
+			log.Printf("Policies drifted from %s: %s", path, summarizeDiff(diff))
+		}
+	}
+	reconcile()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			reconcile()
+		}
+	}()
+}
+
+func summarizeDiff(diff PolicyDiff) string {
+	return fmt.Sprintf("+%d/-%d roles, +%d/-%d policies, +%d/-%d groupings",
+		len(diff.AddRoles), len(diff.RemoveRoles),
+		len(diff.AddPolicies), len(diff.RemovePolicies),
+		len(diff.AddGroupings), len(diff.RemoveGroupings))
+}
@@ -0,0 +1,372 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"sort"
+	"time"
+)
+
+// The schema is versioned by the migrations below, applied in order and
+// recorded in schema_migrations. Migrations create tables from the
+// snapshots in schema.go, so a change to a model needs a new migration and
+// snapshot adding or altering its columns. AutoMigrate only adds what is
+// missing, which also brings databases created before versioning up to
+// the first versions.
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// MigrationStatus describes a migration known to this build or recorded
+// in the database. Unknown migrations were applied by a newer build.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	Unknown   bool
+}
+
+// migration is one reversible schema change
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+	down    func(tx *gorm.DB) error
+}
+
+// ErrSchemaTooNew is returned for a database migrated by a newer build
+var ErrSchemaTooNew = errors.New("database schema is newer than this build")
+
+// createTables returns a migration step creating tables for models
+func createTables(models ...interface{}) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.AutoMigrate(models...)
+	}
+}
+
+// dropTables returns a migration step dropping the tables of models
+func dropTables(models ...interface{}) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(models...)
+	}
+}
+
+// createRuleTable creates a Casbin rule table with the unique index the
+// gorm adapter expects
+func createRuleTable(tx *gorm.DB, table string) error {
+	if err := tx.Table(table).AutoMigrate(&casbinRuleV2{}); err != nil {
+		return err
+	}
+	index := "idx_" + table
+	if tx.Migrator().HasIndex(table, index) {
+		return nil
+	}
+	return tx.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (ptype,v0,v1,v2,v3,v4,v5)", index, table)).Error
+}
+
+// migrateDomainPolicies moves policies stored before organizations existed
+// into the global domain, as part of the casbin_rule migration
+func migrateDomainPolicies(tx *gorm.DB) error {
+	const globalDomain = "*"
+
+	// p, sub, obj, act becomes p, sub, *, obj, act
+	if err := tx.Exec("UPDATE casbin_rule SET v3 = v2, v2 = v1, v1 = ? WHERE ptype = ? AND v3 = ?",
+		globalDomain, "p", "").Error; err != nil {
+		return err
+	}
+
+	// g, user, role becomes g, user, role, *
+	return tx.Table("casbin_rule").
+		Where("ptype = ? AND v2 = ?", "g", "").
+		Update("v2", globalDomain).Error
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create_users_and_roles",
+		up: func(tx *gorm.DB) error {
+			// Accounts created before email verification existed count as verified
+			backfillVerified := tx.Migrator().HasTable(&userV1{}) && !tx.Migrator().HasColumn(&userV1{}, "email_verified")
+
+			if err := tx.AutoMigrate(&userV1{}, &roleV1{}); err != nil {
+				return err
+			}
+			if backfillVerified {
+				return tx.Model(&userV1{}).Where("1 = 1").Update("email_verified", true).Error
+			}
+			return nil
+		},
+		down: dropTables(&roleV1{}, &userV1{}),
+	},
+	{
+		version: 2,
+		name:    "create_casbin_rules",
+		up: func(tx *gorm.DB) error {
+			if err := createRuleTable(tx, "casbin_rule"); err != nil {
+				return err
+			}
+			return migrateDomainPolicies(tx)
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("casbin_rule")
+		},
+	},
+	{
+		version: 3,
+		name:    "create_token_tables",
+		up:      createTables(&refreshTokenV3{}, &revokedTokenV3{}, &signingKeyV3{}, &recoveryCodeV3{}),
+		down:    dropTables(&recoveryCodeV3{}, &signingKeyV3{}, &revokedTokenV3{}, &refreshTokenV3{}),
+	},
+	{
+		version: 4,
+		name:    "create_oauth_tables",
+		up:      createTables(&oauthClientV4{}, &authorizationCodeV4{}, &oauthConsentV4{}),
+		down:    dropTables(&oauthConsentV4{}, &authorizationCodeV4{}, &oauthClientV4{}),
+	},
+	{
+		version: 5,
+		name:    "create_api_keys_and_invitations",
+		up:      createTables(&apiKeyV5{}, &invitationV5{}),
+		down:    dropTables(&invitationV5{}, &apiKeyV5{}),
+	},
+	{
+		version: 6,
+		name:    "create_audit_and_login_attempts",
+		up:      createTables(&auditEventV6{}, &loginAttemptV6{}),
+		down:    dropTables(&loginAttemptV6{}, &auditEventV6{}),
+	},
+	{
+		version: 7,
+		name:    "create_organizations",
+		up:      createTables(&organizationV7{}),
+		down:    dropTables(&organizationV7{}),
+	},
+	{
+		version: 8,
+		name:    "create_role_grants",
+		up:      createTables(&roleGrantV8{}),
+		down:    dropTables(&roleGrantV8{}),
+	},
+	{
+		version: 9,
+		name:    "create_user_sessions",
+		up:      createTables(&userSessionV9{}),
+		down:    dropTables(&userSessionV9{}),
+	},
+	{
+		version: 10,
+		name:    "create_abac_rules",
+		up: func(tx *gorm.DB) error {
+			return createRuleTable(tx, "abac_rule")
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("abac_rule")
+		},
+	},
+	{
+		version: 11,
+		name:    "create_password_history",
+		up:      createTables(&passwordHistoryV11{}),
+		down:    dropTables(&passwordHistoryV11{}),
+	},
+	{
+		version: 12,
+		name:    "add_audit_impersonator",
+		up: func(tx *gorm.DB) error {
+			// Earlier builds created the column with the table in version 6
+			if !tx.Migrator().HasColumn(&auditEventV12{}, "Impersonator") {
+				if err := tx.Migrator().AddColumn(&auditEventV12{}, "Impersonator"); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&auditEventV12{}, "Impersonator") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&auditEventV12{}, "Impersonator")
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&auditEventV12{}, "Impersonator"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropColumn(&auditEventV12{}, "Impersonator"); err != nil {
+				return err
+			}
+			// SQLite drops a column by rebuilding the table without its indexes
+			return tx.AutoMigrate(&auditEventV6{})
+		},
+	},
+	{
+		version: 13,
+		name:    "create_webhooks",
+		up:      createTables(&webhookV13{}, &webhookDeliveryV13{}),
+		down:    dropTables(&webhookDeliveryV13{}, &webhookV13{}),
+	},
+	{
+		version: 14,
+		name:    "unique_active_signing_key",
+		up: func(tx *gorm.DB) error {
+			// Instances starting together could each create an active key.
+			// Keep the newest and retire the others with the default retention.
+			var newest signingKeyV3
+			err := tx.Where("active = ?", true).Order("id DESC").Limit(1).Find(&newest).Error
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			if err := tx.Model(&signingKeyV3{}).Where("active = ? AND id <> ?", true, newest.ID).Updates(map[string]interface{}{
+				"active":      false,
+				"private_key": "",
+				"retired_at":  now,
+				"expires_at":  now.Add(24 * time.Hour),
+			}).Error; err != nil {
+				return err
+			}
+
+			// MySQL has no partial indexes but leaves NULLs out of unique ones
+			if tx.Dialector.Name() == "mysql" {
+				return tx.Exec("CREATE UNIQUE INDEX idx_signing_keys_active ON signing_keys ((IF(active, 1, NULL)))").Error
+			}
+			return tx.Exec("CREATE UNIQUE INDEX idx_signing_keys_active ON signing_keys (active) WHERE active").Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropIndex(&signingKeyV3{}, "idx_signing_keys_active")
+		},
+	},
+	{
+		version: 15,
+		name:    "create_policy_seeds",
+		up:      createTables(&policySeedV15{}),
+		down:    dropTables(&policySeedV15{}),
+	},
+}
+
+// LatestSchemaVersion is the version this build expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the highest applied migration, 0 for a new database
+func (s *Store) SchemaVersion() (int, error) {
+	if err := s.DB.AutoMigrate(&SchemaMigration{}); err != nil {
+		return 0, err
+	}
+	var version int
+	err := s.DB.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// MigrateUp applies the pending migrations up to and including target
+func (s *Store) MigrateUp(target int) error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("%w: version %d, this build supports up to %d", ErrSchemaTooNew, current, LatestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current || m.version > target {
+			continue
+		}
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+		}
+		log.Printf("Applied migration %d %s", m.version, m.name)
+	}
+	return nil
+}
+
+// MigrateDown reverts the applied migrations above target, newest first
+func (s *Store) MigrateDown(target int) error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("%w: version %d, this build supports up to %d", ErrSchemaTooNew, current, LatestSchemaVersion())
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > current || m.version <= target {
+			continue
+		}
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d %s: %w", m.version, m.name, err)
+		}
+		log.Printf("Reverted migration %d %s", m.version, m.name)
+	}
+	return nil
+}
+
+// CheckSchema runs at startup. It refuses a schema newer than this build
+// and applies pending migrations if autoMigrate is set.
+func (s *Store) CheckSchema(autoMigrate bool) error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	latest := LatestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("%w: version %d, this build supports up to %d", ErrSchemaTooNew, current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+	if !autoMigrate {
+		return fmt.Errorf("database schema is at version %d, run the migrate command to upgrade to %d", current, latest)
+	}
+	return s.MigrateUp(latest)
+}
+
+// MigrationStatus lists every migration with the time it was applied
+func (s *Store) MigrationStatus() ([]MigrationStatus, error) {
+	if _, err := s.SchemaVersion(); err != nil {
+		return nil, err
+	}
+	var applied []SchemaMigration
+	if err := s.DB.Order("version").Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	recorded := make(map[int]SchemaMigration, len(applied))
+	for _, a := range applied {
+		recorded[a.Version] = a
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if a, ok := recorded[m.version]; ok {
+			status.AppliedAt = &a.AppliedAt
+			delete(recorded, m.version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	unknown := make([]MigrationStatus, 0, len(recorded))
+	for _, a := range recorded {
+		unknown = append(unknown, MigrationStatus{Version: a.Version, Name: a.Name, AppliedAt: &a.AppliedAt, Unknown: true})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(statuses, unknown...), nil
+}
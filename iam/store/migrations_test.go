@@ -0,0 +1,159 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// openTestStore opens a private in-memory SQLite database
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	st, err := Open("sqlite", "file:"+strings.ReplaceAll(t.Name(), "/", "_")+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := st.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return st
+}
+
+// schema describes the tables, columns and indexes of the database
+func schema(t *testing.T, st *Store) []string {
+	t.Helper()
+	var objects []struct {
+		Type, Name, TblName string
+		SQL                 *string
+	}
+	if err := st.DB.Raw("SELECT type, name, tbl_name, sql FROM sqlite_master WHERE name NOT LIKE 'sqlite_%'").
+		Scan(&objects).Error; err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+
+	var described []string
+	for _, object := range objects {
+		if object.Type == "index" {
+			sql := ""
+			if object.SQL != nil {
+				sql = *object.SQL
+			}
+			described = append(described, fmt.Sprintf("index %s on %s: %s", object.Name, object.TblName, sql))
+			continue
+		}
+
+		var columns []struct {
+			Name, Type string
+			NotNull    bool
+			PK         int
+		}
+		if err := st.DB.Raw(fmt.Sprintf("SELECT name, type, \"notnull\" AS not_null, pk FROM pragma_table_info('%s')", object.Name)).
+			Scan(&columns).Error; err != nil {
+			t.Fatalf("read columns of %s: %v", object.Name, err)
+		}
+		for _, column := range columns {
+			described = append(described, fmt.Sprintf("%s %s.%s %s notnull=%t pk=%d", object.Type, object.Name, column.Name, column.Type, column.NotNull, column.PK))
+		}
+	}
+	slices.Sort(described)
+	return described
+}
+
+// schemaDiff lists what only one of two schemas has
+func schemaDiff(got, want []string) string {
+	var diff []string
+	for _, entry := range got {
+		if !slices.Contains(want, entry) {
+			diff = append(diff, "+ "+entry)
+		}
+	}
+	for _, entry := range want {
+		if !slices.Contains(got, entry) {
+			diff = append(diff, "- "+entry)
+		}
+	}
+	return strings.Join(diff, "\n")
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	st := openTestStore(t)
+	latest := LatestSchemaVersion()
+	if err := st.MigrateUp(latest); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	want := schema(t, st)
+
+	for _, target := range []int{11, 5, 0} {
+		if err := st.MigrateDown(target); err != nil {
+			t.Fatalf("migrate down to %d: %v", target, err)
+		}
+		if err := st.MigrateUp(latest); err != nil {
+			t.Fatalf("migrate up from %d: %v", target, err)
+		}
+		if got := schema(t, st); !slices.Equal(got, want) {
+			t.Errorf("schema after down to %d and up differs:\n%s", target, schemaDiff(got, want))
+		}
+	}
+}
+
+func TestMigrationVersionsAreFixed(t *testing.T) {
+	st := openTestStore(t)
+	if err := st.MigrateUp(6); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	if st.DB.Migrator().HasColumn("audit_events", "impersonator") {
+		t.Error("version 6 has the impersonator column of version 12")
+	}
+
+	// The models match the schema the migrations build
+	if err := st.MigrateUp(LatestSchemaVersion()); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	want := schema(t, st)
+	if err := st.DB.AutoMigrate(&User{}, &Role{}, &RefreshToken{}, &RevokedToken{}, &SigningKey{}, &RecoveryCode{},
+		&OAuthClient{}, &AuthorizationCode{}, &OAuthConsent{}, &APIKey{}, &Invitation{}, &AuditEvent{}, &LoginAttempt{},
+		&Organization{}, &RoleGrant{}, &UserSession{}, &PasswordHistory{}, &Webhook{}, &WebhookDelivery{}, &PolicySeed{}); err != nil {
+		t.Fatalf("auto migrate models: %v", err)
+	}
+	if got := schema(t, st); !slices.Equal(got, want) {
+		t.Errorf("models differ from the migrated schema:\n%s", schemaDiff(got, want))
+	}
+}
+
+func TestSingleActiveSigningKey(t *testing.T) {
+	st := openTestStore(t)
+	if err := st.MigrateUp(13); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	// Keys left active by instances racing before version 14
+	for _, kid := range []string{"first", "second"} {
+		if err := st.DB.Create(&SigningKey{KID: kid, Active: true, PrivateKey: "private"}).Error; err != nil {
+			t.Fatalf("create key: %v", err)
+		}
+	}
+	if err := st.MigrateUp(LatestSchemaVersion()); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	var active []SigningKey
+	st.DB.Where("active = ?", true).Find(&active)
+	if len(active) != 1 || active[0].KID != "second" {
+		t.Errorf("active keys after migration: %+v, want only the newest", active)
+	}
+	var retired SigningKey
+	st.DB.Where(&SigningKey{KID: "first"}).First(&retired)
+	if retired.PrivateKey != "" || retired.RetiredAt == nil || retired.ExpiresAt == nil {
+		t.Errorf("older key not retired: %+v", retired)
+	}
+
+	if err := st.DB.Create(&SigningKey{KID: "third", Active: true}).Error; err == nil {
+		t.Error("created a second active key")
+	}
+	if err := st.DB.Create(&SigningKey{KID: "fourth"}).Error; err != nil {
+		t.Errorf("create retired key: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// User represents a user in our system
+type User struct {
+	gorm.Model
+	Username     string `json:"username" gorm:"uniqueIndex"`
+	PasswordHash string `json:"-"`
+	Email        string `json:"email" gorm:"uniqueIndex"`
+	Role         string `json:"role"`
+	TokenVersion uint   `json:"-"`
+	MFAEnabled   bool   `json:"mfa_enabled"`
+	TOTPSecret   string `json:"-"`
+	TOTPLastStep int64  `json:"-"`
+	// Service accounts have no password and authenticate with API keys
+	ServiceAccount  bool       `json:"service_account"`
+	EmailVerified   bool       `json:"email_verified"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	ActiveOrg       string     `json:"active_org"`
+	// Disabled users keep their data but cannot sign in
+	Disabled   bool   `json:"disabled"`
+	ExternalID string `json:"external_id" gorm:"index"` // set by SCIM provisioning
+}
+
+// Role represents a role in the system
+type Role struct {
+	ID   uint   `json:"id" gorm:"primaryKey"`
+	Name string `json:"name" gorm:"uniqueIndex"`
+}
+
+// Organization is a tenant. Its slug is the Casbin domain of the policies
+// and role assignments that only apply within the organization.
+type Organization struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Slug      string    `json:"slug" gorm:"uniqueIndex"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RoleGrant is a temporary role assignment. Users request one with a
+// reason, an approver accepts it and the role is assigned as a grouping
+// rule until the grant expires. Break-glass grants skip the approval and
+// are flagged for review instead.
+type RoleGrant struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	UserID       uint       `json:"user_id" gorm:"index"`
+	Username     string     `json:"username"`
+	Role         string     `json:"role"`
+	Reason       string     `json:"reason"`
+	Duration     int64      `json:"duration_seconds"`
+	Status       string     `json:"status" gorm:"index"`
+	BreakGlass   bool       `json:"break_glass"`
+	NeedsReview  bool       `json:"needs_review"`
+	DecidedByID  *uint      `json:"decided_by_id"`
+	DecidedAt    *time.Time `json:"decided_at"`
+	ExpiresAt    *time.Time `json:"expires_at" gorm:"index"`
+	ReviewedByID *uint      `json:"reviewed_by_id"`
+	ReviewedAt   *time.Time `json:"reviewed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// RefreshToken is an opaque, single-use token that can be exchanged for a new
+// access token. Only the SHA-256 hash of the token is stored. Tokens obtained
+// by rotating each other share a FamilyID so that reuse of an already rotated
+// token can revoke the whole chain. Tokens issued to OAuth clients record
+// the client and the granted scope.
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index"`
+	FamilyID  string     `json:"family_id" gorm:"index"`
+	ClientID  string     `json:"client_id" gorm:"index"`
+	Scope     string     `json:"scope"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RevokedToken is an entry in the access token revocation list
+type RevokedToken struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	JTI       string    `json:"jti" gorm:"uniqueIndex"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SigningKey is a key pair used to sign JWTs. Exactly one key is active and
+// used for signing, which a unique index on active keys enforces; retired keys are kept for verification until ExpiresAt so
+// that tokens signed before a rotation stay valid.
+type SigningKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	KID        string     `json:"kid" gorm:"uniqueIndex"`
+	Algorithm  string     `json:"alg"`
+	PrivateKey string     `json:"-"` // PKCS #8, PEM encoded
+	PublicKey  string     `json:"-"` // PKIX, PEM encoded
+	Active     bool       `json:"active"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RetiredAt  *time.Time `json:"retired_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// RecoveryCode is a single-use code that can replace a TOTP code when the
+// user has lost their authenticator. Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index"`
+	CodeHash  string     `json:"-" gorm:"uniqueIndex"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PasswordHistory keeps a replaced password hash, so the password policy
+// can refuse recently used passwords
+type PasswordHistory struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	Hash      string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OAuthClient is an application registered to use iam as its identity provider
+type OAuthClient struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ClientID     string    `json:"client_id" gorm:"uniqueIndex"`
+	SecretHash   string    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs string    `json:"redirect_uris"` // space separated
+	GrantTypes   string    `json:"grant_types"`   // space separated
+	Scopes       string    `json:"scopes"`        // space separated scopes the client may request
+	Role         string    `json:"role"`          // role of client credentials tokens
+	Public       bool      `json:"public"`        // public clients have no secret and must use PKCE
+	Trusted      bool      `json:"trusted"`       // trusted first-party clients skip the consent screen
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// AuthorizationCode is a single-use code issued by the authorize endpoint.
+// Only the hash of the code is stored.
+type AuthorizationCode struct {
+	ID                  uint       `json:"id" gorm:"primaryKey"`
+	CodeHash            string     `json:"-" gorm:"uniqueIndex"`
+	ClientID            string     `json:"client_id" gorm:"index"`
+	UserID              uint       `json:"user_id"`
+	RedirectURI         string     `json:"redirect_uri"`
+	Scope               string     `json:"scope"`
+	Nonce               string     `json:"nonce"`
+	CodeChallenge       string     `json:"-"`
+	CodeChallengeMethod string     `json:"-"`
+	AuthTime            time.Time  `json:"auth_time"`
+	ExpiresAt           time.Time  `json:"expires_at"`
+	UsedAt              *time.Time `json:"used_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// OAuthConsent remembers the scopes a user granted to a client
+type OAuthConsent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:idx_consent_user_client"`
+	ClientID  string    `json:"client_id" gorm:"uniqueIndex:idx_consent_user_client"`
+	Scope     string    `json:"scope"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// APIKey is a long-lived credential for machine clients. The key is shown
+// once on creation; only its prefix, used for lookup, and the hash of its
+// secret are stored.
+type APIKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix" gorm:"uniqueIndex"`
+	SecretHash string     `json:"-"`
+	Scopes     string     `json:"scopes"` // space separated, empty for the owner's full access
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Invitation lets an admin grant a role other than the default role to a
+// user who has not registered yet. The invitation link carries a signed
+// token that identifies the invitation.
+type Invitation struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	Email        string     `json:"email" gorm:"index"`
+	Role         string     `json:"role"`
+	InvitedByID  uint       `json:"invited_by_id"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	AcceptedAt   *time.Time `json:"accepted_at"`
+	AcceptedByID *uint      `json:"accepted_by_id"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// LoginAttempt tracks failed logins for a username or a client IP. Keys
+// look like "user:<username>" or "ip:<address>".
+type LoginAttempt struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	Key           string     `json:"key" gorm:"column:attempt_key;uniqueIndex"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// UserSession is a server-side browser session. The cookie only carries a
+// signed random token and the session ID is the token's SHA-256 hash, so
+// session IDs shown to users or stored in the backend cannot be replayed.
+type UserSession struct {
+	ID         string    `json:"id" gorm:"primaryKey;size:64"`
+	UserID     uint      `json:"-" gorm:"index"`
+	Data       []byte    `json:"-"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"index"`
+}
+
+// Webhook is a subscription of a downstream system to lifecycle events.
+// Events holds the subscribed event types separated by spaces, where *
+// subscribes to every event. Payloads are signed with the secret.
+type Webhook struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      string    `json:"events"`
+	Secret      string    `json:"-"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one event queued for a webhook. Failed attempts are
+// retried at NextAttemptAt until the delivery succeeds or gives up.
+type WebhookDelivery struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	WebhookID     uint       `json:"webhook_id" gorm:"index"`
+	EventID       string     `json:"event_id" gorm:"index"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"payload"`
+	Status        string     `json:"status" gorm:"index"`
+	Attempts      int        `json:"attempts"`
+	ResponseCode  int        `json:"response_code"`
+	Error         string     `json:"error"`
+	NextAttemptAt *time.Time `json:"next_attempt_at" gorm:"index"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// PolicySeed records a set of built-in policies added to the database.
+// Each set is added once, so policies an admin removes stay removed.
+type PolicySeed struct {
+	Version   int       `json:"version" gorm:"primaryKey;autoIncrement:false"`
+	AppliedAt time.Time `json:"applied_at"`
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations create tables from the snapshots below, the models as they
+// were at the version introducing them, never from the models themselves.
+// That way a version always means the same schema, however the models
+// evolve. A change to a model gets a new migration with its own snapshot
+// of what changed.
+
+// Version 1
+
+type userV1 struct {
+	ID              uint `gorm:"primarykey"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       gorm.DeletedAt `gorm:"index"`
+	Username        string         `gorm:"uniqueIndex"`
+	PasswordHash    string
+	Email           string `gorm:"uniqueIndex"`
+	Role            string
+	TokenVersion    uint
+	MFAEnabled      bool
+	TOTPSecret      string
+	TOTPLastStep    int64
+	ServiceAccount  bool
+	EmailVerified   bool
+	EmailVerifiedAt *time.Time
+	ActiveOrg       string
+	Disabled        bool
+	ExternalID      string `gorm:"index"`
+}
+
+func (userV1) TableName() string { return "users" }
+
+type roleV1 struct {
+	ID   uint   `gorm:"primaryKey"`
+	Name string `gorm:"uniqueIndex"`
+}
+
+func (roleV1) TableName() string { return "roles" }
+
+// Version 2, also the shape of the ABAC rules of version 10
+
+type casbinRuleV2 struct {
+	ID    uint   `gorm:"primaryKey;autoIncrement"`
+	Ptype string `gorm:"size:100"`
+	V0    string `gorm:"size:100"`
+	V1    string `gorm:"size:100"`
+	V2    string `gorm:"size:100"`
+	V3    string `gorm:"size:100"`
+	V4    string `gorm:"size:100"`
+	V5    string `gorm:"size:100"`
+}
+
+// Version 3
+
+type refreshTokenV3 struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	FamilyID  string `gorm:"index"`
+	ClientID  string `gorm:"index"`
+	Scope     string
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+func (refreshTokenV3) TableName() string { return "refresh_tokens" }
+
+type revokedTokenV3 struct {
+	ID        uint   `gorm:"primaryKey"`
+	JTI       string `gorm:"uniqueIndex"`
+	Reason    string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+func (revokedTokenV3) TableName() string { return "revoked_tokens" }
+
+type signingKeyV3 struct {
+	ID         uint   `gorm:"primaryKey"`
+	KID        string `gorm:"uniqueIndex"`
+	Algorithm  string
+	PrivateKey string
+	PublicKey  string
+	Active     bool
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+	ExpiresAt  *time.Time
+}
+
+func (signingKeyV3) TableName() string { return "signing_keys" }
+
+type recoveryCodeV3 struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	CodeHash  string `gorm:"uniqueIndex"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (recoveryCodeV3) TableName() string { return "recovery_codes" }
+
+// Version 4
+
+type oauthClientV4 struct {
+	ID           uint   `gorm:"primaryKey"`
+	ClientID     string `gorm:"uniqueIndex"`
+	SecretHash   string
+	Name         string
+	RedirectURIs string
+	GrantTypes   string
+	Scopes       string
+	Role         string
+	Public       bool
+	Trusted      bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (oauthClientV4) TableName() string { return "o_auth_clients" }
+
+type authorizationCodeV4 struct {
+	ID                  uint   `gorm:"primaryKey"`
+	CodeHash            string `gorm:"uniqueIndex"`
+	ClientID            string `gorm:"index"`
+	UserID              uint
+	RedirectURI         string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	AuthTime            time.Time
+	ExpiresAt           time.Time
+	UsedAt              *time.Time
+	CreatedAt           time.Time
+}
+
+func (authorizationCodeV4) TableName() string { return "authorization_codes" }
+
+type oauthConsentV4 struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"uniqueIndex:idx_consent_user_client"`
+	ClientID  string `gorm:"uniqueIndex:idx_consent_user_client"`
+	Scope     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (oauthConsentV4) TableName() string { return "o_auth_consents" }
+
+// Version 5
+
+type apiKeyV5 struct {
+	ID         uint `gorm:"primaryKey"`
+	UserID     uint `gorm:"index"`
+	Name       string
+	Prefix     string `gorm:"uniqueIndex"`
+	SecretHash string
+	Scopes     string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	LastUsedIP string
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (apiKeyV5) TableName() string { return "api_keys" }
+
+type invitationV5 struct {
+	ID           uint   `gorm:"primaryKey"`
+	Email        string `gorm:"index"`
+	Role         string
+	InvitedByID  uint
+	ExpiresAt    time.Time
+	AcceptedAt   *time.Time
+	AcceptedByID *uint
+	RevokedAt    *time.Time
+	CreatedAt    time.Time
+}
+
+func (invitationV5) TableName() string { return "invitations" }
+
+// Version 6
+
+type auditEventV6 struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	ActorID   *uint     `gorm:"index"`
+	Actor     string    `gorm:"index"`
+	Action    string    `gorm:"index"`
+	Target    string    `gorm:"index"`
+	IP        string
+	UserAgent string
+	Result    string
+	Before    string
+	After     string
+	PrevHash  string `gorm:"uniqueIndex"`
+	Hash      string `gorm:"uniqueIndex"`
+}
+
+func (auditEventV6) TableName() string { return "audit_events" }
+
+type loginAttemptV6 struct {
+	ID            uint   `gorm:"primaryKey"`
+	Key           string `gorm:"column:attempt_key;uniqueIndex"`
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+func (loginAttemptV6) TableName() string { return "login_attempts" }
+
+// Version 7
+
+type organizationV7 struct {
+	ID        uint   `gorm:"primaryKey"`
+	Slug      string `gorm:"uniqueIndex"`
+	Name      string
+	CreatedAt time.Time
+}
+
+func (organizationV7) TableName() string { return "organizations" }
+
+// Version 8
+
+type roleGrantV8 struct {
+	ID           uint `gorm:"primaryKey"`
+	UserID       uint `gorm:"index"`
+	Username     string
+	Role         string
+	Reason       string
+	Duration     int64
+	Status       string `gorm:"index"`
+	BreakGlass   bool
+	NeedsReview  bool
+	DecidedByID  *uint
+	DecidedAt    *time.Time
+	ExpiresAt    *time.Time `gorm:"index"`
+	ReviewedByID *uint
+	ReviewedAt   *time.Time
+	CreatedAt    time.Time
+}
+
+func (roleGrantV8) TableName() string { return "role_grants" }
+
+// Version 9
+
+type userSessionV9 struct {
+	ID         string `gorm:"primaryKey;size:64"`
+	UserID     uint   `gorm:"index"`
+	Data       []byte
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time `gorm:"index"`
+}
+
+func (userSessionV9) TableName() string { return "user_sessions" }
+
+// Version 11
+
+type passwordHistoryV11 struct {
+	ID        uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"index"`
+	Hash      string
+	CreatedAt time.Time
+}
+
+func (passwordHistoryV11) TableName() string { return "password_histories" }
+
+// Version 12 adds a column to the audit events of version 6
+
+type auditEventV12 struct {
+	Impersonator string `gorm:"index"`
+}
+
+func (auditEventV12) TableName() string { return "audit_events" }
+
+// Version 13
+
+type webhookV13 struct {
+	ID          uint `gorm:"primaryKey"`
+	URL         string
+	Description string
+	Events      string
+	Secret      string
+	Active      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (webhookV13) TableName() string { return "webhooks" }
+
+type webhookDeliveryV13 struct {
+	ID            uint   `gorm:"primaryKey"`
+	WebhookID     uint   `gorm:"index"`
+	EventID       string `gorm:"index"`
+	Event         string
+	Payload       string
+	Status        string `gorm:"index"`
+	Attempts      int
+	ResponseCode  int
+	Error         string
+	NextAttemptAt *time.Time `gorm:"index"`
+	DeliveredAt   *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (webhookDeliveryV13) TableName() string { return "webhook_deliveries" }
+
+// Version 15
+
+type policySeedV15 struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	AppliedAt time.Time
+}
+
+func (policySeedV15) TableName() string { return "policy_seeds" }
@@ -0,0 +1,48 @@
+// Package store holds the iam data model, the database connection with its
+// versioned schema, and the tamper-evident audit log.
+package store
+
+import (
+	"fmt"
+	"sync"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Store is the database of an iam instance
+type Store struct {
+	DB *gorm.DB
+
+	// auditMu serializes appends so events are chained in order
+	auditMu sync.Mutex
+}
+
+// New wraps an open database connection
+func New(db *gorm.DB) *Store {
+	return &Store{DB: db}
+}
+
+// Open connects to a sqlite, postgres or mysql database
+func Open(driver, dsn string) (*Store, error) {
+	var dialector gorm.Dialector
+	switch driver {
+	case "sqlite":
+		dialector = sqlite.Open(dsn)
+	case "postgres":
+		dialector = postgres.Open(dsn)
+	case "mysql":
+		// Time columns need parseTime=true in the DSN
+		dialector = mysql.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q, use sqlite, postgres or mysql", driver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return New(db), nil
+}
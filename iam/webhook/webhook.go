@@ -0,0 +1,334 @@
+// Package webhook notifies downstream systems of identity lifecycle events.
+// Events are queued in the database for every subscribed webhook and
+// delivered as HMAC-signed JSON, retried with exponential backoff. The
+// queue doubles as the delivery log.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"iam/store"
+)
+
+// Event types
+const (
+	EventUserRegistered  = "user.registered"
+	EventUserRoleChanged = "user.role_changed"
+	EventPolicyChanged   = "policy.changed"
+	EventUserLockedOut   = "user.locked_out"
+)
+
+// Events lists the event types webhooks can subscribe to
+var Events = []string{EventUserRegistered, EventUserRoleChanged, EventPolicyChanged, EventUserLockedOut}
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Request headers of a delivery. The signature is "t=<unix time>,v1=<hex>"
+// where v1 is the HMAC-SHA256 of "<unix time>.<body>" keyed with the
+// webhook secret, so receivers can also reject replayed deliveries. The
+// event ID stays the same across retries, for receivers to drop duplicates.
+const (
+	SignatureHeader = "X-IAM-Signature"
+	EventHeader     = "X-IAM-Event"
+	EventIDHeader   = "X-IAM-Event-ID"
+)
+
+// ErrUnknownEvent is returned for subscriptions to events that do not exist
+var ErrUnknownEvent = errors.New("unknown event")
+
+// Config holds the delivery settings
+type Config struct {
+	// MaxAttempts is the number of attempts before a delivery fails
+	MaxAttempts int
+	// Retries wait BaseDelay, doubling up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Timeout bounds a single attempt
+	Timeout time.Duration
+}
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Dispatcher queues and delivers events
+type Dispatcher struct {
+	db     *gorm.DB
+	client *http.Client
+	Config Config
+
+	// wake starts a delivery run as soon as events are queued
+	wake chan struct{}
+}
+
+// New creates a dispatcher. Deliveries are only attempted once Run is started.
+func New(st *store.Store, cfg Config) *Dispatcher {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 8
+	}
+	if cfg.BaseDelay == 0 {
+		cfg.BaseDelay = 30 * time.Second
+	}
+	if cfg.MaxDelay < cfg.BaseDelay {
+		cfg.MaxDelay = max(time.Hour, cfg.BaseDelay)
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	return &Dispatcher{
+		db: st.DB,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			// A redirect is not an acknowledgement
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Config: cfg,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// ParseEvents validates a subscription and returns it as stored
+func ParseEvents(events []string) (string, error) {
+	if len(events) == 0 {
+		return "", fmt.Errorf("%w: subscribe to at least one event or *", ErrUnknownEvent)
+	}
+	for _, event := range events {
+		if event != "*" && !slices.Contains(Events, event) {
+			return "", fmt.Errorf("%w: %q", ErrUnknownEvent, event)
+		}
+	}
+	return strings.Join(events, " "), nil
+}
+
+// Subscribed reports whether the stored subscription events includes event
+func Subscribed(events, event string) bool {
+	for _, subscribed := range strings.Fields(events) {
+		if subscribed == "*" || subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret returns a random signing secret
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header of body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Emit queues event for every active webhook subscribed to it
+func (d *Dispatcher) Emit(event string, data interface{}) error {
+	var hooks []store.Webhook
+	if err := d.db.Where("active = ?", true).Find(&hooks).Error; err != nil {
+		return err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(Payload{
+		ID:        hex.EncodeToString(id),
+		Type:      event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var deliveries []store.WebhookDelivery
+	for _, hook := range hooks {
+		if Subscribed(hook.Events, event) {
+			deliveries = append(deliveries, store.WebhookDelivery{
+				WebhookID:     hook.ID,
+				EventID:       hex.EncodeToString(id),
+				Event:         event,
+				Payload:       string(payload),
+				Status:        StatusPending,
+				NextAttemptAt: &now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := d.db.Create(&deliveries).Error; err != nil {
+		return err
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Redeliver queues a delivery again with a fresh set of attempts
+func (d *Dispatcher) Redeliver(delivery *store.WebhookDelivery) error {
+	now := time.Now()
+	delivery.Status = StatusPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = &now
+	if err := d.db.Save(delivery).Error; err != nil {
+		return err
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers queued events as they are emitted, checking for due
+// retries every interval
+func (d *Dispatcher) Run(interval time.Duration) {
+	for {
+		d.DeliverDue(time.Now())
+		select {
+		case <-d.wake:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// DeliverDue attempts every pending delivery due at now and returns the
+// number of attempts made
+func (d *Dispatcher) DeliverDue(now time.Time) int {
+	var due []store.WebhookDelivery
+	err := d.db.Where("status = ? AND next_attempt_at <= ?", StatusPending, now).
+		Order("id").Limit(100).Find(&due).Error
+	if err != nil {
+		log.Printf("Failed to load webhook deliveries: %v", err)
+		return 0
+	}
+
+	attempted := 0
+	for _, delivery := range due {
+		// Claim the delivery, so instances sharing the database do not
+		// both send it. The claim lapses if this instance stops meanwhile.
+		lease := now.Add(2 * d.Config.Timeout)
+		claim := d.db.Model(&store.WebhookDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at <= ?", delivery.ID, StatusPending, now).
+			Update("next_attempt_at", lease)
+		if claim.Error != nil || claim.RowsAffected != 1 {
+			continue
+		}
+
+		d.attempt(&delivery)
+		attempted++
+	}
+	return attempted
+}
+
+// attempt sends a delivery once and records the outcome
+func (d *Dispatcher) attempt(delivery *store.WebhookDelivery) {
+	var hook store.Webhook
+	err := d.db.First(&hook, delivery.WebhookID).Error
+	if err == nil && !hook.Active {
+		err = errors.New("webhook is disabled")
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode = 0
+	delivery.Error = ""
+	if err == nil {
+		delivery.ResponseCode, err = d.send(hook, delivery)
+	}
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		delivery.Status = StatusSucceeded
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= d.Config.MaxAttempts || !hook.Active:
+		delivery.Status = StatusFailed
+		delivery.Error = err.Error()
+		delivery.NextAttemptAt = nil
+	default:
+		next := now.Add(d.backoff(delivery.Attempts))
+		delivery.Error = err.Error()
+		delivery.NextAttemptAt = &next
+	}
+
+	if err := d.db.Save(delivery).Error; err != nil {
+		log.Printf("Failed to record webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// send posts a delivery to its webhook. Any 2xx response acknowledges it.
+func (d *Dispatcher) send(hook store.Webhook, delivery *store.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "iam-webhook")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(EventIDHeader, delivery.EventID)
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, time.Now().Unix(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the wait before the attempt after the given number of
+// failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.Config.BaseDelay
+	for i := 1; i < attempts && delay < d.Config.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.Config.MaxDelay)
+}